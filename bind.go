@@ -0,0 +1,99 @@
+package sflag
+
+import (
+	"flag"
+	"reflect"
+	"unicode/utf8"
+)
+
+// BoundFlag is a flag of a struct given to the bind function of
+// Parser.BindFlags, to register the fields to other flag libraries.
+type BoundFlag struct {
+	// Name is the first name longer than a letter, Shorthand the first
+	// single letter name. Name is the shorthand if there isn't a longer one.
+	Name      string
+	Shorthand string
+	// Aliases are the rest of the names.
+	Aliases []string
+	Usage   string
+	// Type labels the value in help, it is "bool" for bool flags.
+	Type    string
+	Default string
+	Hidden  bool
+	// Deprecated is the message of the deprecated tag.
+	Deprecated string
+	// Value sets the field.
+	Value flag.Value
+}
+
+// IsBool reports whether the flag takes no value argument.
+func (f BoundFlag) IsBool() bool {
+	bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
+
+// BindFlags calls bind with the flags of the struct flagsPtr points to, which
+// are set to the values of the environment, the config or the default in the
+// order of Parser.Precedence first. It returns the first error of bind.
+func (p *Parser) BindFlags(flagsPtr interface{}, bind func(f BoundFlag) error) error {
+	flags := p.newCommandFlags("")
+	fields := p.defineFlags(flags, flag.NewFlagSet("", flag.ContinueOnError), flagsPtr)
+	if fields.err != nil {
+		return fields.err
+	}
+	for _, value := range fields.values {
+		value.apply(p.precedence())
+	}
+	for _, f := range flags.flags {
+		bf := BoundFlag{
+			Usage:      f.Usage,
+			Type:       f.Type,
+			Hidden:     f.Hidden,
+			Deprecated: f.value.deprecated,
+			Value:      f.value.value,
+		}
+		if defs := f.value.values[DefaultSource]; len(defs) > 0 {
+			bf.Default = defs[0]
+		}
+		if f.value.field.Kind() == reflect.Bool {
+			bf.Type = "bool"
+		}
+		for _, name := range f.value.names {
+			switch {
+			case bf.Shorthand == "" && utf8.RuneCountInString(name) == 1:
+				bf.Shorthand = name
+			case bf.Name == "" && utf8.RuneCountInString(name) > 1:
+				bf.Name = name
+			default:
+				bf.Aliases = append(bf.Aliases, name)
+			}
+		}
+		if bf.Name == "" {
+			bf.Name = bf.Shorthand
+		}
+		if err := bind(bf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetNonFlags sets the non-flag fields of the struct flagsPtr points to from
+// args, the same as parsing the non-flag arguments does. It is for the flags
+// parsed by other libraries, see Parser.BindFlags.
+func SetNonFlags(flagsPtr interface{}, args []string) error {
+	p := &Parser{noValidators: true}
+	fields := p.defineFlags(p.newCommandFlags(""), flag.NewFlagSet("", flag.ContinueOnError), flagsPtr)
+	args, terminated := fields.splitTerminator(args)
+	consumed, err := fields.setNonFlags(args)
+	if err != nil {
+		return err
+	}
+	if consumed < len(args) {
+		return fields.nonFlagsError(args[consumed:])
+	}
+	if err := fields.checkNonFlagSlice(args); err != nil {
+		return err
+	}
+	return fields.setTerminated(terminated)
+}
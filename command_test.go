@@ -0,0 +1,945 @@
+package sflag
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stubExit makes osExit record the exit codes until the returned func is
+// called.
+func stubExit() (codes *[]int, restore func()) {
+	codes = new([]int)
+	osExit = func(code int) { *codes = append(*codes, code) }
+	return codes, func() { osExit = os.Exit }
+}
+
+func TestRunCommandE(t *testing.T) {
+	var out bytes.Buffer
+	p := &Parser{Usage: func(printDefaults func(w io.Writer)) { printDefaults(&out) }}
+	errBoom := errors.New("boom")
+	var ran []string
+	commands := []Command{{
+		Name:  "serve",
+		Usage: "serve files",
+		Run:   func([]string) { ran = append(ran, "Run") },
+		RunE: func([]string) error {
+			ran = append(ran, "RunE")
+			return errBoom
+		},
+	}}
+	if err := p.RunCommandE([]string{"prog", "serve"}, nil, commands...); err != errBoom {
+		t.Errorf("RunCommandE = %v, want the error of RunE", err)
+	}
+	if len(ran) != 1 || ran[0] != "RunE" {
+		t.Errorf("ran %q, want only RunE", ran)
+	}
+
+	var serve struct{ Port int }
+	commands[0].Flags = &serve
+	commands[0].RunE = func([]string) error { return ErrHelp }
+	if err := p.RunCommandE([]string{"prog", "serve"}, nil, commands...); err != ErrHelp {
+		t.Errorf("RunCommandE = %v, want ErrHelp", err)
+	}
+	checkHelp(t, out.String(), `Usage: prog serve [OPTION]
+
+serve files
+
+Options:
+  -port     int
+  -h/-help
+            show this help
+`)
+}
+
+func TestCommandOptional(t *testing.T) {
+	var global struct {
+		Verbose bool `name:"v"`
+	}
+	commands := []Command{{Name: "serve", Usage: "serve files", Run: func([]string) { t.Error("serve ran") }}}
+	cmd, args, err := (&Parser{CommandOptional: true}).ParseCommand([]string{"prog", "-v"}, &global, commands...)
+	if err != nil || cmd.Name != "" || args != nil || !global.Verbose {
+		t.Errorf("ParseCommand = %+v, %q, %v, global %+v", cmd, args, err, global)
+	}
+	if _, _, err := ParseCommand([]string{"prog", "-v"}, &global, commands...); err == nil || err.Error() != "no command to be run" {
+		t.Errorf("ParseCommand = %v, want no command to be run", err)
+	}
+
+	var got interface{}
+	p := &Parser{CommandOptional: true, NoCommand: func(globalFlags interface{}) { got = globalFlags }}
+	if err := p.RunCommandE([]string{"prog"}, &global, commands...); err != nil || got != &global {
+		t.Errorf("RunCommandE = %v, NoCommand got %v", err, got)
+	}
+	if err := p.RunCommandE([]string{"prog", "serv"}, &global, commands...); err == nil {
+		t.Error("RunCommandE of an unknown command succeeded")
+	}
+
+	var out bytes.Buffer
+	p = &Parser{CommandOptional: true, Output: &out}
+	if err := p.RunCommandE([]string{"prog"}, nil, commands...); err != nil {
+		t.Fatal(err)
+	}
+	checkHelp(t, out.String(), `Usage: prog [COMMAND [ARGUMENT]...]
+
+Commands:
+  serve  serve files
+  help   show help of the program or a command
+`)
+}
+
+func TestRunHooks(t *testing.T) {
+	var calls []string
+	record := func(name string, err error) func([]string) error {
+		return func([]string) error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+	p := &Parser{
+		PreRun: func(cmd Command, _ interface{}, _ []string) error {
+			calls = append(calls, "Parser.PreRun "+cmd.Name)
+			return nil
+		},
+		PostRun: func(cmd Command, _ interface{}, _ []string) error {
+			calls = append(calls, "Parser.PostRun "+cmd.Name)
+			return nil
+		},
+	}
+	cmd := Command{
+		Name:    "serve",
+		PreRun:  record("PreRun", nil),
+		RunE:    record("RunE", nil),
+		PostRun: record("PostRun", nil),
+	}
+	if err := p.RunCommandE([]string{"prog", "serve"}, nil, cmd); err != nil {
+		t.Fatalf("RunCommandE: %v", err)
+	}
+	want := "Parser.PreRun serve,PreRun,RunE,PostRun,Parser.PostRun serve"
+	if got := strings.Join(calls, ","); got != want {
+		t.Errorf("calls %s, want %s", got, want)
+	}
+
+	calls = nil
+	errPre := errors.New("pre")
+	cmd.PreRun = record("PreRun", errPre)
+	if err := p.RunCommandE([]string{"prog", "serve"}, nil, cmd); err != errPre {
+		t.Errorf("RunCommandE = %v, want the error of PreRun", err)
+	}
+	if want := "Parser.PreRun serve,PreRun"; strings.Join(calls, ",") != want {
+		t.Errorf("calls %s, want %s", strings.Join(calls, ","), want)
+	}
+}
+
+type ctxKey struct{}
+
+func TestRunCommandContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), ctxKey{}, "v")
+	var got []interface{}
+	cmd := Command{
+		Name: "serve",
+		Run:  func([]string) { got = append(got, "Run") },
+		RunE: func([]string) error {
+			got = append(got, "RunE")
+			return nil
+		},
+		RunCtx: func(ctx context.Context, _ []string) error {
+			got = append(got, ctx.Value(ctxKey{}))
+			return nil
+		},
+	}
+	(&Parser{}).RunCommandContext(ctx, []string{"prog", "serve"}, nil, cmd)
+	if len(got) != 1 || got[0] != "v" {
+		t.Errorf("handlers got %v, want only RunCtx with the context given", got)
+	}
+
+	got = nil
+	if err := (&Parser{}).RunCommandE([]string{"prog", "serve"}, nil, cmd); err != nil {
+		t.Fatalf("RunCommandE: %v", err)
+	}
+	if len(got) != 1 || got[0] != nil {
+		t.Errorf("handlers got %v, want RunCtx with the background context", got)
+	}
+}
+
+type codeError struct{}
+
+func (codeError) Error() string { return "partial failure" }
+func (codeError) ExitCode() int { return 3 }
+
+func TestRunCommandExitCodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		usage   int
+		err     error
+		code    int
+		printed string
+	}{
+		{"success", []string{"prog", "run"}, 0, nil, -1, ""},
+		{"help", []string{"prog", "-h"}, 0, nil, 0, ""},
+		{"error", []string{"prog", "run"}, 0, errors.New("failed"), 1, "failed\n"},
+		{"ExitCode", []string{"prog", "run"}, 0, codeError{}, 3, "partial failure\n"},
+		{"wrapped ExitCode", []string{"prog", "run"}, 0, fmt.Errorf("run: %w", codeError{}), 3, "run: partial failure\n"},
+		{"ExitError", []string{"prog", "run"}, 0, ExitError{Code: 4, Err: errors.New("failed")}, 4, "failed\n"},
+		{"silent ExitError", []string{"prog", "run"}, 0, ExitError{Code: 5}, 5, ""},
+		{"usage", []string{"prog", "-x"}, 0, nil, 2, ""},
+		{"UsageExitCode", []string{"prog", "-x"}, 64, nil, 64, ""},
+		{"ErrHelp of the handler", []string{"prog", "run"}, 0, ErrHelp, 0, ""},
+	}
+	for _, tt := range tests {
+		var errOut bytes.Buffer
+		codes, restore := stubExit()
+		p := &Parser{Usage: func(func(w io.Writer)) {}, UsageExitCode: tt.usage, ErrOutput: &errOut}
+		runErr := tt.err
+		p.RunCommand(tt.args, nil, Command{Name: "run", RunE: func([]string) error { return runErr }})
+		restore()
+		if tt.code < 0 {
+			if len(*codes) != 0 {
+				t.Errorf("%s: exit codes %v, want none", tt.name, *codes)
+			}
+			continue
+		}
+		if len(*codes) != 1 || (*codes)[0] != tt.code {
+			t.Errorf("%s: exit codes %v, want [%d]", tt.name, *codes, tt.code)
+		}
+		if tt.printed != "" && errOut.String() != tt.printed {
+			t.Errorf("%s: error output %q, want %q", tt.name, errOut.String(), tt.printed)
+		}
+		if tt.printed == "" && tt.err != nil && errOut.Len() != 0 {
+			t.Errorf("%s: error output %q, want none", tt.name, errOut.String())
+		}
+	}
+}
+
+func TestGlobalFlagsAnywhere(t *testing.T) {
+	type global struct {
+		V     bool
+		Level string
+		Out   string
+	}
+	type build struct {
+		Out  string
+		Jobs int
+		Args []string `name:"#"`
+	}
+	tests := []struct {
+		args   []string
+		global global
+		build  build
+		run    []string
+		err    string
+	}{
+		{
+			args:   []string{"prog", "build", "-v", "./..."},
+			global: global{V: true},
+			build:  build{Args: []string{"./..."}},
+			run:    []string{"build", "./..."},
+		},
+		{
+			// -out is the flag of the command, the flags end at ./...
+			args:   []string{"prog", "build", "-level", "debug", "-out", "x", "-jobs", "2", "./...", "-v"},
+			global: global{Level: "debug"},
+			build:  build{Out: "x", Jobs: 2, Args: []string{"./...", "-v"}},
+			run:    []string{"build", "-out", "x", "-jobs", "2", "./...", "-v"},
+		},
+		{
+			args:   []string{"prog", "build", "-level=info", "--", "-v"},
+			global: global{Level: "info"},
+			build:  build{Args: []string{"-v"}},
+			run:    []string{"build", "--", "-v"},
+		},
+		{
+			args:   []string{"prog", "run", "-v", "-level", "warn", "a"},
+			global: global{V: true, Level: "warn"},
+			run:    []string{"run", "a"},
+		},
+		{
+			args: []string{"prog", "build", "-level"},
+			err:  "flag needs an argument: -level",
+		},
+	}
+	for _, tt := range tests {
+		var g global
+		var b build
+		var run []string
+		handler := func(args []string) error {
+			run = args
+			return nil
+		}
+		p := &Parser{GlobalFlagsAnywhere: true}
+		err := p.RunCommandE(tt.args, &g,
+			Command{Name: "build", Flags: &b, RunE: handler},
+			Command{Name: "run", RunE: handler})
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%q: error %v, want %s", tt.args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(g, tt.global) || !reflect.DeepEqual(b, tt.build) || !reflect.DeepEqual(run, tt.run) {
+			t.Errorf("%q: got %+v %+v %q, want %+v %+v %q", tt.args, g, b, run, tt.global, tt.build, tt.run)
+		}
+	}
+}
+
+func TestAfterTerminatorWithCommands(t *testing.T) {
+	var global struct {
+		Verbose bool     `name:"v"`
+		Args    []string `name:"#ARGS" after-terminator:"true"`
+	}
+	var cmdArgs []string
+	commands := []Command{{Name: "run", Run: func(args []string) { cmdArgs = args }}}
+	if err := RunCommandE([]string{"prog", "-v", "run", "a", "--", "x", "-y"}, &global, commands...); err != nil {
+		t.Fatal(err)
+	}
+	if !global.Verbose || !reflect.DeepEqual(global.Args, []string{"x", "-y"}) || !reflect.DeepEqual(cmdArgs, []string{"run", "a"}) {
+		t.Errorf("global %+v, command args %q", global, cmdArgs)
+	}
+	checkHelp(t, helpOutput(t, &Parser{}, []string{"prog", "-h"}, &global), `Usage: prog [OPTION] [-- ARGS...]
+
+Options:
+  -v
+  ARGS      string
+  -h/-help
+            show this help
+`)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("a non-flag slice without after-terminator is allowed with commands")
+		}
+	}()
+	var ambiguous struct {
+		Args []string `name:"#ARGS"`
+	}
+	_ = RunCommandE([]string{"prog", "run"}, &ambiguous, commands...)
+}
+
+func TestProgramName(t *testing.T) {
+	var serve struct{ Port int }
+	commands := []Command{{Name: "serve", Usage: "serve files", Flags: &serve, Run: func([]string) {}}}
+	for _, c := range []struct {
+		p    *Parser
+		want string
+	}{
+		{&Parser{Name: "myprog"}, "myprog"},
+		{&Parser{TrimProgramPath: true}, "myprog-v1.2.3"},
+		{&Parser{Name: "myprog", TrimProgramPath: true}, "myprog"},
+		{&Parser{}, "/usr/local/bin/myprog-v1.2.3"},
+	} {
+		var out bytes.Buffer
+		c.p.Output = &out
+		if err := c.p.RunCommandE([]string{"/usr/local/bin/myprog-v1.2.3", "help"}, nil, commands...); err != ErrHelp {
+			t.Fatalf("RunCommandE = %v, want ErrHelp", err)
+		}
+		checkHelp(t, out.String(), `Usage: `+c.want+` COMMAND [ARGUMENT]...
+
+Commands:
+  serve  serve files
+  help   show help of the program or a command
+`)
+		out.Reset()
+		if err := c.p.RunCommandE([]string{"/usr/local/bin/myprog-v1.2.3", "serve", "-h"}, nil, commands...); err != ErrHelp {
+			t.Fatalf("RunCommandE = %v, want ErrHelp", err)
+		}
+		checkHelp(t, out.String(), `Usage: `+c.want+` serve [OPTION]
+
+serve files
+
+Options:
+  -port     int
+  -h/-help
+            show this help
+`)
+	}
+}
+
+func TestRunOSArgs(t *testing.T) {
+	args := os.Args
+	defer func() { os.Args = args }()
+
+	var flags struct{ Port int }
+	os.Args = []string{"prog", "-port=80"}
+	if err := ParseArgs(&flags); err != nil || flags.Port != 80 {
+		t.Errorf("ParseArgs = %v, %d, want 80", err, flags.Port)
+	}
+
+	flags.Port = 0
+	var ran []string
+	codes, restore := stubExit()
+	defer restore()
+	os.Args = []string{"prog", "-port=81", "serve", "a"}
+	Run(&flags, Command{Name: "serve", Run: func(args []string) { ran = args }})
+	if flags.Port != 81 || !reflect.DeepEqual(ran, []string{"serve", "a"}) || len(*codes) != 0 {
+		t.Errorf("Run = %d, ran %q, exit codes %v, want 81 and serve run", flags.Port, ran, *codes)
+	}
+
+	os.Args = []string{"prog", "-port=x"}
+	(&Parser{ErrOutput: &bytes.Buffer{}}).Run(&flags)
+	if len(*codes) != 1 || (*codes)[0] != 2 {
+		t.Errorf("Run exit codes %v, want [2]", *codes)
+	}
+}
+
+func TestPassthrough(t *testing.T) {
+	type options struct {
+		Image string   `name:"image"`
+		Dir   string   `name:"#DIR"`
+		Extra []string `name:"#EXTRA"`
+		Rest  []string `name:"#REST" passthrough:"true" usage:"the command to run"`
+	}
+	var flags options
+	if err := Parse([]string{"prog", "-image", "foo", "dir", "a", "--", "/bin/sh", "-c", "echo hi", "--"}, &flags); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := options{Image: "foo", Dir: "dir", Extra: []string{"a"}, Rest: []string{"/bin/sh", "-c", "echo hi", "--"}}
+	if !reflect.DeepEqual(flags, want) {
+		t.Errorf("Parse = %+v, want %+v", flags, want)
+	}
+
+	flags = options{}
+	if err := Parse([]string{"prog", "dir", "a"}, &flags); err != nil || flags.Rest != nil {
+		t.Errorf("Parse = %v, passthrough %#v, want nil without --", err, flags.Rest)
+	}
+	checkHelp(t, helpOutput(t, &Parser{}, []string{"prog", "-h"}, &flags), `Usage: prog [OPTION] DIR [EXTRA...] [-- REST...]
+
+Options:
+  -image    string
+  DIR       string
+  EXTRA     string
+  REST      string
+            the command to run
+  -h/-help
+            show this help
+`)
+
+	var global struct {
+		Rest []string `name:"#REST" passthrough:"true"`
+	}
+	commands := []Command{{Name: "run"}, {Name: "stop"}}
+	cmd, cmdArgs, err := ParseCommand([]string{"prog", "run", "x", "--", "stop"}, &global, commands...)
+	if err != nil || cmd.Name != "run" || !reflect.DeepEqual(cmdArgs, []string{"run", "x"}) || !reflect.DeepEqual(global.Rest, []string{"stop"}) {
+		t.Errorf("ParseCommand = %s, %q, %v, passthrough %q", cmd.Name, cmdArgs, err, global.Rest)
+	}
+}
+
+func TestDashArgument(t *testing.T) {
+	var single struct {
+		Verbose bool   `name:"v"`
+		File    string `name:"#FILE"`
+	}
+	if err := Parse([]string{"prog", "-"}, &single); err != nil || single.File != "-" {
+		t.Errorf("Parse = %v, file %q, want -", err, single.File)
+	}
+	single.File = ""
+	if err := Parse([]string{"prog", "-v", "-"}, &single); err != nil || single.File != "-" || !single.Verbose {
+		t.Errorf("Parse = %v, flags %+v, want -v and the file -", err, single)
+	}
+
+	var files struct {
+		Number bool     `name:"n"`
+		Files  []string `name:"#FILES"`
+	}
+	if err := Parse([]string{"prog", "-n", "a", "-", "b"}, &files); err != nil || !reflect.DeepEqual(files.Files, []string{"a", "-", "b"}) {
+		t.Errorf("Parse = %v, files %q", err, files.Files)
+	}
+	var stdin struct {
+		Files []string `name:"#FILES" after-terminator:"true"`
+	}
+	stdin.Files = []string{"-"}
+	if args, err := MarshalArgs(&stdin); err != nil || !reflect.DeepEqual(args, []string{"--", "-"}) {
+		t.Errorf("MarshalArgs = %q, %v", args, err)
+	}
+	files.Files = []string{"-"}
+	if args, err := MarshalArgs(&files); err != nil || !reflect.DeepEqual(args, []string{"-n=true", "-"}) {
+		t.Errorf("MarshalArgs = %q, %v, want - without --", args, err)
+	}
+
+	var global struct {
+		Verbose bool `name:"v"`
+	}
+	commands := []Command{{Name: "cat"}}
+	cmd, cmdArgs, err := ParseCommand([]string{"prog", "-v", "cat", "-"}, &global, commands...)
+	if err != nil || cmd.Name != "cat" || !reflect.DeepEqual(cmdArgs, []string{"cat", "-"}) {
+		t.Errorf("ParseCommand = %s, %q, %v", cmd.Name, cmdArgs, err)
+	}
+	p := &Parser{ErrOutput: ioutil.Discard, GlobalFlagsAnywhere: true}
+	cmd, cmdArgs, err = p.ParseCommand([]string{"prog", "cat", "-", "-v"}, &global, commands...)
+	if err != nil || !reflect.DeepEqual(cmdArgs, []string{"cat", "-", "-v"}) {
+		t.Errorf("ParseCommand = %s, %q, %v, want the flags after - left to the command", cmd.Name, cmdArgs, err)
+	}
+	_, _, err = p.ParseCommand([]string{"prog", "-"}, &global, commands...)
+	if want := "unknown command: -"; err == nil || err.Error() != want {
+		t.Errorf("ParseCommand = %v, want %q", err, want)
+	}
+}
+
+func TestCommandArgs(t *testing.T) {
+	var push struct {
+		Force bool     `name:"f"`
+		Refs  []string `name:"#REF"`
+	}
+	var ran []string
+	run := func(args []string) { ran = args }
+	commands := []Command{
+		{Name: "add", Args: RangeArgs(1, 2), Run: run},
+		{Name: "rm", Args: ExactArgs(1), Run: run},
+		{Name: "ls", Args: NoArgs, Run: run},
+		{Name: "cat", Args: MinimumArgs(1), Run: run},
+		{Name: "push", Flags: &push, Args: ExactArgs(1), Run: run},
+		{Name: "tag", Args: func(args []string) error { return errors.New("bad tag") }, Run: run},
+	}
+	for _, c := range []struct {
+		args  []string
+		err   string
+		usage string
+	}{
+		{args: []string{"add", "a", "b"}},
+		{args: []string{"add", "a", "b", "c", "d"}, err: `command "add" accepts between 1 and 2 arguments, got 4`, usage: "Usage: prog add\n"},
+		{args: []string{"rm"}, err: `command "rm" accepts 1 argument, got 0`},
+		{args: []string{"ls", "x"}, err: `command "ls" accepts no arguments, got 1`},
+		{args: []string{"cat", "a", "b", "c"}},
+		{args: []string{"cat"}, err: `command "cat" accepts at least 1 argument, got 0`},
+		{args: []string{"push", "-f", "origin"}},
+		{args: []string{"push", "-f"}, err: `command "push" accepts 1 argument, got 0`, usage: "Usage: prog push [OPTION] [REF...]\n"},
+		{args: []string{"tag", "v1"}, err: `command "tag": bad tag`},
+		{args: []string{"help", "add"}, err: "flag: help requested"},
+		{args: []string{"add", "-h"}, err: "flag: help requested"},
+	} {
+		ran = nil
+		var errOut bytes.Buffer
+		p := &Parser{Output: ioutil.Discard, ErrOutput: &errOut}
+		err := p.RunCommandE(append([]string{"prog"}, c.args...), nil, commands...)
+		switch {
+		case c.err == "":
+			if err != nil || !reflect.DeepEqual(ran, c.args) {
+				t.Errorf("RunCommandE(%q) = %v, ran %q", c.args, err, ran)
+			}
+		case err == nil || err.Error() != c.err || ran != nil:
+			t.Errorf("RunCommandE(%q) = %v, ran %q, want %q", c.args, err, ran, c.err)
+		case c.usage != "" && errOut.String() != c.usage:
+			t.Errorf("RunCommandE(%q) printed %q, want %q", c.args, errOut.String(), c.usage)
+		}
+	}
+}
+
+func TestCommandResolvers(t *testing.T) {
+	var ran string
+	command := func(name string) Command {
+		return Command{Name: name, Run: func(args []string) { ran = strings.Join(args, " ") }}
+	}
+	commands := []Command{command("start"), command("status"), command("stop"), command("remove"), command("debug")}
+	commands[4].Hidden = true
+	resolver := ChainResolvers(AliasResolver(map[string]string{"rm": "remove", "st": "status"}), PrefixResolver)
+	for _, c := range []struct {
+		args []string
+		ran  string
+		err  string
+	}{
+		{args: []string{"stat", "-x"}, ran: "status -x"},
+		{args: []string{"star"}, ran: "start"},
+		{args: []string{"sto", "now"}, ran: "stop now"},
+		{args: []string{"rem"}, ran: "remove"},
+		{args: []string{"rm", "a"}, ran: "remove a"},
+		// the alias wins over the ambiguous prefix
+		{args: []string{"st"}, ran: "status"},
+		{args: []string{"sta"}, err: "unknown command: sta (did you mean start or status?)"},
+		{args: []string{"s"}, err: "unknown command: s"},
+		// hidden commands run by their full names only
+		{args: []string{"deb"}, err: "unknown command: deb"},
+		{args: []string{"debug"}, ran: "debug"},
+		{args: []string{"x"}, err: "unknown command: x"},
+	} {
+		ran = ""
+		p := &Parser{CommandResolver: resolver, ErrOutput: ioutil.Discard}
+		err := p.RunCommandE(append([]string{"prog"}, c.args...), nil, commands...)
+		if c.err == "" && (err != nil || ran != c.ran) || c.err != "" && (err == nil || err.Error() != c.err) {
+			t.Errorf("RunCommandE(%q) = %v, ran %q, want %q %q", c.args, err, ran, c.ran, c.err)
+		}
+	}
+
+	args := []string{"sto", "now"}
+	if resolved, ok := PrefixResolver(args, commands); !ok || !reflect.DeepEqual(resolved, []string{"stop", "now"}) || args[0] != "sto" {
+		t.Errorf("PrefixResolver(%q) = %q, %v", args, resolved, ok)
+	}
+}
+
+func TestCommandGroups(t *testing.T) {
+	run := func([]string) {}
+	commands := []Command{
+		{Name: "version", Usage: "print the version", Run: run},
+		{Name: "build", Usage: "compile packages", Group: "Build", Run: run},
+		{Name: "user", Usage: "manage users", Group: "Admin", Run: run},
+		{Name: "test", Usage: "test packages", Group: "Build", Run: run},
+		{Name: "prune", Usage: "prune caches", Group: "Admin", Hidden: true, Run: run},
+		{Name: "env", Usage: "print the environment", Run: run},
+	}
+	var out bytes.Buffer
+	if err := (&Parser{Output: &out}).RunCommandE([]string{"prog", "-h"}, nil, commands...); err != ErrHelp {
+		t.Fatalf("RunCommandE = %v, want ErrHelp", err)
+	}
+	// ungrouped commands first, then the groups in order of appearance
+	checkHelp(t, out.String(), `Usage: prog COMMAND [ARGUMENT]...
+
+Commands:
+  version  print the version
+  env      print the environment
+  help     show help of the program or a command
+
+Build Commands:
+  build    compile packages
+  test     test packages
+
+Admin Commands:
+  user     manage users
+`)
+}
+
+func TestCommandUsageFunc(t *testing.T) {
+	var serve struct {
+		Addr string `name:"addr" usage:"listen address"`
+	}
+	var out bytes.Buffer
+	commands := []Command{
+		{Name: "serve", Usage: "run the server", Flags: &serve, Run: func([]string) {}, UsageFunc: func(printDefaults func(w io.Writer)) {
+			fmt.Fprintln(&out, "serve usage")
+			printDefaults(&out)
+		}},
+	}
+	for _, args := range [][]string{{"serve", "-h"}, {"help", "serve"}} {
+		out.Reset()
+		p := &Parser{Output: ioutil.Discard}
+		if err := p.RunCommandE(append([]string{"prog"}, args...), nil, commands...); err != ErrHelp {
+			t.Errorf("RunCommandE(%q) = %v, want ErrHelp", args, err)
+		}
+		checkHelp(t, out.String(), `serve usage
+Usage: prog serve [OPTION]
+
+run the server
+
+Options:
+  -addr     string
+            listen address
+  -h/-help
+            show this help
+`)
+	}
+}
+
+func TestCommandHelpGlobalOptions(t *testing.T) {
+	var globals struct {
+		Config  string `name:"config" env:"APP_CONFIG" default:"app.json" usage:"config file"`
+		Verbose bool   `name:"v" usage:"verbose output"`
+		Debug   bool   `name:"debug" hidden:"true"`
+	}
+	var serve struct {
+		Addr string `name:"addr" default:":8080" usage:"listen address"`
+	}
+	commands := []Command{
+		{Name: "serve", Usage: "run the server", Flags: &serve, Run: func([]string) {}},
+		{Name: "version", Usage: "print the version", Run: func([]string) {}},
+	}
+	const serveHelp = `Usage: prog serve [OPTION]
+
+run the server
+
+Options:
+  -addr     string (default: ":8080")
+            listen address
+  -h/-help
+            show this help
+
+Global options:
+  -config   string (default: "app.json", env: APP_CONFIG)
+            config file
+  -v
+            verbose output
+`
+	const versionHelp = `Usage: prog version
+
+print the version
+
+Global options:
+  -config   string (default: "app.json", env: APP_CONFIG)
+            config file
+  -v
+            verbose output
+`
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"serve", "-h"}, serveHelp},
+		{[]string{"help", "serve"}, serveHelp},
+		{[]string{"-v", "version", "-help"}, versionHelp},
+	} {
+		var out bytes.Buffer
+		p := &Parser{Output: &out}
+		if err := p.RunCommandE(append([]string{"prog"}, c.args...), &globals, commands...); err != ErrHelp {
+			t.Errorf("RunCommandE(%q) = %v, want ErrHelp", c.args, err)
+		}
+		checkHelp(t, out.String(), c.want)
+	}
+
+	// no globals, no section
+	var out bytes.Buffer
+	if err := (&Parser{Output: &out}).RunCommandE([]string{"prog", "serve", "-h"}, nil, commands...); err != ErrHelp {
+		t.Fatalf("RunCommandE = %v, want ErrHelp", err)
+	}
+	checkHelp(t, out.String(), `Usage: prog serve [OPTION]
+
+run the server
+
+Options:
+  -addr     string (default: ":8080")
+            listen address
+  -h/-help
+            show this help
+`)
+}
+
+type serveCmd struct {
+	Port int `name:"port" default:"8080" usage:"port to listen on"`
+	ran  *[]string
+}
+
+func (c *serveCmd) Run(args []string) error {
+	*c.ran = append(*c.ran, fmt.Sprint("serve ", c.Port, " ", args))
+	return nil
+}
+
+type remoteAddCmd struct {
+	URL  string   `name:"url" required:"true"`
+	Args []string `name:"#NAME"`
+	ran  *[]string
+}
+
+func (c *remoteAddCmd) Run(args []string) error {
+	*c.ran = append(*c.ran, fmt.Sprint("remote add ", c.URL, " ", c.Args))
+	return nil
+}
+
+type remoteCmd struct {
+	Verbose bool          `name:"v" usage:"verbose"`
+	Add     *remoteAddCmd `cmd:"add" usage:"add a remote"`
+}
+
+type structRoot struct {
+	Debug  bool      `name:"debug"`
+	Serve  serveCmd  `cmd:"serve" usage:"run the server"`
+	Remote remoteCmd `cmd:"remote" usage:"manage remotes"`
+	Old    serveCmd  `cmd:"old" hidden:"true"`
+}
+
+func TestRunStruct(t *testing.T) {
+	var ran []string
+	newRoot := func() *structRoot {
+		root := &structRoot{Remote: remoteCmd{Add: &remoteAddCmd{ran: &ran}}}
+		root.Serve.ran, root.Old.ran = &ran, &ran
+		return root
+	}
+	root := newRoot()
+	if err := RunStruct([]string{"prog", "-debug", "serve", "-port", "80"}, root); err != nil {
+		t.Fatal(err)
+	}
+	if !root.Debug || root.Serve.Port != 80 || !reflect.DeepEqual(ran, []string{"serve 80 [serve -port 80]"}) {
+		t.Errorf("got %+v, ran %q", root, ran)
+	}
+
+	ran = nil
+	root = newRoot()
+	if err := RunStruct([]string{"prog", "remote", "-v", "add", "-url", "git@x", "origin"}, root); err != nil {
+		t.Fatal(err)
+	}
+	if !root.Remote.Verbose || !reflect.DeepEqual(ran, []string{"remote add git@x [origin]"}) {
+		t.Errorf("got %+v, ran %q", root.Remote, ran)
+	}
+
+	var out bytes.Buffer
+	p := &Parser{Output: &out, ErrOutput: &out}
+	if err := p.RunStruct([]string{"prog", "remote", "-h"}, newRoot()); err != ErrHelp {
+		t.Fatalf("RunStruct(remote -h) = %v, want ErrHelp", err)
+	}
+	checkHelp(t, out.String(), `Usage: prog remote [OPTION] COMMAND [ARGUMENT]...
+
+Options:
+  -v
+            verbose
+  -h/-help
+            show this help
+
+Commands:
+  add   add a remote
+  help  show help of the program or a command
+`)
+	out.Reset()
+	if err := p.RunStruct([]string{"prog", "help", "remote"}, newRoot()); err != ErrHelp {
+		t.Fatalf("RunStruct(help remote) = %v, want ErrHelp", err)
+	}
+	checkHelp(t, out.String(), `Usage: prog remote [OPTION] COMMAND [ARGUMENT]...
+
+manage remotes
+
+Options:
+  -v
+            verbose
+  -h/-help
+            show this help
+
+Global options:
+  -debug
+
+Commands:
+  add   add a remote
+  help  show help of the program or a command
+`)
+	out.Reset()
+	err := p.RunStruct([]string{"prog", "remote", "add", "origin"}, newRoot())
+	if err == nil || !strings.Contains(err.Error(), "-url") {
+		t.Errorf("RunStruct without -url = %v", err)
+	}
+	codes, restore := stubExit()
+	defer restore()
+	p.handleError(err, false)
+	if !reflect.DeepEqual(*codes, []int{2}) {
+		t.Errorf("exit codes of a nested parse error = %v, want [2]", *codes)
+	}
+
+	commands := StructCommands(newRoot())
+	if subs, flags := commands[1].Subcommands(); len(subs) != 1 || subs[0].Name != "add" || flags == nil {
+		t.Errorf("Subcommands of remote = %+v, %v, want add", subs, flags)
+	}
+	if subs, flags := commands[0].Subcommands(); subs != nil || flags != nil {
+		t.Errorf("Subcommands of serve = %+v, %v, want nil", subs, flags)
+	}
+
+	// mixed with a Command
+	ran = nil
+	root = newRoot()
+	commands = append(StructCommands(root), Command{Name: "version", Run: func([]string) { ran = append(ran, "version") }})
+	for _, args := range [][]string{{"prog", "version"}, {"prog", "old"}} {
+		if err := (&Parser{}).RunCommandE(args, root, commands...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(ran, []string{"version", "serve 8080 [old]"}) {
+		t.Errorf("ran %q", ran)
+	}
+
+	for _, c := range []struct {
+		root interface{}
+		err  string
+	}{
+		{&struct {
+			Cmd serveCmd `cmd:""`
+		}{}, "empty cmd tag of field Cmd"},
+		{&struct {
+			Cmd struct{ Port int } `cmd:"x"`
+		}{}, "has no Run(args []string) error method"},
+		{&struct {
+			Cmd int `cmd:"x"`
+		}{}, "cmd field Cmd isn't a struct or pointer of struct"},
+		{&struct{ Port int }{}, "no cmd fields in"},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), c.err) {
+					t.Errorf("recovered %v, want %s", r, c.err)
+				}
+			}()
+			_ = RunStruct([]string{"prog"}, c.root)
+		}()
+	}
+}
+
+func TestCommandHelp(t *testing.T) {
+	var global struct {
+		V bool `name:"v" usage:"verbose"`
+	}
+	var serve struct {
+		Port int    `name:"port" default:"80" usage:"port to listen"`
+		Dir  string `name:"#DIR"`
+	}
+	commands := []Command{{Name: "serve", Usage: "serve files", Flags: &serve}}
+	for _, args := range [][]string{
+		{"prog", "serve", "-h"},
+		{"prog", "serve", "--help"},
+		{"prog", "serve", "help"},
+		{"prog", "help", "serve"},
+		{"prog", "-v", "serve", "-port", "8080", "-h"},
+	} {
+		var out bytes.Buffer
+		p := &Parser{Output: &out, ErrOutput: ioutil.Discard}
+		if _, _, err := p.ParseCommandFlags(args, &global, commands...); err != ErrHelp {
+			t.Fatalf("ParseCommandFlags(%q) = %v, want ErrHelp", args, err)
+		}
+		checkHelp(t, out.String(), `Usage: prog serve [OPTION] DIR
+
+serve files
+
+Options:
+  -port     int (default: 80)
+            port to listen
+  DIR       string
+  -h/-help
+            show this help
+
+Global options:
+  -v
+            verbose
+`)
+	}
+
+	// RunCommand exits as the help of the program does
+	codes, restore := stubExit()
+	defer restore()
+	commands[0].Run = func(args []string) { t.Errorf("serve ran with %q", args) }
+	(&Parser{Output: ioutil.Discard}).RunCommand([]string{"prog", "serve", "-h"}, &global, commands...)
+	if !reflect.DeepEqual(*codes, []int{0}) {
+		t.Errorf("exit codes = %v, want [0]", *codes)
+	}
+}
+
+func TestCommandFlags(t *testing.T) {
+	var global struct {
+		V bool `name:"v"`
+	}
+	var copyFlags struct {
+		Force bool     `name:"force,f"`
+		Mode  int      `name:"mode" default:"644"`
+		Src   string   `name:"#SRC"`
+		Dst   []string `name:"#DST" min:"1"`
+	}
+	var ran []string
+	commands := []Command{{Name: "cp", Flags: &copyFlags, Run: func(args []string) { ran = args }}}
+	p := &Parser{ErrOutput: ioutil.Discard}
+	if err := p.RunCommandE([]string{"prog", "-v", "cp", "-f", "a", "b", "c"}, &global, commands...); err != nil {
+		t.Fatal(err)
+	}
+	if !global.V || !copyFlags.Force || copyFlags.Mode != 644 || copyFlags.Src != "a" || !reflect.DeepEqual(copyFlags.Dst, []string{"b", "c"}) {
+		t.Errorf("global = %+v, cp = %+v", global, copyFlags)
+	}
+	// Run gets the arguments from the command name on, as without Flags
+	if want := []string{"cp", "-f", "a", "b", "c"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("Run got %q, want %q", ran, want)
+	}
+
+	// the flags of the command are its own
+	if err := p.RunCommandE([]string{"prog", "cp", "-v", "a", "b"}, &global, commands...); err == nil || err.Error() != "flag provided but not defined: -v" {
+		t.Errorf("RunCommandE = %v, want -v undefined for cp", err)
+	}
+	if err := p.RunCommandE([]string{"prog", "cp", "a"}, &global, commands...); err == nil || err.Error() != "expected at least 1 DST argument, got 0" {
+		t.Errorf("RunCommandE = %v, want DST missing", err)
+	}
+}
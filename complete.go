@@ -0,0 +1,165 @@
+package sflag
+
+import (
+	"flag"
+	"strings"
+)
+
+// Complete returns the completion candidates for the last element of args,
+// which are the words of the command line following the program name up to
+// and including the one under the cursor.
+//
+// Flags and command names are completed by the parser, and so are the values
+// of flags taking one of a few, such as slog.Level, positionals of a command
+// by Command.Complete. Candidates are deduplicated and filtered by
+// the word being completed; nil means the shell should fall back to
+// completing file names.
+func (p *Parser) Complete(args []string, globalFlags interface{}, commands ...Command) []string {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	words, toComplete := args[:len(args)-1], args[len(args)-1]
+
+	root := p.newCommandFlags("")
+	rootSet := p.completionFlagSet(root, globalFlags)
+	cmdIndex := -1
+	for i := 0; i < len(words) && len(commands) > 0; i++ {
+		if !isFlagArg(words[i]) {
+			cmdIndex = i
+			break
+		}
+		if takesValue(rootSet, words[i]) {
+			i++
+		}
+	}
+	if cmdIndex < 0 {
+		if values, ok := completeValue(rootSet, words, toComplete); ok {
+			return values
+		}
+		if strings.HasPrefix(toComplete, "-") || len(commands) == 0 {
+			return filterCandidates(flagNames(rootSet), toComplete)
+		}
+		var names []string
+		for _, cmd := range visibleCommands(commands) {
+			names = append(names, cmd.Name)
+		}
+		return filterCandidates(names, toComplete)
+	}
+
+	var cmd Command
+	for _, c := range commands {
+		if c.Name == words[cmdIndex] {
+			cmd = c
+			break
+		}
+	}
+	if cmd.Name == "" {
+		return nil
+	}
+	cmdSet := p.completionFlagSet(p.newSubcommandFlags("", cmd), cmd.Flags)
+	if values, ok := completeValue(cmdSet, words[cmdIndex+1:], toComplete); ok {
+		return values
+	}
+	if strings.HasPrefix(toComplete, "-") {
+		return filterCandidates(flagNames(cmdSet), toComplete)
+	}
+	if cmd.Complete == nil {
+		return nil
+	}
+	return filterCandidates(cmd.Complete(words[cmdIndex+1:], toComplete), toComplete)
+}
+
+// completionFlagSet defines the flags of flagsPtr on a copy of the struct, as
+// Describe does, so that completing neither changes the struct nor reads the
+// environment and the config.
+func (p *Parser) completionFlagSet(flags *commandFlags, flagsPtr interface{}) *flag.FlagSet {
+	fs := flag.NewFlagSet(flags.name, flag.ContinueOnError)
+	if flagsPtr != nil {
+		p.defineCopy(flags, fs, flagsPtr)
+	}
+	return fs
+}
+
+func isFlagArg(arg string) bool {
+	return len(arg) > 0 && arg[0] == '-' && arg != "-"
+}
+
+func takesValue(fs *flag.FlagSet, arg string) bool {
+	name := flagName(arg)
+	if strings.Contains(name, "=") {
+		return false
+	}
+	f := fs.Lookup(name)
+	if f == nil {
+		return false
+	}
+	bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !bf.IsBoolFlag()
+}
+
+// completeValue completes toComplete if it's the value of a flag of fs, given
+// as the word following the flag or after its "=", false if it isn't one.
+func completeValue(fs *flag.FlagSet, words []string, toComplete string) ([]string, bool) {
+	if i := strings.IndexByte(toComplete, '='); i >= 0 && isFlagArg(toComplete) {
+		if fs.Lookup(flagName(toComplete[:i])) == nil {
+			return nil, false
+		}
+		var candidates []string
+		for _, c := range flagChoices(fs, toComplete[:i]) {
+			candidates = append(candidates, toComplete[:i+1]+c)
+		}
+		return filterCandidates(candidates, toComplete), true
+	}
+	if len(words) == 0 || !takesValue(fs, words[len(words)-1]) {
+		return nil, false
+	}
+	return filterCandidates(flagChoices(fs, words[len(words)-1]), toComplete), true
+}
+
+// flagChoices returns the values the flag of arg takes if it's a
+// choiceLister.
+func flagChoices(fs *flag.FlagSet, arg string) []string {
+	f := fs.Lookup(flagName(arg))
+	if f == nil {
+		return nil
+	}
+	value := f.Value
+	switch v := value.(type) {
+	case *sourcedValue:
+		value = v.value
+	case *aliasValue:
+		value = v.value
+	}
+	if cl, ok := value.(choiceLister); ok {
+		return cl.Choices()
+	}
+	return nil
+}
+
+// flagName returns the name of the flag argument arg, less one or two dashes.
+func flagName(arg string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+}
+
+func flagNames(fs *flag.FlagSet) []string {
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		// aliases are deprecated
+		if _, ok := f.Value.(*aliasValue); !ok {
+			names = append(names, "-"+f.Name)
+		}
+	})
+	return names
+}
+
+func filterCandidates(candidates []string, prefix string) []string {
+	var filtered []string
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if !seen[c] && strings.HasPrefix(c, prefix) {
+			seen[c] = true
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
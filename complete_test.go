@@ -0,0 +1,59 @@
+package sflag
+
+import (
+	"reflect"
+	"testing"
+)
+
+type CompleteInner struct {
+	Retries int `name:"retries"`
+}
+
+func TestComplete(t *testing.T) {
+	type globals struct {
+		Verbose bool   `name:"verbose,v"`
+		Token   string `name:"token" env:"SFLAG_TEST_COMPLETE_TOKEN" default:"t"`
+		*CompleteInner
+	}
+	type pushFlags struct {
+		Force bool `name:"force"`
+	}
+	var (
+		global globals
+		push   pushFlags
+		got    [][]string
+	)
+	commands := []Command{
+		{Name: "push", Flags: &push, Complete: func(args []string, toComplete string) []string {
+			got = append(got, append(args, toComplete))
+			return []string{"origin", "upstream", "origin", "other"}
+		}},
+		{Name: "pull"},
+		{Name: "prune", Hidden: true},
+	}
+	for _, c := range []struct {
+		args []string
+		want []string
+	}{
+		{nil, []string{"push", "pull"}},
+		{[]string{"p"}, []string{"push", "pull"}},
+		{[]string{"-v", "pu"}, []string{"push", "pull"}},
+		{[]string{"-t"}, []string{"-token"}},
+		{[]string{"-token", "x", "-r"}, []string{"-retries"}},
+		{[]string{"push", "-"}, []string{"-force"}},
+		{[]string{"push", "o"}, []string{"origin", "other"}},
+		{[]string{"push", "-force", "origin", ""}, []string{"origin", "upstream", "other"}},
+		{[]string{"pull", ""}, nil},
+		{[]string{"unknown", ""}, nil},
+	} {
+		if got := (&Parser{LookupEnv: mapEnv(map[string]string{"SFLAG_TEST_COMPLETE_TOKEN": "secret"})}).Complete(c.args, &global, commands...); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Complete(%q) = %q, want %q", c.args, got, c.want)
+		}
+	}
+	if want := [][]string{{"o"}, {"-force", "origin", ""}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Command.Complete called with %q, want %q", got, want)
+	}
+	if global != (globals{}) || push != (pushFlags{}) {
+		t.Errorf("Complete changed the flags to %+v %+v, want them left alone", global, push)
+	}
+}
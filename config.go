@@ -0,0 +1,488 @@
+package sflag
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config maps flag names to the values loaded from config files. Keys of
+// nested objects are joined by "-", so {"server": {"port": 80}} sets the
+// flag -server-port. Values of arrays are applied to the flag one by one.
+type config map[string][]string
+
+// configFiles maps config keys to the files they are loaded from.
+type configFiles map[string]string
+
+func configKey(name string) string {
+	return strings.Replace(name, ".", "-", -1)
+}
+
+// configValues returns the config values of the flag of names, and the file
+// they are loaded from if any.
+func (c *commandFlags) configValues(names []string) ([]string, string) {
+	for _, name := range names {
+		key := configKey(c.configPrefix + name)
+		if values, ok := c.config[key]; ok {
+			c.configUsed[key] = true
+			return values, c.configFiles[key]
+		}
+	}
+	return nil, ""
+}
+
+// checkConfigKeys reports the config keys that matched no flags. Keys
+// prefixed by the name of a command are left to the command.
+func (p *Parser) checkConfigKeys(flags *commandFlags, commands []Command) error {
+	var unknown []string
+	for key := range flags.config {
+		if flags.configUsed[key] || !strings.HasPrefix(key, flags.configPrefix) {
+			continue
+		}
+		if hasCommandPrefix(key, commands) {
+			continue
+		}
+		unknown = append(unknown, key)
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	err := flags.tr.errorf(MsgUnknownConfigKeys, strings.Join(unknown, ", "))
+	if p.StrictConfig {
+		return err
+	}
+	fprintln(flags.errOutput, flags.tr(MsgWarning, err))
+	return nil
+}
+
+// configBool converts the yes/no/on/off of config files to the values
+// accepted by bool flags.
+func configBool(fval flag.Value, v string) string {
+	if bf, ok := fval.(interface{ IsBoolFlag() bool }); ok && bf.IsBoolFlag() {
+		switch strings.ToLower(v) {
+		case "yes", "on":
+			return "true"
+		case "no", "off":
+			return "false"
+		}
+	}
+	return v
+}
+
+func hasCommandPrefix(key string, commands []Command) bool {
+	for _, cmd := range commands {
+		if strings.HasPrefix(key, cmd.Name+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigDecoder decodes a config document into a map, it is the contract of
+// yaml.Unmarshal and alike. Keys of nested map[interface{}]interface{} are
+// converted to strings by fmt.Sprint.
+type ConfigDecoder func(data []byte) (map[string]interface{}, error)
+
+// LoadConfig loads the flag values of a config document of the given format
+// from r. The "json", "toml" and "ini" (or "conf") formats are built in, the
+// others are decoded by Parser.ConfigDecoder. By default the config values
+// override the defaults and are overridden by the environment and the command
+// line, see Parser.Precedence.
+func (p *Parser) LoadConfig(r io.Reader, format string) error {
+	doc, err := p.decodeConfig(r, format)
+	if err != nil {
+		return err
+	}
+	return p.addConfig(doc, "")
+}
+
+func (p *Parser) decodeConfig(r io.Reader, format string) (map[string]interface{}, error) {
+	switch strings.ToLower(format) {
+	case "json":
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		var doc map[string]interface{}
+		if err := dec.Decode(&doc); err != nil {
+			return nil, newErrorf("decode json config: %v", err)
+		}
+		return doc, nil
+	case "toml":
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return parseTOML(string(data))
+	case "ini", "conf":
+		return parseINI(r)
+	}
+	if p.ConfigDecoder == nil {
+		return nil, newErrorf("unsupported config format: %s", format)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := p.ConfigDecoder(data)
+	if err != nil {
+		return nil, newErrorf("decode %s config: %v", format, err)
+	}
+	return doc, nil
+}
+
+// LoadConfigFile loads the config file at path, the format is taken from
+// the file extension.
+func (p *Parser) LoadConfigFile(path string) error {
+	c, err := p.readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	p.mergeConfig(c, path)
+	return nil
+}
+
+// readConfigFile reads the config file at path, the format is taken from
+// the file extension.
+func (p *Parser) readConfigFile(path string) (config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, newErrorf("open config %s: %v", path, err)
+	}
+	defer f.Close()
+	doc, err := p.decodeConfig(f, strings.TrimPrefix(filepath.Ext(path), "."))
+	c := make(config)
+	if err == nil {
+		err = flattenConfig(c, "", doc)
+	}
+	if err != nil {
+		return nil, newErrorf("load config %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// loadConfigFile loads the config file at path for the parse of flags only,
+// over the config values of the parser.
+func (p *Parser) loadConfigFile(flags *commandFlags, path string) error {
+	c, err := p.readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	// copied, as the maps are the ones of the parser
+	merged := make(config, len(flags.config)+len(c))
+	files := make(configFiles, len(flags.configFiles)+len(c))
+	for key, values := range flags.config {
+		merged[key] = values
+		if file, ok := flags.configFiles[key]; ok {
+			files[key] = file
+		}
+	}
+	for key, values := range c {
+		merged[key] = values
+		files[key] = path
+	}
+	flags.config = merged
+	flags.configFiles = files
+	return nil
+}
+
+// loadConfigFlag loads the config file given by the field tagged with
+// `config:"true"`, taking its value from args, the environment or the
+// default in the order of Parser.Precedence, before the struct is parsed, so
+// the command line still overrides values of the file. The values are used by
+// this parse only.
+func (p *Parser) loadConfigFlag(flags *commandFlags, args []string, flagsPtr interface{}) error {
+	reft := reflect.TypeOf(flagsPtr)
+	if reft.Kind() != reflect.Ptr || reft.Elem().Kind() != reflect.Struct {
+		// left to defineFlags to panic
+		return nil
+	}
+	reft = reft.Elem()
+	var (
+		field reflect.StructField
+		found bool
+	)
+	for i := 0; i < reft.NumField(); i++ {
+		ftyp := reft.Field(i)
+		if isConfig, _ := strconv.ParseBool(ftyp.Tag.Get("config")); !isConfig {
+			continue
+		}
+		if found {
+			panic(newErrorf("duplicated config field: %s", ftyp.Name))
+		}
+		if ftyp.Type.Kind() != reflect.String {
+			panic(newErrorf("config field must be string: %s", ftyp.Name))
+		}
+		field, found = ftyp, true
+	}
+	if !found {
+		return nil
+	}
+	var (
+		path     string
+		supplied bool
+	)
+	for _, src := range p.precedence() {
+		switch src {
+		case FlagSource:
+			path, supplied = lookupArg(args, fieldNames(field), func(name, next string) bool {
+				for i := 0; i < reft.NumField(); i++ {
+					if ftyp := reft.Field(i); hasName(fieldNames(ftyp), name) {
+						return !isBoolFlagType(ftyp.Type)
+					}
+				}
+				return false
+			})
+		case EnvSource:
+			path, _ = flags.getenv(field.Tag.Get("env"))
+			supplied = path != ""
+		case DefaultSource:
+			path = field.Tag.Get("default")
+			supplied = path != ""
+		}
+		if supplied {
+			break
+		}
+	}
+	if path == "" {
+		return nil
+	}
+	return p.loadConfigFile(flags, path)
+}
+
+// fieldNames returns the flag names of the struct field.
+func fieldNames(field reflect.StructField) []string {
+	name := field.Tag.Get("name")
+	if name == "" {
+		name = defaultFlagName(field)
+	}
+	return splitAndTrim(name)
+}
+
+// isBoolFlagType reports whether the flags of type t take no value
+// argument, as the bool flags of the flag package.
+func isBoolFlagType(t reflect.Type) bool {
+	if t.Kind() == reflect.Bool {
+		return true
+	}
+	bf, ok := reflect.New(t).Interface().(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
+
+// lookupArg returns the last value of the flag of names in args, the flags
+// end at the first non-flag argument as the flag package parses them.
+// isValue reports whether next is the value of the flag of name given
+// without "=", it is skipped then.
+func lookupArg(args []string, names []string, isValue func(name, next string) bool) (value string, found bool) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || len(arg) < 2 || arg[0] != '-' {
+			break
+		}
+		name := strings.TrimPrefix(arg[1:], "-")
+		v, hasValue := "", false
+		if j := strings.IndexByte(name, '='); j >= 0 {
+			name, v, hasValue = name[:j], name[j+1:], true
+		}
+		if !hasValue && i+1 < len(args) && (hasName(names, name) || isValue(name, args[i+1])) {
+			i++
+			v, hasValue = args[i], true
+		}
+		if hasValue && hasName(names, name) {
+			value, found = v, true
+		}
+	}
+	return value, found
+}
+
+func hasName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Parser) addConfig(doc map[string]interface{}, path string) error {
+	c := make(config)
+	if err := flattenConfig(c, "", doc); err != nil {
+		return err
+	}
+	p.mergeConfig(c, path)
+	return nil
+}
+
+// mergeConfig adds the values of c loaded from the file at path, which is
+// empty if they aren't loaded from a file, to the parser.
+func (p *Parser) mergeConfig(c config, path string) {
+	if p.config == nil {
+		p.config = make(config)
+		p.configFiles = make(configFiles)
+	}
+	for key, values := range c {
+		p.config[key] = values
+		if path == "" {
+			delete(p.configFiles, key)
+		} else {
+			p.configFiles[key] = path
+		}
+	}
+}
+
+func flattenConfig(c config, prefix string, doc map[string]interface{}) error {
+	for k, v := range doc {
+		key := configKey(prefix + k)
+		if m, ok := configMap(v); ok {
+			if err := flattenConfig(c, key+"-", m); err != nil {
+				return err
+			}
+			continue
+		}
+		values, err := configStrings(key, v)
+		if err != nil {
+			return err
+		}
+		if values != nil {
+			c[key] = values
+		}
+	}
+	return nil
+}
+
+func configMap(v interface{}) (map[string]interface{}, bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return v, true
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, elem := range v {
+			m[fmt.Sprint(k)] = elem
+		}
+		return m, true
+	}
+	return nil, false
+}
+
+func configStrings(key string, v interface{}) ([]string, error) {
+	if arr, ok := v.([]interface{}); ok {
+		values := make([]string, 0, len(arr))
+		for _, elem := range arr {
+			s, ok := configString(elem)
+			if !ok {
+				return nil, newErrorf("invalid config value for %s: %v", key, elem)
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	}
+	if v == nil {
+		return nil, nil
+	}
+	s, ok := configString(v)
+	if !ok {
+		return nil, newErrorf("invalid config value for %s: %v", key, v)
+	}
+	return []string{s}, nil
+}
+
+func configString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case time.Time:
+		return v.Format(time.RFC3339), true
+	case fmt.Stringer:
+		return v.String(), true
+	}
+	refv := reflect.ValueOf(v)
+	switch refv.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(refv.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(refv.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(refv.Uint(), 10), true
+	case reflect.Float32:
+		return strconv.FormatFloat(refv.Float(), 'f', -1, 32), true
+	case reflect.Float64:
+		return strconv.FormatFloat(refv.Float(), 'f', -1, 64), true
+	}
+	return "", false
+}
+
+// ConfigEncoder encodes a config document, it is the contract of
+// yaml.Marshal and alike.
+type ConfigEncoder func(doc map[string]interface{}) ([]byte, error)
+
+// WriteConfig writes the current values of the flags of flagsPtr to w as a
+// config document of format, which loads back to the same values. "json" is
+// built in, other formats are encoded by Parser.ConfigEncoder. Fields tagged
+// with `secret:"true"` or `hidden:"true"`, the config field and the non-flag
+// fields are omitted. Neither the environment nor the config is read, and the
+// struct is left alone.
+func (p *Parser) WriteConfig(w io.Writer, format string, flagsPtr interface{}) error {
+	flags := p.newCommandFlags("")
+	if fields := p.defineCopy(flags, flag.NewFlagSet("", flag.ContinueOnError), flagsPtr); fields.err != nil {
+		return fields.err
+	}
+	doc := make(map[string]interface{}, len(flags.flags))
+	for _, f := range flags.flags {
+		if f.Secret || f.Hidden || f.Config || f.value == nil {
+			continue
+		}
+		doc[configKey(f.value.names[0])] = configDocValue(f.value)
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	switch strings.ToLower(format) {
+	case "json":
+		data, err = json.MarshalIndent(doc, "", "  ")
+		data = append(data, '\n')
+	default:
+		if p.ConfigEncoder == nil {
+			return newErrorf("unsupported config format: %s", format)
+		}
+		data, err = p.ConfigEncoder(doc)
+	}
+	if err != nil {
+		return newErrorf("encode %s config: %v", format, err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// configDocValue returns the value of v as a bool or a number for the fields
+// of such kinds, the string of it otherwise.
+func configDocValue(v *sourcedValue) interface{} {
+	if _, ok := v.value.(*commonflagValue); !ok || v.field.Type() == durationType {
+		return v.value.String()
+	}
+	switch v.field.Kind() {
+	case reflect.Bool:
+		return v.field.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.field.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.field.Uint()
+	case reflect.Float32:
+		// encoded in 32 bits, not as the float64 it converts to
+		return float32(v.field.Float())
+	case reflect.Float64:
+		return v.field.Float()
+	}
+	return v.value.String()
+}
@@ -0,0 +1,480 @@
+package sflag
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type configFlags struct {
+	Name    string
+	Count   int
+	Ratio   float64
+	Verbose bool
+	DBHost  string `name:"db.host"`
+	DBPort  int    `name:"db.port"`
+	Env     string `env:"CONFIG_TEST_ENV"`
+}
+
+func configParser(t *testing.T, doc string) *Parser {
+	t.Helper()
+	p := &Parser{}
+	if err := p.LoadConfig(strings.NewReader(doc), "json"); err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	return p
+}
+
+func TestLoadConfigJSON(t *testing.T) {
+	p := configParser(t, `{"name": "cfg", "count": 3, "ratio": 0.5, "verbose": true,
+		"db": {"host": "h", "port": 5432}, "env": "cfg"}`)
+	p.LookupEnv = mapEnv(map[string]string{"CONFIG_TEST_ENV": "env"})
+	var flags configFlags
+	if err := p.Parse([]string{"prog", "-count", "4"}, &flags); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := configFlags{Name: "cfg", Count: 4, Ratio: 0.5, Verbose: true, DBHost: "h", DBPort: 5432, Env: "env"}
+	if !reflect.DeepEqual(flags, want) {
+		t.Errorf("flags %+v, want %+v", flags, want)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	p := configParser(t, `{"name": "cfg", "unknown": 1}`)
+	var (
+		flags  configFlags
+		errOut bytes.Buffer
+	)
+	p.ErrOutput = &errOut
+	if err := p.Parse([]string{"prog"}, &flags); err != nil || errOut.String() != "warning: unknown config keys: unknown\n" {
+		t.Errorf("Parse = %v, warned %q, want the unknown keys warned of", err, errOut.String())
+	}
+	p.StrictConfig = true
+	if err := p.Parse([]string{"prog"}, &flags); err == nil || err.Error() != "unknown config keys: unknown" {
+		t.Errorf("Parse with StrictConfig = %v, want the unknown keys", err)
+	}
+
+	p = configParser(t, `{"count": "x"}`)
+	err := p.Parse([]string{"prog"}, &flags)
+	if err == nil || !strings.HasPrefix(err.Error(), `invalid config value "x" for flag -count`) {
+		t.Errorf("Parse = %v, want the invalid value", err)
+	}
+}
+
+func TestConfigDecoder(t *testing.T) {
+	p := &Parser{
+		ConfigDecoder: func(data []byte) (map[string]interface{}, error) {
+			var doc map[string]interface{}
+			err := json.Unmarshal(data, &doc)
+			return doc, err
+		},
+	}
+	if err := p.LoadConfig(strings.NewReader(`{"count": 2, "db": {"host": "h", "port": 1}}`), "yaml"); err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	var flags configFlags
+	if err := p.Parse([]string{"prog", "-db.host", "flag"}, &flags); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if flags.Count != 2 || flags.DBHost != "flag" || flags.DBPort != 1 {
+		t.Errorf("flags %+v, want the decoded values below the flags", flags)
+	}
+	if err := p.LoadConfig(strings.NewReader(`{`), "yaml"); err == nil || !strings.HasPrefix(err.Error(), "decode yaml config: ") {
+		t.Errorf("LoadConfig of a bad document = %v, want the decoder error", err)
+	}
+	p.ConfigDecoder = nil
+	if err := p.LoadConfig(strings.NewReader(`{}`), "yaml"); err == nil || err.Error() != "unsupported config format: yaml" {
+		t.Errorf("LoadConfig without a decoder = %v, want unsupported format", err)
+	}
+}
+
+func TestConfigDecoderInterfaceKeys(t *testing.T) {
+	p := &Parser{
+		ConfigDecoder: func([]byte) (map[string]interface{}, error) {
+			// the nested maps of yaml libraries
+			return map[string]interface{}{
+				"db": map[interface{}]interface{}{"host": "h", "port": 5432},
+			}, nil
+		},
+	}
+	if err := p.LoadConfig(strings.NewReader(""), "yaml"); err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	var flags configFlags
+	if err := p.Parse([]string{"prog"}, &flags); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if flags.DBHost != "h" || flags.DBPort != 5432 {
+		t.Errorf("flags %+v, want the values of the nested map", flags)
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigFlag(t *testing.T) {
+	type options struct {
+		Config string `config:"true" env:"APP_CONFIG"`
+		Port   int    `default:"1"`
+		Host   string `default:"default"`
+		Name   string `default:"default"`
+	}
+	path := writeFile(t, "c.json", `{"port": 80, "host": "file"}`)
+	env := map[string]string{}
+	p := &Parser{LookupEnv: mapEnv(env)}
+	var flags options
+	if err := p.Parse([]string{"prog", "-config", path, "-port", "9090"}, &flags); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if flags.Port != 9090 || flags.Host != "file" || flags.Name != "default" {
+		t.Errorf("flags %+v, want the flag over the file over the default", flags)
+	}
+
+	env["APP_CONFIG"] = path
+	flags = options{}
+	if err := p.Parse([]string{"prog"}, &flags); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if flags.Config != path || flags.Port != 80 {
+		t.Errorf("flags %+v, want the file of the environment loaded", flags)
+	}
+
+	// the file of a parse isn't kept by the parser
+	delete(env, "APP_CONFIG")
+	flags = options{}
+	if err := p.Parse([]string{"prog"}, &flags); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if flags.Port != 1 || flags.Host != "default" {
+		t.Errorf("flags %+v, want the defaults without the file", flags)
+	}
+}
+
+func TestConfigFlagErrors(t *testing.T) {
+	var flags struct {
+		Config string `config:"true"`
+		Port   int
+	}
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	err := Parse([]string{"prog", "-config", missing}, &flags)
+	if err == nil || !strings.HasPrefix(err.Error(), "open config "+missing+": ") {
+		t.Errorf("Parse of a missing file = %v, want the path", err)
+	}
+	bad := writeFile(t, "bad.json", "{")
+	err = Parse([]string{"prog", "-config", bad}, &flags)
+	if err == nil || !strings.HasPrefix(err.Error(), "load config "+bad+": ") {
+		t.Errorf("Parse of a bad file = %v, want the path", err)
+	}
+}
+
+func TestConfigFlagArgs(t *testing.T) {
+	type options struct {
+		Config  string `config:"true"`
+		Verbose bool   `name:"v"`
+		Name    string
+		Args    []string `name:"#"`
+	}
+	path := writeFile(t, "c.json", `{"name": "file"}`)
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	for _, c := range []struct {
+		args []string
+		name string
+	}{
+		{[]string{"-v", "-config", path}, "file"},
+		{[]string{"-name", "-config", "-config=" + path}, "-config"},
+		{[]string{"-name", "x", "--config", path}, "x"},
+		{[]string{"arg", "-config", missing}, ""},
+		{[]string{"-v", "arg", "-config", missing}, ""},
+		{[]string{"--", "-config", missing}, ""},
+	} {
+		var flags options
+		if err := (&Parser{}).Parse(append([]string{"prog"}, c.args...), &flags); err != nil {
+			t.Errorf("Parse %q: %v", c.args, err)
+		} else if flags.Name != c.name {
+			t.Errorf("Parse %q: -name %q, want %q", c.args, flags.Name, c.name)
+		}
+	}
+
+	var global struct {
+		Config string `config:"true"`
+	}
+	var serveFlags struct {
+		Config string
+	}
+	var ran bool
+	err := (&Parser{}).RunCommandE([]string{"prog", "serve", "-config", missing}, &global, Command{
+		Name:  "serve",
+		Flags: &serveFlags,
+		Run:   func([]string) { ran = true },
+	})
+	if err != nil || !ran || global.Config != "" || serveFlags.Config != missing {
+		t.Errorf("RunCommandE = %v, global %q, command %q, want -config of the command", err, global.Config, serveFlags.Config)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	type options struct {
+		Port int    `env:"PRECEDENCE_PORT" default:"1"`
+		Host string `env:"PRECEDENCE_HOST" default:"default"`
+		Name string `default:"default"`
+	}
+	for _, c := range []struct {
+		precedence []Source
+		want       options
+	}{
+		{nil, options{Port: 4, Host: "config", Name: "default"}},
+		{[]Source{EnvSource, FlagSource, ConfigSource, DefaultSource}, options{Port: 2, Host: "config", Name: "default"}},
+		{[]Source{ConfigSource, FlagSource}, options{Port: 3, Host: "config"}},
+		{[]Source{DefaultSource}, options{Port: 1, Host: "default", Name: "default"}},
+	} {
+		p := configParser(t, `{"port": 3, "host": "config"}`)
+		p.Precedence = c.precedence
+		p.LookupEnv = mapEnv(map[string]string{"PRECEDENCE_PORT": "2"})
+		var flags options
+		if err := p.Parse([]string{"prog", "-port", "4"}, &flags); err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		if flags != c.want {
+			t.Errorf("Precedence %v: flags %+v, want %+v", c.precedence, flags, c.want)
+		}
+	}
+}
+
+// countingValue is a flag.Value with a side effect, it counts the calls of
+// Set through a pointer shared by its copies, if it's set.
+type countingValue struct {
+	calls  *int
+	values []string
+}
+
+func (v *countingValue) String() string { return strings.Join(v.values, ",") }
+
+func (v *countingValue) Set(s string) error {
+	if v.calls != nil {
+		*v.calls++
+	}
+	v.values = append(v.values, s)
+	return nil
+}
+
+func TestSetOncePerValue(t *testing.T) {
+	for _, c := range []struct {
+		precedence []Source
+		want       string
+	}{
+		{nil, "a,b"},
+		{[]Source{DefaultSource, FlagSource}, "d"},
+	} {
+		var calls int
+		flags := struct {
+			C countingValue `name:"c" default:"d"`
+		}{countingValue{calls: &calls}}
+		p := &Parser{Precedence: c.precedence}
+		if err := p.Parse([]string{"prog", "-c", "a", "-c", "b"}, &flags); err != nil {
+			t.Fatal(err)
+		}
+		if flags.C.String() != c.want || calls != 3 {
+			t.Errorf("Precedence %v: -c %q with %d calls of Set, want %q with 3", c.precedence, flags.C.String(), calls, c.want)
+		}
+	}
+}
+
+func TestWriteConfig(t *testing.T) {
+	type options struct {
+		Config   string `config:"true"`
+		Name     string
+		Count    int `default:"3"`
+		Ratio    float32
+		Verbose  bool
+		Timeout  time.Duration
+		Limit    Size
+		DBHost   string   `name:"db.host"`
+		Password string   `secret:"true"`
+		Debug    bool     `hidden:"true"`
+		Args     []string `name:"#"`
+	}
+	p := &Parser{}
+	var flags options
+	err := p.Parse([]string{"prog", "-name", "a b", "-ratio", "0.1", "-verbose", "-timeout", "1m", "-limit", "2MiB",
+		"-db.host", "h", "-password", "p", "-debug", "arg"}, &flags)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := p.WriteConfig(&buf, "json", &flags); err != nil {
+		t.Fatalf("WriteConfig: %v", err)
+	}
+	if strings.Contains(buf.String(), "password") || strings.Contains(buf.String(), "debug") {
+		t.Errorf("config %s, want the secret and hidden fields omitted", buf.String())
+	}
+
+	p = &Parser{}
+	if err := p.LoadConfig(&buf, "json"); err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	var loaded options
+	if err := p.Parse([]string{"prog", "-password", "p", "-debug", "arg"}, &loaded); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, flags) {
+		t.Errorf("loaded %+v, want %+v", loaded, flags)
+	}
+
+	p.ConfigEncoder = func(doc map[string]interface{}) ([]byte, error) {
+		return []byte(fmt.Sprint(doc["count"], doc["verbose"])), nil
+	}
+	buf.Reset()
+	if err := p.WriteConfig(&buf, "yaml", &flags); err != nil || buf.String() != "3 true" {
+		t.Errorf("WriteConfig with ConfigEncoder = %q, %v, want the native values", buf.String(), err)
+	}
+	p.ConfigEncoder = nil
+	if err := p.WriteConfig(&buf, "yaml", &flags); err == nil || err.Error() != "unsupported config format: yaml" {
+		t.Errorf("WriteConfig without an encoder = %v, want unsupported format", err)
+	}
+}
+
+type DetachedCommon struct {
+	Trace bool `name:"trace"`
+}
+
+// detachedOpts are read without a parse, with a bad value of ZZ_WORKERS in
+// the environment of detachedParser.
+type detachedOpts struct {
+	*DetachedCommon
+	Name    string `name:"name"`
+	Workers int    `name:"workers" env:"ZZ_WORKERS"`
+}
+
+func detachedParser() *Parser {
+	return &Parser{LookupEnv: func(key string) (string, bool) {
+		if key == "ZZ_WORKERS" {
+			return "abc", true
+		}
+		return "", false
+	}}
+}
+
+func TestWriteConfigDetached(t *testing.T) {
+	flags := detachedOpts{Name: "x", Workers: 2}
+	var buf bytes.Buffer
+	if err := detachedParser().WriteConfig(&buf, "json", &flags); err != nil {
+		t.Fatalf("WriteConfig: %v", err)
+	}
+	checkHelp(t, buf.String(), `{
+  "name": "x",
+  "trace": false,
+  "workers": 2
+}
+`)
+	if flags.DetachedCommon != nil {
+		t.Error("WriteConfig allocated the embedded pointer")
+	}
+}
+
+func TestSetFields(t *testing.T) {
+	type options struct {
+		SetInfo
+		Workers int    `name:"workers,w" default:"4"`
+		Host    string `env:"SET_FIELDS_HOST"`
+		Port    int
+		Name    string
+	}
+	p := configParser(t, `{"port": 80}`)
+	p.LookupEnv = mapEnv(map[string]string{"SET_FIELDS_HOST": "env"})
+	var flags options
+	if err := p.Parse([]string{"prog", "-w", "8"}, &flags); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := SetInfo{"workers": FlagSource, "host": EnvSource, "port": ConfigSource}
+	if got := p.SetFields(); !reflect.DeepEqual(got, want) {
+		t.Errorf("SetFields = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(flags.SetInfo, want) || !flags.Changed("workers") || flags.Changed("host") || flags.Source("name") != NoSource {
+		t.Errorf("SetInfo = %v, want %v", flags.SetInfo, want)
+	}
+
+	var serve struct {
+		Port int `default:"8080"`
+	}
+	err := p.RunCommandE([]string{"prog", "serve"}, &flags, Command{Name: "serve", Flags: &serve, Run: func([]string) {}})
+	if err != nil {
+		t.Fatalf("RunCommandE: %v", err)
+	}
+	want = SetInfo{"workers": DefaultSource, "host": EnvSource, "port": ConfigSource, "serve-port": DefaultSource}
+	if got := p.SetFields(); !reflect.DeepEqual(got, want) {
+		t.Errorf("SetFields = %v, want %v", got, want)
+	}
+}
+
+func TestDumpEffective(t *testing.T) {
+	type options struct {
+		Name     string `name:"name,n"`
+		Host     string `env:"DUMP_HOST"`
+		Port     int    `default:"80"`
+		Ratio    float64
+		Password string `secret:"true"`
+		Token    string `secret:"true"`
+		Debug    bool   `hidden:"true"`
+		Level    int
+	}
+	p := configParser(t, `{"ratio": 0.5}`)
+	p.LookupEnv = mapEnv(map[string]string{"DUMP_HOST": "example.com"})
+	path := writeFile(t, "c.json", `{"level": 3}`)
+	if err := p.LoadConfigFile(path); err != nil {
+		t.Fatalf("LoadConfigFile: %v", err)
+	}
+	var flags options
+	if err := p.Parse([]string{"prog", "-n", "a b", "-password", "p"}, &flags); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := `FLAG       VALUE        SOURCE
+-name      a b          flag
+-host      example.com  env:DUMP_HOST
+-port      80           default
+-ratio     0.5          config
+-password  ****         flag
+-token     ""           none
+-debug     false        none
+-level     3            config:` + path + `
+`
+	var buf bytes.Buffer
+	p.DumpEffective(&buf, &flags)
+	checkHelp(t, buf.String(), want)
+
+	buf.Reset()
+	p.Output = &buf
+	p.PrintConfig = true
+	if err := p.Parse([]string{"prog", "-n", "a b", "-password", "p", "-print-config"}, &flags); err != ErrHelp {
+		t.Errorf("Parse -print-config = %v, want ErrHelp", err)
+	}
+	checkHelp(t, buf.String(), want)
+}
+
+func TestDumpEffectiveNotParsed(t *testing.T) {
+	flags := detachedOpts{Name: "x", Workers: 2}
+	var buf bytes.Buffer
+	if err := detachedParser().DumpEffective(&buf, &flags); err != nil {
+		t.Fatalf("DumpEffective: %v", err)
+	}
+	// the bad ZZ_WORKERS isn't read
+	checkHelp(t, buf.String(), `FLAG      VALUE  SOURCE
+-trace    false  none
+-name     x      none
+-workers  2      none
+`)
+	if flags.DetachedCommon != nil {
+		t.Error("DumpEffective allocated the embedded pointer")
+	}
+}
@@ -0,0 +1,65 @@
+package sflag
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type computedOpts struct {
+	Workers int    `name:"workers" usage:"number of workers"`
+	Host    string `name:"host"`
+	Port    int    `name:"port" default:"80"`
+}
+
+func (o *computedOpts) DefaultWorkers() int    { return 4 }
+func (o computedOpts) DefaultHost() string     { return "box" }
+func (o *computedOpts) DefaultUnrelated() bool { return true }
+
+func TestDefaultMethods(t *testing.T) {
+	var flags computedOpts
+	if err := Parse([]string{"prog"}, &flags); err != nil || flags.Workers != 4 || flags.Host != "box" || flags.Port != 80 {
+		t.Errorf("Parse = %+v, %v, want the computed defaults", flags, err)
+	}
+	flags = computedOpts{}
+	if err := Parse([]string{"prog", "-workers=2"}, &flags); err != nil || flags.Workers != 2 {
+		t.Errorf("Parse = %+v, %v, want the flag over the computed default", flags, err)
+	}
+	help := helpOutput(t, &Parser{}, []string{"prog", "-h"}, &flags)
+	for _, want := range []string{"-workers  int (default: 4 (computed))\n", `-host     string (default: "box" (computed))` + "\n", "-port     int (default: 80)\n"} {
+		if !strings.Contains(help, want) {
+			t.Errorf("help doesn't contain %q:\n%s", want, help)
+		}
+	}
+}
+
+type mismatchOpts struct {
+	Workers int
+}
+
+func (o *mismatchOpts) DefaultWorkers() string { return "4" }
+
+type conflictOpts struct {
+	Workers int `default:"2"`
+}
+
+func (o *conflictOpts) DefaultWorkers() int { return 4 }
+
+func TestInvalidDefaultMethods(t *testing.T) {
+	for _, c := range []struct {
+		flags interface{}
+		want  string
+	}{
+		{&mismatchOpts{}, "method DefaultWorkers of sflag.mismatchOpts must be func() int"},
+		{&conflictOpts{}, "field conflictOpts.Workers has both a default tag and the method DefaultWorkers"},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil || fmt.Sprint(r) != c.want {
+					t.Errorf("recover() = %v, want %q", r, c.want)
+				}
+			}()
+			_ = Parse([]string{"prog"}, c.flags)
+		}()
+	}
+}
@@ -0,0 +1,234 @@
+package sflag
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+)
+
+// FlagInfo describes a flag of a struct.
+type FlagInfo struct {
+	Names []string
+	Usage string
+	// Type is the type name shown in help, such as int or duration.
+	Type string
+	// Choices are the values the flag takes, if there are a few.
+	Choices []string
+	Env     string
+	// Default is the value of the default tag, or of the DefaultXxx method
+	// if Computed is true. It's masked if Secret is true.
+	Default    string
+	Computed   bool
+	Required   bool
+	Hidden     bool
+	Secret     bool
+	Config     bool
+	Stdin      bool
+	Deprecated string
+}
+
+// PositionalInfo describes a non-flag field of a struct, tagged with
+// name:"#".
+type PositionalInfo struct {
+	Name  string
+	Usage string
+	Type  string
+	// Default is the value of the default tag, a non-flag without one is
+	// required, unless Slice is true.
+	Default string
+	// Slice is true if the field takes the remaining arguments, Min to Max
+	// of them, a zero Max means no limit.
+	Slice    bool
+	Min, Max int
+	// AfterTerminator is true if the slice takes the arguments after "--"
+	// only.
+	AfterTerminator bool
+}
+
+// Required reports whether the argument must be given.
+func (p PositionalInfo) Required() bool {
+	if p.Slice {
+		return p.Min > 0
+	}
+	return p.Default == ""
+}
+
+// CommandSpec describes the flags, the non-flags and the commands of a struct,
+// or of a command.
+type CommandSpec struct {
+	// Name is the name of the command, empty for the global flags.
+	Name     string
+	Usage    string
+	Group    string
+	Hidden   bool
+	Examples []string
+	// Flags are in the order of declaration, hidden ones included.
+	Flags []FlagInfo
+	// Positionals are in the order they take the arguments.
+	Positionals []PositionalInfo
+	// Commands are the commands, or the nested commands of a cmd field,
+	// hidden ones included.
+	Commands []*CommandSpec
+}
+
+// Describe returns the flags and the non-flags of the struct flagsPtr points
+// to and the commands. They are collected as help does, but the arguments,
+// the environment and the config aren't read, and the structs are left as
+// they are. Invalid tags are returned as the error instead of panicking.
+func (p *Parser) Describe(flagsPtr interface{}, commands ...Command) (spec *CommandSpec, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(sflagError)
+			if !ok {
+				panic(r)
+			}
+			spec, err = nil, se
+		}
+	}()
+	spec = &CommandSpec{}
+	if flagsPtr != nil {
+		if err := p.describeFlags(spec, flagsPtr); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.describeCommands(spec, commands); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+func Describe(flagsPtr interface{}, commands ...Command) (*CommandSpec, error) {
+	return (&Parser{}).Describe(flagsPtr, commands...)
+}
+
+func (p *Parser) describeCommands(spec *CommandSpec, commands []Command) error {
+	for _, cmd := range commands {
+		sub := &CommandSpec{
+			Name:     cmd.Name,
+			Usage:    cmd.Usage,
+			Group:    cmd.Group,
+			Hidden:   cmd.Hidden,
+			Examples: cmd.Examples,
+		}
+		flagsPtr := cmd.Flags
+		if cmd.commands != nil {
+			flagsPtr = cmd.nestedFlags
+		}
+		if flagsPtr != nil {
+			if err := p.describeFlags(sub, flagsPtr); err != nil {
+				return err
+			}
+		}
+		if err := p.describeCommands(sub, cmd.commands); err != nil {
+			return err
+		}
+		spec.Commands = append(spec.Commands, sub)
+	}
+	return nil
+}
+
+// defineCopy defines the flags of a copy of the struct flagsPtr points to
+// with the environment and the config disabled, for reading the flags
+// without a parse: the struct is left alone, nil pointers included, and the
+// values of the fields are the current ones.
+func (p *Parser) defineCopy(flags *commandFlags, cmdline *flag.FlagSet, flagsPtr interface{}) *structFields {
+	refv := reflect.ValueOf(flagsPtr)
+	if refv.Kind() != reflect.Ptr || refv.Elem().Kind() != reflect.Struct {
+		panic("expect pointer of struct")
+	}
+	copied := reflect.New(refv.Elem().Type())
+	copied.Elem().Set(refv.Elem())
+	flags.config, flags.configFiles, flags.disableEnv = nil, nil, true
+	return p.defineFlags(flags, cmdline, copied.Interface())
+}
+
+// describeFlags fills spec with the flags and the non-flags of the struct
+// flagsPtr points to.
+func (p *Parser) describeFlags(spec *CommandSpec, flagsPtr interface{}) error {
+	flags := p.newCommandFlags("")
+	fields := p.defineCopy(flags, flag.NewFlagSet("", flag.ContinueOnError), flagsPtr)
+	if fields.err != nil {
+		return fields.err
+	}
+	described := flags.spec()
+	spec.Flags, spec.Positionals = described.Flags, described.Positionals
+	return nil
+}
+
+// spec returns the CommandSpec of the flags defined to c, which help is
+// printed from. The commands are those listed in help, without their flags.
+func (c *commandFlags) spec() *CommandSpec {
+	spec := &CommandSpec{Usage: c.desc, Examples: c.examples}
+	for _, info := range c.flags {
+		spec.Flags = append(spec.Flags, info.spec())
+	}
+
+	// the non-flag slice takes the arguments after the at non-flag fields
+	// before it, the slices after "--" come last
+	var slice, after []PositionalInfo
+	at := -1
+	for _, info := range c.sliceNonFlag {
+		pi := PositionalInfo{
+			Name:            info.Name,
+			Usage:           info.Usage,
+			Type:            info.Type,
+			Slice:           true,
+			Min:             info.Min,
+			Max:             info.Max,
+			AfterTerminator: info.afterTerminator,
+		}
+		if info.afterTerminator {
+			after = append(after, pi)
+		} else {
+			slice, at = []PositionalInfo{pi}, info.at
+		}
+	}
+	for i, info := range c.nonFlags {
+		if i == at {
+			spec.Positionals = append(spec.Positionals, slice...)
+			slice = nil
+		}
+		spec.Positionals = append(spec.Positionals, PositionalInfo{
+			Name:    info.Name,
+			Usage:   info.Usage,
+			Type:    info.Type,
+			Default: info.Default,
+		})
+	}
+	spec.Positionals = append(spec.Positionals, slice...)
+	spec.Positionals = append(spec.Positionals, after...)
+
+	for _, cmd := range c.subcommands {
+		spec.Commands = append(spec.Commands, &CommandSpec{
+			Name:   cmd.Name,
+			Usage:  cmd.Usage,
+			Group:  cmd.Group,
+			Hidden: cmd.Hidden,
+		})
+	}
+	return spec
+}
+
+// spec returns the FlagInfo of the flag f is the row of, the flags of a
+// flag.FlagSet included.
+func (f flagInfo) spec() FlagInfo {
+	info := FlagInfo{
+		Usage:    f.Usage,
+		Type:     f.Type,
+		Choices:  f.Choices,
+		Env:      f.Env,
+		Default:  f.Default,
+		Computed: f.Computed,
+		Required: f.Required,
+		Hidden:   f.Hidden,
+		Secret:   f.Secret,
+		Config:   f.Config,
+		Stdin:    f.Stdin,
+	}
+	if f.value != nil {
+		info.Names, info.Deprecated = f.value.names, f.value.deprecated
+	} else {
+		info.Names = []string{strings.TrimPrefix(f.Name, "-")}
+	}
+	return info
+}
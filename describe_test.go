@@ -0,0 +1,123 @@
+package sflag
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type DescribeCommon struct {
+	Verbose bool `name:"v" usage:"verbose"`
+}
+
+type describeOpts struct {
+	*DescribeCommon
+	Addr    string        `name:"addr,a" env:"DESCRIBE_ADDR" default:":80" usage:"listen address" required:"true"`
+	Token   string        `name:"token" secret:"true" default:"s3cret"`
+	Timeout time.Duration `name:"timeout"`
+	Level   string        `name:"level" hidden:"true" deprecated:"use -v"`
+	Src     string        `name:"#SRC"`
+	Files   []string      `name:"#FILE" min:"1"`
+	Dst     string        `name:"#DST"`
+}
+
+func (o *describeOpts) DefaultTimeout() time.Duration {
+	return 5 * time.Second
+}
+
+func TestDescribe(t *testing.T) {
+	t.Parallel()
+	var opts describeOpts
+	var cmdFlags struct {
+		Force bool `name:"force"`
+	}
+	root := &structRoot{}
+	commands := append([]Command{{Name: "rm", Usage: "remove", Group: "Files", Flags: &cmdFlags, Hidden: true}}, StructCommands(root)...)
+	p := &Parser{LookupEnv: mapEnv(map[string]string{"DESCRIBE_ADDR": ":8080"})}
+	spec, err := p.Describe(&opts, commands...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(opts, describeOpts{}) {
+		t.Errorf("the struct is changed: %+v", opts)
+	}
+	wantFlags := []FlagInfo{
+		{Names: []string{"v"}, Usage: "verbose", Type: ""},
+		{Names: []string{"addr", "a"}, Usage: "listen address", Type: "string", Env: "DESCRIBE_ADDR", Default: ":80", Required: true},
+		{Names: []string{"token"}, Type: "string", Default: "****", Secret: true},
+		{Names: []string{"timeout"}, Type: "duration", Default: "5s", Computed: true},
+		{Names: []string{"level"}, Type: "string", Hidden: true, Deprecated: "use -v"},
+	}
+	if !reflect.DeepEqual(spec.Flags, wantFlags) {
+		t.Errorf("Flags =\n%+v\nwant\n%+v", spec.Flags, wantFlags)
+	}
+	wantPositionals := []PositionalInfo{
+		{Name: "SRC", Type: "string"},
+		{Name: "FILE", Type: "string", Slice: true, Min: 1},
+		{Name: "DST", Type: "string"},
+	}
+	if !reflect.DeepEqual(spec.Positionals, wantPositionals) {
+		t.Errorf("Positionals =\n%+v\nwant\n%+v", spec.Positionals, wantPositionals)
+	}
+	for _, p := range spec.Positionals {
+		if !p.Required() {
+			t.Errorf("%s isn't required", p.Name)
+		}
+	}
+
+	var names []string
+	var walk func(prefix string, specs []*CommandSpec)
+	walk = func(prefix string, specs []*CommandSpec) {
+		for _, s := range specs {
+			names = append(names, prefix+s.Name)
+			walk(prefix+s.Name+" ", s.Commands)
+		}
+	}
+	walk("", spec.Commands)
+	if want := []string{"rm", "serve", "remote", "remote add", "old"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("commands = %q, want %q", names, want)
+	}
+	rm, remote := spec.Commands[0], spec.Commands[2]
+	if rm.Usage != "remove" || rm.Group != "Files" || !rm.Hidden || len(rm.Flags) != 1 || rm.Flags[0].Names[0] != "force" {
+		t.Errorf("rm = %+v", rm)
+	}
+	if len(remote.Flags) != 1 || remote.Flags[0].Names[0] != "v" || len(remote.Commands[0].Flags) != 1 || remote.Commands[0].Flags[0].Names[0] != "url" {
+		t.Errorf("remote = %+v", remote)
+	}
+
+	var bad struct {
+		N int `name:"n" default:"x"`
+	}
+	if _, err := Describe(&bad); err == nil || err.Error() != `invalid default "x" of field N: strconv.ParseInt: parsing "x": invalid syntax` {
+		t.Errorf("Describe(bad) = %v", err)
+	}
+}
+
+func TestHelpFromDescribe(t *testing.T) {
+	type opts struct {
+		Addr  string   `name:"addr,a" default:"a b" usage:"listen address"`
+		Token string   `name:"token" secret:"true" default:"s3cret"`
+		Level string   `name:"level" hidden:"true"`
+		Src   string   `name:"#SRC" default:"."`
+		Files []string `name:"#FILE"`
+	}
+	p := &Parser{}
+	spec, err := p.Describe(&opts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Flags[0].Default != "a b" {
+		t.Errorf("Default = %q, want the unquoted default", spec.Flags[0].Default)
+	}
+	checkHelp(t, helpOutput(t, p, []string{"prog", "-h"}, &opts{}), `Usage: prog [OPTION]... [SRC] [FILE...]
+
+Options:
+  -addr/-a  string (default: "a b")
+            listen address
+  -token    string (default: ****)
+  SRC       string (default: .)
+  FILE      string
+  -h/-help
+            show this help
+`)
+}
@@ -0,0 +1,126 @@
+package sflag
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// getenv looks up key in the environment, then in the .env files. A variable
+// set to the empty string is reported as set. Nothing is looked up if the
+// environment is disabled, or for the key "-" of the env tags opting out.
+func (c *commandFlags) getenv(key string) (string, bool) {
+	_, v, ok := c.lookupEnvName(key)
+	return v, ok
+}
+
+// lookupEnvName is getenv also returning the name of the variable found,
+// which differs from key in case if it's matched case-insensitively.
+func (c *commandFlags) lookupEnvName(key string) (name, value string, ok bool) {
+	if key == "" || key == "-" || c.disableEnv {
+		return "", "", false
+	}
+	if c.lookupEnv != nil {
+		if v, ok := c.lookupEnv(key); ok {
+			return key, v, true
+		}
+	} else {
+		if v, ok := os.LookupEnv(key); ok {
+			return key, v, true
+		}
+		if c.envFold != nil {
+			if name, ok := c.envFold.lookup(key); ok {
+				return name, os.Getenv(name), true
+			}
+		}
+	}
+	v, ok := c.dotEnv[key]
+	return key, v, ok
+}
+
+// envFold matches the names of the environment case-insensitively, the index
+// is built the first time it's needed, once for a parse.
+type envFold struct {
+	once  sync.Once
+	names map[string]string
+}
+
+func (e *envFold) lookup(key string) (string, bool) {
+	e.once.Do(func() {
+		e.names = make(map[string]string)
+		for _, kv := range os.Environ() {
+			name := kv
+			if i := strings.IndexByte(kv, '='); i > 0 {
+				name = kv[:i]
+			}
+			folded := strings.ToUpper(name)
+			// the first of the names differing in case only wins
+			if _, ok := e.names[folded]; !ok {
+				e.names[folded] = name
+			}
+		}
+	})
+	name, ok := e.names[strings.ToUpper(key)]
+	return name, ok
+}
+
+func (p *Parser) loadDotEnvFiles() (map[string]string, error) {
+	env := make(map[string]string)
+	for _, path := range p.DotEnvFiles {
+		required := strings.HasPrefix(path, "!")
+		path = strings.TrimPrefix(path, "!")
+		f, err := os.Open(path)
+		if err != nil {
+			if !required && os.IsNotExist(err) {
+				continue
+			}
+			return nil, newErrorf("open env file %s: %v", path, err)
+		}
+		err = parseDotEnv(f, env)
+		f.Close()
+		if err != nil {
+			return nil, newErrorf("load env file %s: %v", path, err)
+		}
+	}
+	return env, nil
+}
+
+// parseDotEnv parses KEY=VALUE lines into env, a leading "export" is
+// allowed, values may be quoted and lines starting with '#' are comments.
+// Later files and lines override earlier ones.
+func parseDotEnv(r io.Reader, env map[string]string) error {
+	scanner := bufio.NewScanner(r)
+	var lineno int
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			return newErrorf("line %d: expect KEY=VALUE", lineno)
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		switch {
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			s, err := strconv.Unquote(value)
+			if err != nil {
+				return newErrorf("line %d: invalid quoted value %s", lineno, value)
+			}
+			value = s
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		default:
+			if j := strings.Index(value, " #"); j >= 0 {
+				value = strings.TrimSpace(value[:j])
+			}
+		}
+		env[key] = value
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,214 @@
+package sflag
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDotEnv(t *testing.T) {
+	env := map[string]string{"KEEP": "1"}
+	err := parseDotEnv(strings.NewReader(`# comment
+NAME=a
+export HOST = h
+QUOTED="a # b\n"
+SINGLE='c $d'
+INLINE=e # comment
+EMPTY=
+NAME=b
+`), env)
+	if err != nil {
+		t.Fatalf("parseDotEnv: %v", err)
+	}
+	want := map[string]string{
+		"KEEP": "1", "NAME": "b", "HOST": "h", "QUOTED": "a # b\n", "SINGLE": "c $d", "INLINE": "e", "EMPTY": "",
+	}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("parseDotEnv = %q, want %q", env, want)
+	}
+
+	for doc, msg := range map[string]string{
+		"A=1\nB\n":    "line 2: expect KEY=VALUE",
+		"=1\n":        "line 1: expect KEY=VALUE",
+		"A=\"\\q\"\n": `line 1: invalid quoted value "\q"`,
+	} {
+		if err := parseDotEnv(strings.NewReader(doc), make(map[string]string)); err == nil || err.Error() != msg {
+			t.Errorf("parseDotEnv(%q) = %v, want %s", doc, err, msg)
+		}
+	}
+}
+
+func TestDotEnvFiles(t *testing.T) {
+	first := writeFile(t, "first.env", "NAME=first\nPORT=1\n")
+	second := writeFile(t, "second.env", "PORT=2\nHOST=dotenv\n")
+	missing := filepath.Join(filepath.Dir(first), "missing.env")
+	type envFlags struct {
+		Name string `env:"NAME"`
+		Port int    `env:"PORT"`
+		Host string `env:"HOST"`
+	}
+	p := &Parser{DotEnvFiles: []string{first, missing, second}, LookupEnv: mapEnv(map[string]string{"HOST": "real"})}
+	var flags envFlags
+	if err := p.Parse([]string{"prog"}, &flags); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if want := (envFlags{"first", 2, "real"}); flags != want {
+		t.Errorf("flags %+v, want %+v", flags, want)
+	}
+
+	p.DotEnvFiles = []string{first, "!" + missing}
+	if err := p.Parse([]string{"prog"}, &flags); err == nil || !strings.HasPrefix(err.Error(), "open env file "+missing+": ") {
+		t.Errorf("Parse with a required missing file = %v, want the open error", err)
+	}
+	p.DotEnvFiles = []string{writeFile(t, "bad.env", "NAME\n")}
+	if err := p.Parse([]string{"prog"}, &flags); err == nil || !strings.HasSuffix(err.Error(), "bad.env: line 1: expect KEY=VALUE") {
+		t.Errorf("Parse with an invalid file = %v, want the line error", err)
+	}
+}
+
+func TestDotEnvFilesOfCommands(t *testing.T) {
+	var serveFlags struct {
+		Port int `env:"PORT"`
+	}
+	p := &Parser{DotEnvFiles: []string{writeFile(t, ".env", "PORT=8080\n")}}
+	var port int
+	err := p.RunCommandE([]string{"prog", "serve"}, nil, Command{
+		Name:  "serve",
+		Flags: &serveFlags,
+		Run:   func([]string) { port = serveFlags.Port },
+	})
+	if err != nil || port != 8080 {
+		t.Errorf("RunCommandE = %v, -port %d, want 8080 of the .env file", err, port)
+	}
+}
+
+// mapEnv returns a Parser.LookupEnv of the variables of env, so that the
+// tests don't change the environment of the process and run in parallel.
+func mapEnv(env map[string]string) func(key string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+func TestLookupEnv(t *testing.T) {
+	type options struct {
+		Host string `env:"HOST"`
+		Port int    `env:"PORT" default:"80"`
+	}
+	for _, c := range []struct {
+		env  map[string]string
+		want options
+	}{
+		{map[string]string{"HOST": "a", "PORT": "1"}, options{Host: "a", Port: 1}},
+		{map[string]string{"HOST": "b"}, options{Host: "b", Port: 80}},
+	} {
+		c := c
+		t.Run(c.env["HOST"], func(t *testing.T) {
+			t.Parallel()
+			p := &Parser{LookupEnv: mapEnv(c.env)}
+			var flags options
+			if err := p.Parse([]string{"prog"}, &flags); err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if flags != c.want {
+				t.Errorf("flags %+v, want %+v", flags, c.want)
+			}
+		})
+	}
+}
+
+func TestEmptyEnv(t *testing.T) {
+	type options struct {
+		Host   string `env:"HOST" default:"localhost"`
+		Proxy  string `env:"PROXY" default:"proxy" env-empty:"ignore"`
+		Port   int    `env:"PORT" default:"80" env-empty:"ignore"`
+		Cached int    `env:"CACHED"`
+	}
+	for _, c := range []struct {
+		env  map[string]string
+		want options
+		err  string
+	}{
+		{map[string]string{"HOST": "", "PROXY": "", "PORT": ""}, options{Proxy: "proxy", Port: 80}, ""},
+		{map[string]string{}, options{Host: "localhost", Proxy: "proxy", Port: 80}, ""},
+		{map[string]string{"CACHED": ""}, options{}, `invalid value "" for $CACHED (flag -cached): strconv.ParseInt: parsing "": invalid syntax`},
+	} {
+		p := &Parser{LookupEnv: mapEnv(c.env)}
+		var flags options
+		err := p.Parse([]string{"prog"}, &flags)
+		if (err == nil && c.err != "") || (err != nil && err.Error() != c.err) {
+			t.Errorf("Parse with %v = %v, want %q", c.env, err, c.err)
+			continue
+		}
+		if err == nil && flags != c.want {
+			t.Errorf("Parse with %v = %+v, want %+v", c.env, flags, c.want)
+		}
+	}
+}
+
+func TestDisableEnv(t *testing.T) {
+	type options struct {
+		Workers int    `env:"WORKERS" default:"1"`
+		Host    string `env:"-"`
+	}
+	lookupEnv := func(key string) (string, bool) {
+		return map[string]string{"WORKERS": "8", "-": "x"}[key], key == "WORKERS" || key == "-"
+	}
+	noEnv := func(string) (string, bool) { return "", false }
+	path := writeFile(t, ".env", "WORKERS=4\n")
+	for _, c := range []struct {
+		p    *Parser
+		want options
+	}{
+		{&Parser{LookupEnv: lookupEnv}, options{Workers: 8}},
+		{&Parser{LookupEnv: lookupEnv, DisableEnv: true}, options{Workers: 1}},
+		{&Parser{LookupEnv: noEnv, DotEnvFiles: []string{path}}, options{Workers: 4}},
+		{&Parser{DotEnvFiles: []string{"!" + path + ".missing"}, DisableEnv: true}, options{Workers: 1}},
+	} {
+		var flags options
+		if err := c.p.Parse([]string{"prog"}, &flags); err != nil || flags != c.want {
+			t.Errorf("Parse with DisableEnv %v = %+v, %v, want %+v", c.p.DisableEnv, flags, err, c.want)
+		}
+	}
+
+	var flags options
+	if help := helpOutput(t, &Parser{DisableEnv: true}, []string{"prog", "-h"}, &flags); !strings.Contains(help, "(default: 1, env: WORKERS)") || strings.Contains(help, "env: -") {
+		t.Errorf("help doesn't show the env tags:\n%s", help)
+	}
+}
+
+func TestEnvCaseInsensitive(t *testing.T) {
+	os.Setenv("Sflag_Case_Proxy", "p")
+	defer os.Unsetenv("Sflag_Case_Proxy")
+	type options struct {
+		Proxy string `name:"proxy" env:"SFLAG_CASE_PROXY"`
+	}
+	if !envCaseInsensitive {
+		var flags options
+		if err := Parse([]string{"prog"}, &flags); err != nil || flags.Proxy != "" {
+			t.Errorf("Parse = %v, flags %+v, want the env tag matched exactly", err, flags)
+		}
+	}
+
+	var flags options
+	p := &Parser{EnvCaseInsensitive: true}
+	if err := p.Parse([]string{"prog"}, &flags); err != nil || flags.Proxy != "p" {
+		t.Errorf("Parse = %v, flags %+v, want the env tag matched ignoring case", err, flags)
+	}
+	var out strings.Builder
+	p.DumpEffective(&out, &flags)
+	if !strings.Contains(out.String(), "env:Sflag_Case_Proxy") {
+		t.Errorf("DumpEffective = %q, want the name of the variable matched", out.String())
+	}
+
+	var port struct {
+		Port int `name:"port" env:"SFLAG_CASE_PROXY"`
+	}
+	err := p.Parse([]string{"prog"}, &port)
+	if want := `invalid value "p" for $Sflag_Case_Proxy (flag -port): strconv.ParseInt: parsing "p": invalid syntax`; err == nil || err.Error() != want {
+		t.Errorf("Parse = %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,90 @@
+package sflag
+
+import (
+	"flag"
+	"io"
+	"strings"
+)
+
+const secretMask = "****"
+
+// maskSecret returns secretMask in place of s, an empty s stays empty as it
+// reveals nothing.
+func maskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return secretMask
+}
+
+// DumpEffective prints the flags of the struct flagsPtr points to, with their
+// current values and where the values come from: flag, env:NAME, config,
+// config:FILE, default, or none if the field is left as is. The sources are
+// known only if the struct is of the last parse of p, the environment and the
+// config aren't read for other structs. Values of fields tagged with
+// `secret:"true"` are masked. The error is of the tags of a struct not parsed.
+func (p *Parser) DumpEffective(w io.Writer, flagsPtr interface{}) error {
+	var flags *commandFlags
+	for _, parsed := range p.lastParsed() {
+		if parsed.flagsPtr == flagsPtr {
+			flags = parsed
+			break
+		}
+	}
+	if flags == nil {
+		flags = p.newCommandFlags("")
+		if fields := p.defineCopy(flags, flag.NewFlagSet("", flag.ContinueOnError), flagsPtr); fields.err != nil {
+			return fields.err
+		}
+	}
+	flags.dumpEffective(w)
+	return nil
+}
+
+// dumpEffective is DumpEffective of the flags of c.
+func (c *commandFlags) dumpEffective(w io.Writer) {
+	flags := c
+	var set map[string]bool
+	if flags.flagSet != nil {
+		set = make(map[string]bool)
+		flags.flagSet.Visit(func(f *flag.Flag) {
+			set[f.Name] = true
+		})
+	}
+
+	tw := tabWriter(w, 2)
+	fprintf(tw, "FLAG\tVALUE\tSOURCE\n")
+	for _, f := range flags.sortedFlags(true) {
+		var value, source string
+		if f.value == nil {
+			// registered by Parser.PreParse or adopted
+			name := strings.TrimPrefix(f.Name, "-")
+			if fl := flags.flagSet.Lookup(name); fl != nil {
+				value = fl.Value.String()
+			}
+			source = DefaultSource.String()
+			if set[name] {
+				source = FlagSource.String()
+			}
+		} else {
+			value = f.value.String()
+			source = f.value.source.String()
+			switch f.value.source {
+			case EnvSource:
+				source += ":" + f.value.envName
+			case ConfigSource:
+				if f.value.configFile != "" {
+					source += ":" + f.value.configFile
+				}
+			}
+		}
+		switch {
+		case f.Secret && value != "":
+			value = secretMask
+		case value == "":
+			value = `""`
+		}
+		fprintf(tw, "%s\t%s\t%s\n", strings.SplitN(f.Name, "/", 2)[0], value, source)
+	}
+	_ = tw.Flush()
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+// +build !windows
+
+package sflag
+
+// envCaseInsensitive matches the env tags case-insensitively regardless of
+// Parser.EnvCaseInsensitive.
+const envCaseInsensitive = false
@@ -0,0 +1,5 @@
+package sflag
+
+// envCaseInsensitive matches the env tags case-insensitively regardless of
+// Parser.EnvCaseInsensitive, as the names of the environment are.
+const envCaseInsensitive = true
@@ -0,0 +1,79 @@
+package sflag
+
+import (
+	"os"
+	"strconv"
+)
+
+// expandMode is how the variables of the values of a flag are expanded, by
+// the expand tag or Parser.ExpandValues.
+type expandMode int
+
+const (
+	// expandDefault expands the variables if Parser.ExpandValues is true.
+	expandDefault expandMode = iota
+	expandNever
+	// expandLoose expands undefined variables to the empty string.
+	expandLoose
+	// expandStrict makes undefined variables an error.
+	expandStrict
+)
+
+// parseExpandTag parses the expand tag, which is a bool or "strict".
+func parseExpandTag(tag string) (expandMode, bool) {
+	switch tag {
+	case "":
+		return expandDefault, true
+	case "strict":
+		return expandStrict, true
+	}
+	expand, err := strconv.ParseBool(tag)
+	if err != nil {
+		return expandDefault, false
+	}
+	if expand {
+		return expandLoose, true
+	}
+	return expandNever, true
+}
+
+// expandFunc returns the function expanding the ${VAR} and $VAR of the
+// values of the flag of name by the environment, nil if they aren't
+// expanded. "$$" is a literal "$".
+func (c *commandFlags) expandFunc(name string, mode expandMode) func(string) (string, error) {
+	if mode == expandDefault && c.expandValues {
+		mode = expandLoose
+	}
+	if mode != expandLoose && mode != expandStrict {
+		return nil
+	}
+	return func(s string) (string, error) {
+		var undefined string
+		expanded := os.Expand(s, func(key string) string {
+			if key == "$" {
+				return "$"
+			}
+			v, ok := c.getenv(key)
+			if !ok && undefined == "" {
+				undefined = key
+			}
+			return v
+		})
+		if mode == expandStrict && undefined != "" {
+			return "", newErrorf("undefined variable $%s in the value %q of flag -%s", undefined, s, name)
+		}
+		return expanded, nil
+	}
+}
+
+// expandAll returns a copy of values expanded by expand.
+func expandAll(values []string, expand func(string) (string, error)) ([]string, error) {
+	expanded := make([]string, len(values))
+	for i, v := range values {
+		var err error
+		if expanded[i], err = expand(v); err != nil {
+			return nil, err
+		}
+	}
+	return expanded, nil
+}
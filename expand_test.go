@@ -0,0 +1,56 @@
+package sflag
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandValues(t *testing.T) {
+	type options struct {
+		LogDir string `name:"log-dir" expand:"true"`
+		Cache  string `name:"cache" expand:"strict"`
+		Raw    string `name:"raw"`
+		Home   string `name:"home" env:"HOME_DIR" expand:"true"`
+	}
+	env := map[string]string{"RUNTIME_DIR": "/run/app", "HOME_DIR": "${RUNTIME_DIR}"}
+	lookupEnv := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+	for _, c := range []struct {
+		args   []string
+		expand bool
+		want   options
+		err    string
+	}{
+		{[]string{"-log-dir=${RUNTIME_DIR}/logs", "-raw=$RUNTIME_DIR"}, false, options{LogDir: "/run/app/logs", Raw: "$RUNTIME_DIR", Home: "${RUNTIME_DIR}"}, ""},
+		{[]string{"-log-dir=$UNSET/logs", "-cache=$$HOME"}, false, options{LogDir: "/logs", Cache: "$HOME", Home: "${RUNTIME_DIR}"}, ""},
+		{[]string{"-raw=$RUNTIME_DIR"}, true, options{Raw: "/run/app", Home: "${RUNTIME_DIR}"}, ""},
+		{[]string{"-cache=${UNSET}/cache"}, false, options{}, `undefined variable $UNSET in the value "${UNSET}/cache" of flag -cache`},
+	} {
+		var flags options
+		p := &Parser{LookupEnv: lookupEnv, ExpandValues: c.expand, ErrOutput: &strings.Builder{}}
+		err := p.Parse(append([]string{"prog"}, c.args...), &flags)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("Parse %q = %v, want %q", c.args, err, c.err)
+			}
+			continue
+		}
+		if err != nil || flags != c.want {
+			t.Errorf("Parse %q = %+v, %v, want %+v", c.args, flags, err, c.want)
+		}
+	}
+
+	p := configParser(t, `{"log-dir": "${RUNTIME_DIR}/logs", "cache": "$UNSET"}`)
+	p.LookupEnv = lookupEnv
+	var flags options
+	if err := p.Parse([]string{"prog"}, &flags); err == nil || !strings.Contains(err.Error(), "undefined variable $UNSET") {
+		t.Errorf("Parse = %v, want the undefined variable of the config", err)
+	}
+	p = configParser(t, `{"log-dir": "${RUNTIME_DIR}/logs"}`)
+	p.LookupEnv = lookupEnv
+	if err := p.Parse([]string{"prog"}, &flags); err != nil || flags.LogDir != "/run/app/logs" {
+		t.Errorf("Parse = %q, %v, want the config value expanded", flags.LogDir, err)
+	}
+}
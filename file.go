@@ -0,0 +1,18 @@
+package sflag
+
+// File is the path of a file, which is labeled as file in help. The file
+// isn't opened or checked.
+type File string
+
+func (f *File) String() string {
+	return string(*f)
+}
+
+func (f *File) Set(s string) error {
+	*f = File(s)
+	return nil
+}
+
+func (f *File) Type() string {
+	return "file"
+}
@@ -1,31 +1,182 @@
 package sflag
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"text/tabwriter"
+	"time"
 	"unicode"
 	"unicode/utf8"
-	"unsafe"
 )
 
 var ErrHelp = flag.ErrHelp
 
 type UsageFunc func(printDefaults func(w io.Writer))
 
+// CommandResolveFunc resolves the name args[0] of an unknown command, it
+// returns the args of the command resolved to and true, or false if there is
+// none. See PrefixResolver, AliasResolver and ChainResolvers.
 type CommandResolveFunc func(args []string, commands []Command) ([]string, bool)
 
 type Command struct {
-	Name  string
-	Usage string
+	Name     string
+	Usage    string
+	Examples []string
+	// Group lists the command under a "<Group> Commands:" header in help,
+	// groups are shown in order of first appearance.
+	Group string
+	// UsageFunc overrides Parser.Usage for the help of the command, the
+	// Usage field being its one-line description.
+	UsageFunc UsageFunc
+	// Hidden commands can be run but are not listed in help.
+	Hidden bool
+	// Flags is an optional pointer to struct holding the command's own
+	// flags, parsed from the arguments following the command name before
+	// Run is called.
+	Flags interface{}
+	// Args checks the arguments following the command name, less the flags
+	// of Flags, before the command is run by RunCommand or returned by
+	// ParseCommandFlags. An error is reported along with the usage line of
+	// the command. See NoArgs, ExactArgs, RangeArgs and MinimumArgs.
+	Args ArgsFunc
 
 	Run func(args []string)
+	// RunE is used instead of Run when both are set, a returned ErrHelp
+	// prints the help of the command.
+	RunE func(args []string) error
+	// RunCtx takes precedence over RunE and Run, it receives the context
+	// given to RunCommandContext or context.Background().
+	RunCtx func(ctx context.Context, args []string) error
+
+	PreRun  func(args []string) error
+	PostRun func(args []string) error
+
+	// Complete returns the completion candidates of the positionals, args
+	// are the words following the command name before toComplete.
+	Complete func(args []string, toComplete string) []string
+
+	// nestedFlags and commands are the flags and the commands of a command
+	// struct of cmd fields, which are parsed and run by a parse of their
+	// own in place of Flags and Run.
+	nestedFlags interface{}
+	commands    []Command
+}
+
+// ArgsFunc checks the arguments of a command.
+type ArgsFunc func(args []string) error
+
+// arityError is the error of the ArgsFunc of RangeArgs, its message names the
+// command once known.
+type arityError struct {
+	min, max, got int
+}
+
+func (e *arityError) Error() string {
+	return strings.TrimPrefix(e.commandError(English, "").Error(), `command "" `)
+}
+
+// commandError returns the error of the arguments of the command name.
+func (e *arityError) commandError(tr TranslateFunc, name string) error {
+	switch {
+	case e.max == 0:
+		return tr.errorf(MsgCommandNoArguments, name, e.got)
+	case e.min == e.max:
+		return tr.errorf(MsgCommandExactArguments, name, e.min, tr(plural(e.min, MsgArgument, MsgArguments)), e.got)
+	case e.max < 0:
+		return tr.errorf(MsgCommandMinArguments, name, e.min, tr(plural(e.min, MsgArgument, MsgArguments)), e.got)
+	}
+	return tr.errorf(MsgCommandRangeArguments, name, e.min, e.max, e.got)
+}
+
+// NoArgs accepts no arguments.
+func NoArgs(args []string) error {
+	return RangeArgs(0, 0)(args)
+}
+
+// ExactArgs accepts n arguments.
+func ExactArgs(n int) ArgsFunc {
+	return RangeArgs(n, n)
+}
+
+// MinimumArgs accepts at least n arguments.
+func MinimumArgs(n int) ArgsFunc {
+	return RangeArgs(n, -1)
+}
+
+// RangeArgs accepts min to max arguments, a negative max means no limit.
+func RangeArgs(min, max int) ArgsFunc {
+	return func(args []string) error {
+		if len(args) < min || (max >= 0 && len(args) > max) {
+			return &arityError{min: min, max: max, got: len(args)}
+		}
+		return nil
+	}
+}
+
+// commandArgsError returns the error of the ArgsFunc of the command name.
+func commandArgsError(tr TranslateFunc, name string, err error) error {
+	if ae, ok := err.(*arityError); ok {
+		return ae.commandError(tr, name)
+	}
+	return tr.errorf(MsgCommandInvalidArguments, name, err)
+}
+
+// helpCommand returns the command listed in help for the "help [COMMAND]"
+// handled by resolveSubCommand, unless there is a user defined one.
+func helpCommand(tr TranslateFunc) Command {
+	return Command{Name: "help", Usage: tr(MsgHelpCommand)}
+}
+
+func hasCommand(commands []Command, name string) bool {
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func commandGroups(commands []*CommandSpec) []string {
+	var groups []string
+	seen := make(map[string]bool)
+	for _, cmd := range commands {
+		if !seen[cmd.Group] {
+			seen[cmd.Group] = true
+			groups = append(groups, cmd.Group)
+		}
+	}
+	return groups
+}
+
+// listedCommands returns the commands listed in help, the visible ones and
+// the help command.
+func listedCommands(tr TranslateFunc, commands []Command) []Command {
+	listed := visibleCommands(commands)
+	if len(commands) > 0 && !hasCommand(commands, "help") {
+		listed = append(listed, helpCommand(tr))
+	}
+	return listed
+}
+
+func visibleCommands(commands []Command) []Command {
+	visible := make([]Command, 0, len(commands))
+	for _, cmd := range commands {
+		if !cmd.Hidden {
+			visible = append(visible, cmd)
+		}
+	}
+	return visible
 }
 
 type flagInfo struct {
@@ -34,106 +185,618 @@ type flagInfo struct {
 	Default string
 	Usage   string
 	Type    string
+	// Choices are the values the flag takes, if there are a few.
+	Choices []string
 
+	Required     bool
 	NonFlag      bool
 	NonFlagSlice bool
+	Hidden       bool
+	Secret       bool
+	Config       bool
+	// Stdin is true if the flag reads stdin when given as "-".
+	Stdin bool
+	// Computed is true if the default is computed by a DefaultXxx method.
+	Computed bool
+
+	// Min and Max are the numbers of arguments the non-flag slice takes, a
+	// zero Max means no limit.
+	Min, Max int
+	// afterTerminator is true if the non-flag slice takes the arguments
+	// after "--" only.
+	afterTerminator bool
+	// at is the number of non-flag fields before the non-flag slice.
+	at int
+
+	value *sourcedValue
+	// origin is the field or flag set the flag is defined by.
+	origin string
 }
 
 type commandFlags struct {
-	name           string
-	flags          []flagInfo
-	stringNonFlags []flagInfo
-	sliceNonFlag   []flagInfo
+	name         string
+	command      bool
+	desc         string
+	examples     []string
+	header       string
+	footer       string
+	flags        []flagInfo
+	nonFlags     []flagInfo
+	sliceNonFlag []flagInfo
+	// helpFlags are the help flags listed after the other options.
+	helpFlags []flagInfo
+	helpNames []string
+	// globals are the global flags listed in the help of a command.
+	globals []FlagInfo
 
 	subcommands []Command
+	// commandOptional is true if the program runs without a command too.
+	commandOptional bool
+
+	flagSet  *flag.FlagSet
+	flagsPtr interface{}
+	setInfo  reflect.Value
+	// overlay is true if the parse sets the flags given only, on top of a
+	// parse of the struct before, whose sources are baseSources.
+	overlay      bool
+	baseSources  SetInfo
+	config       config
+	configFiles  configFiles
+	configPrefix string
+	configUsed   map[string]bool
+	dotEnv       map[string]string
+	disableEnv   bool
+	// skipEnvTags leaves the env tags out, the variables expanded in the
+	// values are still looked up.
+	skipEnvTags bool
+	// envFold matches the env tags case-insensitively, nil if they are
+	// matched exactly.
+	envFold       *envFold
+	expandValues  bool
+	stdin         *stdinSource
+	lookupEnv     func(key string) (string, bool)
+	usage         UsageFunc
+	tr            TranslateFunc
+	secretReader  SecretReader
+	output        io.Writer
+	errOutput     io.Writer
+	width         int
+	layout        HelpLayout
+	sortFlags     bool
+	requiredFirst bool
+}
+
+const defaultHelpWidth = 80
+
+// HelpLayout arranges the rows of the flags and commands in help. The zero
+// value lists the usage of a flag below its name, indented by 2.
+type HelpLayout struct {
+	// Inline puts the usage of a flag on the line of its name and type,
+	// along with the default and the env, as the go tool does.
+	Inline bool
+	// Indent is the number of spaces the rows are indented by, 2 if zero.
+	Indent int
+	// MaxNameWidth limits the width of the name column, so that a long
+	// name doesn't push the usages of all the rows to the right. The usage
+	// of a wider name starts on the next line. Zero means no limit.
+	MaxNameWidth int
+}
+
+func (l HelpLayout) indent() string {
+	if l.Indent <= 0 {
+		return "  "
+	}
+	return strings.Repeat(" ", l.Indent)
+}
+
+// nameWidth returns the width of the name column of the widest name.
+func (l HelpLayout) nameWidth(widest int) int {
+	if l.MaxNameWidth > 0 && widest > l.MaxNameWidth {
+		return l.MaxNameWidth
+	}
+	return widest
+}
+
+func (c *commandFlags) helpWidth(w io.Writer) int {
+	if c.width > 0 {
+		return c.width
+	}
+	if f, ok := w.(*os.File); ok {
+		if width, ok := terminalWidth(f); ok {
+			return width
+		}
+	}
+	return defaultHelpWidth
+}
+
+// sortedFlags returns the flags in the order of help, the hidden ones are
+// included only if hidden is true.
+func (c *commandFlags) sortedFlags(hidden bool) []flagInfo {
+	flags := make([]flagInfo, 0, len(c.flags))
+	for _, f := range c.flags {
+		if hidden || !f.Hidden {
+			flags = append(flags, f)
+		}
+	}
+	if !c.sortFlags && !c.requiredFirst {
+		return flags
+	}
+	sort.SliceStable(flags, func(i, j int) bool {
+		return c.flagLess(flags[i].Required, flags[j].Required, strings.SplitN(flags[i].Name, "/", 2)[0], strings.SplitN(flags[j].Name, "/", 2)[0])
+	})
+	return flags
+}
+
+// helpOptions returns the flags of spec listed in help, in the order of help.
+func (c *commandFlags) helpOptions(spec *CommandSpec) []FlagInfo {
+	options := make([]FlagInfo, 0, len(spec.Flags))
+	for _, f := range spec.Flags {
+		if !f.Hidden {
+			options = append(options, f)
+		}
+	}
+	if !c.sortFlags && !c.requiredFirst {
+		return options
+	}
+	sort.SliceStable(options, func(i, j int) bool {
+		return c.flagLess(options[i].Required, options[j].Required, "-"+options[i].Names[0], "-"+options[j].Names[0])
+	})
+	return options
+}
 
-	usage UsageFunc
+// flagLess reports whether the flag of the first name a comes before the one
+// of b in help, the required ones first if requiredFirst is set.
+func (c *commandFlags) flagLess(aRequired, bRequired bool, a, b string) bool {
+	if c.requiredFirst && aRequired != bRequired {
+		return aRequired
+	}
+	return c.sortFlags && strings.ToLower(a) < strings.ToLower(b)
+}
+
+// printUsageLine prints the synopsis of the help of spec.
+func (c *commandFlags) printUsageLine(w io.Writer, spec *CommandSpec) {
+	fprintf(w, "%s\n", c.tr(MsgUsage, c.synopsis(spec, c.helpOptions(spec))))
+}
+
+// synopsis returns the program or command name followed by the arguments it
+// takes, options are the flags listed in help. The required flags are
+// spelled out, such as -token TOKEN, and the others are [OPTION]..., the
+// optional arguments are bracketed.
+func (c *commandFlags) synopsis(spec *CommandSpec, options []FlagInfo) string {
+	var b strings.Builder
+	b.WriteString(c.name)
+	var optional int
+	for _, f := range options {
+		if !f.Required {
+			optional++
+		}
+	}
+	if optional == 1 {
+		fprintf(&b, " [%s]", c.tr(MsgOptionPlaceholder))
+	} else if optional > 1 {
+		fprintf(&b, " [%s]...", c.tr(MsgOptionPlaceholder))
+	}
+	for _, f := range options {
+		if !f.Required {
+			continue
+		}
+		fprintf(&b, " -%s", f.Names[0])
+		if f.Type != "" {
+			// the value is named after the last name, which is the long
+			// one conventionally
+			value := f.Names[len(f.Names)-1]
+			fprintf(&b, " %s", strings.ToUpper(strings.Replace(value, "-", "_", -1)))
+		}
+	}
+	for _, p := range spec.Positionals {
+		switch {
+		case p.AfterTerminator:
+		case p.Slice && p.Min > 0:
+			fprintf(&b, " %s...", p.Name)
+		case p.Slice:
+			fprintf(&b, " [%s...]", p.Name)
+		case p.Default != "":
+			fprintf(&b, " [%s]", p.Name)
+		default:
+			fprintf(&b, " %s", p.Name)
+		}
+	}
+	if len(spec.Commands) > 0 && c.commandOptional {
+		fprintf(&b, " [%s [%s]...]", c.tr(MsgCommandPlaceholder), c.tr(MsgArgumentPlaceholder))
+	} else if len(spec.Commands) > 0 {
+		fprintf(&b, " %s [%s]...", c.tr(MsgCommandPlaceholder), c.tr(MsgArgumentPlaceholder))
+	}
+	// after the command, as the arguments after "--" are taken
+	for _, p := range spec.Positionals {
+		if !p.AfterTerminator {
+			continue
+		}
+		if p.Min > 0 {
+			fprintf(&b, " -- %s...", p.Name)
+		} else {
+			fprintf(&b, " [-- %s...]", p.Name)
+		}
+	}
+	return b.String()
 }
 
+// printDefaults prints the help of the CommandSpec of c, along with the help
+// flags, the global options, the header and the footer.
 func (c *commandFlags) printDefaults(w io.Writer) {
-	if len(c.flags)+len(c.stringNonFlags)+len(c.sliceNonFlag)+len(c.subcommands) == 0 {
-		fprintln(w, "no options.")
+	spec := c.spec()
+	if c.empty(spec) {
+		fprintln(w, c.tr(MsgNoOptions))
 		return
 	}
+	width := c.helpWidth(w)
 	tw := tabWriter(w, 2)
 
-	hasFlag := len(c.flags) > 0 || len(c.stringNonFlags) > 0 || len(c.sliceNonFlag) > 0
-	if hasFlag || len(c.subcommands) > 0 {
-		fprintf(tw, "Usage: %s", c.name)
-		if len(c.flags) == 1 {
-			fprintf(tw, " [OPTION]")
-		} else if len(c.flags) > 1 {
-			fprintf(tw, " [OPTION]...")
+	if c.header != "" {
+		for _, line := range wrapLines(c.header, wrapWidth(width, 0)) {
+			fprintf(tw, "%s\n", line)
 		}
-		for _, f := range c.stringNonFlags {
-			fprintf(tw, " %s", f.Name)
+		fprintf(tw, "\n")
+	}
+
+	options := c.helpOptions(spec)
+	c.printUsageLine(tw, spec)
+	if spec.Usage != "" {
+		fprintf(tw, "\n")
+		for _, line := range wrapLines(spec.Usage, wrapWidth(width, 0)) {
+			fprintf(tw, "%s\n", line)
 		}
-		for _, f := range c.sliceNonFlag {
-			fprintf(tw, " %s...", f.Name)
+	}
+	rowIndent := c.layout.indent()
+	var positionals, slices []helpRow
+	for _, p := range spec.Positionals {
+		if p.Slice {
+			slices = append(slices, c.positionalRow(p))
+		} else {
+			positionals = append(positionals, c.positionalRow(p))
 		}
-		if len(c.subcommands) > 0 {
-			fprintf(tw, " COMMAND [ARGUMENT]...")
+	}
+	var helpFlags []helpRow
+	for _, f := range c.helpFlags {
+		helpFlags = append(helpFlags, helpRow{name: f.Name, usage: f.Usage})
+	}
+	globals := c.flagRows(c.globals)
+	// the global options are aligned with the options
+	rows := [][]helpRow{c.flagRows(options), positionals, slices, helpFlags}
+	aligned := append(rows[:len(rows):len(rows)], globals)
+	if len(options) > 0 || len(spec.Positionals) > 0 {
+		fprintf(tw, "\n%s\n", c.tr(MsgOptions))
+		c.printFlags(tw, rows, aligned, width)
+	}
+	if len(globals) > 0 {
+		fprintf(tw, "\n%s\n", c.tr(MsgGlobalOptions))
+		c.printFlags(tw, [][]helpRow{globals}, aligned, width)
+	}
+	if len(spec.Commands) > 0 {
+		var nameWidth int
+		for _, cmd := range spec.Commands {
+			nameWidth = maxInt(nameWidth, displayWidth(cmd.Name))
 		}
-		fprintf(tw, "\n")
-	} else {
-		fprintf(tw, "Usage of %s:\n", c.name)
-	}
-	if hasFlag {
-		fprintf(tw, "\nOptions:\n")
-		for _, fs := range [][]flagInfo{c.flags, c.stringNonFlags, c.sliceNonFlag} {
-			for _, f := range fs {
-				fprintf(tw, "\t%s\t%s", f.Name, f.Type)
-				if f.Default != "" || f.Env != "" {
-					fprintf(tw, ` (`)
-					if f.Default != "" {
-						fprintf(tw, `default: %s`, f.Default)
-					}
-					if f.Env != "" {
-						if f.Default != "" {
-							fprintf(tw, `, `)
-						}
-						fprintf(tw, `env: %s`, f.Env)
+		nameWidth = c.layout.nameWidth(nameWidth)
+		indent := rowIndent + strings.Repeat(" ", nameWidth+2)
+		usageWidth := wrapWidth(width, len(indent))
+		for _, group := range commandGroups(spec.Commands) {
+			if group == "" {
+				fprintf(tw, "\n%s\n", c.tr(MsgCommands))
+			} else {
+				fprintf(tw, "\n%s\n", c.tr(MsgGroupCommands, group))
+			}
+			for _, cmd := range spec.Commands {
+				if cmd.Group != group {
+					continue
+				}
+				lines := wrapLines(cmd.Usage, usageWidth)
+				if len(lines) == 0 {
+					lines = []string{""}
+				}
+				// names are padded to the widest of all groups so that they
+				// are aligned the same
+				if displayWidth(cmd.Name) > nameWidth {
+					fprintf(tw, "%s%s\n", rowIndent, cmd.Name)
+					if cmd.Usage == "" {
+						continue
 					}
-
-					fprintf(tw, `)`)
+					fprintf(tw, "%s%s\n", indent, lines[0])
+				} else {
+					fprintf(tw, "%s%s  %s\n", rowIndent, padRight(cmd.Name, nameWidth), lines[0])
 				}
-				fprintln(tw)
-				if f.Usage != "" {
-					fprintf(tw, "\t\t%s\n", f.Usage)
+				for _, line := range lines[1:] {
+					fprintf(tw, "%s%s\n", indent, line)
 				}
 			}
 		}
 	}
-	if len(c.subcommands) > 0 {
-		fprintf(tw, "\nCommands:\n")
-		for _, cmd := range c.subcommands {
-			fprintf(tw, "\t%s\t%s\n", cmd.Name, cmd.Usage)
+	_ = tw.Flush()
+
+	// examples are meant to be copy-pasted, so they are neither wrapped nor
+	// aligned by the tabwriter.
+	if len(spec.Examples) > 0 {
+		fprintf(w, "\n%s\n", c.tr(MsgExamples))
+		for _, example := range spec.Examples {
+			fprintf(w, "%s%s\n", rowIndent, example)
+		}
+	}
+	if c.footer != "" {
+		tw = tabWriter(w, 2)
+		fprintf(tw, "\n")
+		for _, line := range wrapLines(c.footer, wrapWidth(width, 0)) {
+			fprintf(tw, "%s\n", line)
+		}
+		_ = tw.Flush()
+	}
+}
+
+// helpRow is a row of the options of help.
+type helpRow struct {
+	name, typ, usage string
+	// attrs are the attributes following the type, such as the default.
+	attrs string
+}
+
+func (c *commandFlags) flagRows(flags []FlagInfo) []helpRow {
+	rows := make([]helpRow, len(flags))
+	for i, f := range flags {
+		rows[i] = helpRow{name: "-" + strings.Join(f.Names, "/-"), typ: f.Type, usage: f.Usage, attrs: c.flagAttrs(f)}
+	}
+	return rows
+}
+
+func (c *commandFlags) positionalRow(p PositionalInfo) helpRow {
+	row := helpRow{name: p.Name, typ: p.Type, usage: p.Usage}
+	if p.Default != "" {
+		row.attrs = "(" + c.tr(MsgDefault, p.Default) + ")"
+	}
+	return row
+}
+
+// helpDefault returns the default of f as help shows it, quoted if it's a
+// string so that spaces and empty strings show.
+func helpDefault(f FlagInfo) string {
+	if f.Type == "string" && !f.Secret && f.Default != "" {
+		return strconv.Quote(f.Default)
+	}
+	return f.Default
+}
+
+// flagAttrs returns the attributes of f shown in help, such as the default,
+// in parentheses, empty if there are none.
+func (c *commandFlags) flagAttrs(f FlagInfo) string {
+	var attrs []string
+	if f.Required {
+		attrs = append(attrs, c.tr(MsgRequired))
+	}
+	switch {
+	case f.Computed:
+		attrs = append(attrs, c.tr(MsgComputedDefault, helpDefault(f)))
+	case f.Default != "":
+		attrs = append(attrs, c.tr(MsgDefault, helpDefault(f)))
+	}
+	if len(f.Choices) > 0 {
+		attrs = append(attrs, c.tr(MsgChoices, strings.Join(f.Choices, "|")))
+	}
+	if f.Env != "" {
+		attrs = append(attrs, c.tr(MsgEnv, f.Env))
+	}
+	if f.Stdin {
+		attrs = append(attrs, c.tr(MsgStdin))
+	}
+	if len(attrs) == 0 {
+		return ""
+	}
+	return "(" + strings.Join(attrs, ", ") + ")"
+}
+
+// printFlags prints the rows of a section of help, the names are padded to
+// the widest of aligned.
+func (c *commandFlags) printFlags(w io.Writer, rows, aligned [][]helpRow, width int) {
+	if c.layout.Inline {
+		c.printInlineFlags(w, rows, aligned, width)
+		return
+	}
+	rowIndent := c.layout.indent()
+	var nameWidth int
+	for _, rs := range aligned {
+		for _, r := range rs {
+			nameWidth = maxInt(nameWidth, displayWidth(r.name))
+		}
+	}
+	nameWidth = c.layout.nameWidth(nameWidth)
+	indent := rowIndent + strings.Repeat(" ", nameWidth+2)
+	usageWidth := wrapWidth(width, len(indent))
+	for _, rs := range rows {
+		for _, r := range rs {
+			fprintf(w, "%s%s  %s", rowIndent, padRight(r.name, nameWidth), r.typ)
+			if r.attrs != "" {
+				fprintf(w, " %s", r.attrs)
+			}
+			fprintln(w)
+			if r.usage != "" {
+				for _, line := range wrapLines(r.usage, usageWidth) {
+					fprintf(w, "%s%s\n", indent, line)
+				}
+			}
+		}
+	}
+}
+
+// printInlineFlags prints the rows with the usages in a column following the
+// names and types, which is aligned for the rows of aligned.
+func (c *commandFlags) printInlineFlags(w io.Writer, rows, aligned [][]helpRow, width int) {
+	rowIndent := c.layout.indent()
+	head := func(r helpRow) string {
+		if r.typ == "" {
+			return r.name
+		}
+		return r.name + " " + r.typ
+	}
+	var headWidth int
+	for _, rs := range aligned {
+		for _, r := range rs {
+			headWidth = maxInt(headWidth, displayWidth(head(r)))
+		}
+	}
+	headWidth = c.layout.nameWidth(headWidth)
+	indent := rowIndent + strings.Repeat(" ", headWidth+2)
+	usageWidth := wrapWidth(width, len(indent))
+	for _, rs := range rows {
+		for _, r := range rs {
+			usage := r.usage
+			if r.attrs != "" {
+				usage = strings.TrimSpace(usage + " " + r.attrs)
+			}
+			lines := wrapLines(usage, usageWidth)
+			switch {
+			case len(lines) == 0:
+				fprintf(w, "%s%s\n", rowIndent, head(r))
+				continue
+			case displayWidth(head(r)) > headWidth:
+				fprintf(w, "%s%s\n", rowIndent, head(r))
+				fprintf(w, "%s%s\n", indent, lines[0])
+			default:
+				fprintf(w, "%s%s  %s\n", rowIndent, padRight(head(r), headWidth), lines[0])
+			}
+			for _, line := range lines[1:] {
+				fprintf(w, "%s%s\n", indent, line)
+			}
 		}
 	}
-	_ = tw.Flush()
 }
 
-func (c *commandFlags) printHelp() {
+func (c *commandFlags) empty(spec *CommandSpec) bool {
+	return !c.command && len(spec.Flags)+len(spec.Positionals)+len(spec.Commands)+len(spec.Examples) == 0 &&
+		spec.Usage == "" && c.header == "" && c.footer == ""
+}
+
+// addHelpFlags defines -h and -help to cmdline unless they are defined
+// already, and returns the value set by them.
+func (c *commandFlags) addHelpFlags(cmdline *flag.FlagSet) *bool {
+	help := new(bool)
+	c.helpNames = nil
+	for _, name := range []string{"h", "help"} {
+		if cmdline.Lookup(name) == nil {
+			cmdline.BoolVar(help, name, false, c.tr(MsgShowHelp))
+			c.helpNames = append(c.helpNames, name)
+		}
+	}
+	c.helpFlags = nil
+	if len(c.helpNames) > 0 {
+		c.helpFlags = []flagInfo{{Name: "-" + strings.Join(c.helpNames, "/-"), Usage: c.tr(MsgShowHelp)}}
+	}
+	return help
+}
+
+// printHelp prints the help to w, unless a UsageFunc prints it.
+func (c *commandFlags) printHelp(w io.Writer) {
 	if c.usage == nil {
-		c.printDefaults(os.Stderr)
+		c.printDefaults(w)
 	} else {
 		c.usage(c.printDefaults)
 	}
 }
 
-var flagValueType = reflect.TypeOf((*flag.Value)(nil)).Elem()
+var (
+	flagValueType = reflect.TypeOf((*flag.Value)(nil)).Elem()
+	typeNamerType = reflect.TypeOf((*typeNamer)(nil)).Elem()
+	durationType  = reflect.TypeOf(time.Duration(0))
+	bytesType     = reflect.TypeOf([]byte(nil))
+	stringsType   = reflect.TypeOf([]string(nil))
+	timeType      = reflect.TypeOf(time.Time{})
+)
+
+// typeNamer may be implemented by flag.Value types to label their value in
+// help output, the same as pflag.Value.Type.
+type typeNamer interface {
+	Type() string
+}
+
+// choiceLister may be implemented by flag.Value types taking one of a few
+// values, which are listed in help and completed.
+type choiceLister interface {
+	Choices() []string
+}
+
+// valueChoices returns the values a flag of type t takes, nil if it isn't a
+// choiceLister.
+func valueChoices(t reflect.Type) []string {
+	fval, _ := newFlagValue(reflect.New(t).Elem())
+	if cl, ok := fval.(choiceLister); ok {
+		return cl.Choices()
+	}
+	return nil
+}
+
+func typeName(t reflect.Type) string {
+	if reflect.PtrTo(t).Implements(typeNamerType) {
+		return reflect.New(t).Interface().(typeNamer).Type()
+	}
+	if fn, ok := typeValues[t]; ok {
+		if tn, ok := fn(reflect.New(t).Elem()).(typeNamer); ok {
+			return tn.Type()
+		}
+	}
+	switch t {
+	case bytesType:
+		return "string"
+	case durationType:
+		return "duration"
+	case timeType:
+		return "time"
+	case tcpAddrType, udpAddrType:
+		return "host:port"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return ""
+	case reflect.Slice:
+		return nonFlagTypeName(t.Elem()) + "s"
+	case reflect.Map:
+		return mapTypeName(t, "=")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "int"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "uint"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.String:
+		return "string"
+	}
+	return "value"
+}
 
 type commonflagValue struct {
 	val reflect.Value
 }
 
-var _ flag.Value = &commonflagValue{}
+var _ flag.Getter = (*commonflagValue)(nil)
+
+// Get returns the value of the field, such as an int or a time.Duration.
+func (p *commonflagValue) Get() interface{} {
+	return p.val.Interface()
+}
 
 func (p *commonflagValue) IsBoolFlag() bool {
 	return p.val.Kind() == reflect.Bool
 }
 
 func (p *commonflagValue) String() string {
+	switch p.val.Type() {
+	case bytesType:
+		return string(p.val.Bytes())
+	case durationType:
+		return time.Duration(p.val.Int()).String()
+	case timeType:
+		t := p.val.Interface().(time.Time)
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	case tcpAddrType, udpAddrType:
+		return addrString(p.val)
+	}
 	switch p.val.Kind() {
 	case reflect.Bool:
 		return strconv.FormatBool(p.val.Bool())
@@ -142,7 +805,7 @@ func (p *commonflagValue) String() string {
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
 		return strconv.FormatUint(p.val.Uint(), 10)
 	case reflect.Float32, reflect.Float64:
-		return strconv.FormatFloat(p.val.Float(), 'f', -1, 64)
+		return strconv.FormatFloat(p.val.Float(), 'f', -1, int(p.val.Type().Size()*8))
 	case reflect.String:
 		return p.val.String()
 	}
@@ -150,32 +813,53 @@ func (p *commonflagValue) String() string {
 }
 
 func (p *commonflagValue) Set(s string) error {
+	switch p.val.Type() {
+	case bytesType:
+		p.val.SetBytes([]byte(s))
+		return nil
+	case durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		p.val.SetInt(int64(d))
+		return nil
+	case timeType:
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return err
+		}
+		p.val.Set(reflect.ValueOf(t))
+		return nil
+	case tcpAddrType, udpAddrType:
+		return setAddr(p.val, s)
+	}
 	switch p.val.Kind() {
 	case reflect.Bool:
-		b, err := strconv.ParseBool(s)
+		b, err := parseBool(s)
 		if err != nil {
 			return err
 		}
 		p.val.SetBool(b)
 		return nil
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		v, err := strconv.ParseInt(s, 10, int(p.val.Type().Size()*8))
+		v, err := strconv.ParseInt(stripUnderscores(s), 10, int(p.val.Type().Size()*8))
 		if err != nil {
-			return err
+			return numError(err, s)
 		}
 		p.val.SetInt(v)
 		return nil
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		v, err := strconv.ParseUint(s, 10, int(p.val.Type().Size()*8))
+		v, err := strconv.ParseUint(stripUnderscores(s), 10, int(p.val.Type().Size()*8))
 		if err != nil {
-			return err
+			return numError(err, s)
 		}
 		p.val.SetUint(v)
 		return nil
 	case reflect.Float32, reflect.Float64:
-		v, err := strconv.ParseFloat(s, int(p.val.Type().Size()*8))
+		v, err := strconv.ParseFloat(stripUnderscores(s), int(p.val.Type().Size()*8))
 		if err != nil {
-			return err
+			return numError(err, s)
 		}
 		p.val.SetFloat(v)
 		return nil
@@ -186,110 +870,1303 @@ func (p *commonflagValue) Set(s string) error {
 	panic("unreachable")
 }
 
-func addFlag(val reflect.Value, cmdline *flag.FlagSet, names []string, env, defstr, usage string, ptr unsafe.Pointer) (string, bool) {
-	iterNames := func(fn func(name string)) {
-		for _, name := range names {
-			fn(name)
+// stripUnderscores removes the underscores separating the digits of the
+// number s, such as 1_000_000, as Go literals do. s is returned as is if an
+// underscore isn't between two digits, for it to be rejected.
+func stripUnderscores(s string) string {
+	if !strings.Contains(s, "_") {
+		return s
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '_' && (i == 0 || i == len(s)-1 || !isDigit(s[i-1]) || !isDigit(s[i+1])) {
+			return s
+		}
+	}
+	return strings.Replace(s, "_", "", -1)
+}
+
+// numError returns the error of parsing the number s, less the underscores,
+// which names s as given.
+func numError(err error, s string) error {
+	if ne, ok := err.(*strconv.NumError); ok {
+		return &strconv.NumError{Func: ne.Func, Num: s, Err: ne.Err}
+	}
+	return err
+}
+
+// parseBool is strconv.ParseBool also accepting yes, on, no and off in any
+// case.
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes", "on":
+		return true, nil
+	case "no", "off":
+		return false, nil
+	}
+	return strconv.ParseBool(s)
+}
+
+// addFlag registers val to cmdline and records the values of the environment
+// variable env, the config and the default to it, which are applied after the
+// command line is parsed. The default is checked when the struct type is
+// compiled. strictBool limits the values of a bool flag of the command line
+// and the environment to the ones of strconv.ParseBool. tr translates the
+// errors of the values.
+func addFlag(tr TranslateFunc, val reflect.Value, cmdline *flag.FlagSet, names []string, seps separators, strictBool bool, env, enval string, enset bool, config []string, defstr, usage string) (_ string, value *sourcedValue, ok bool, err error) {
+	value, ok = newSourcedValue(val, names, seps)
+	if !ok {
+		return "", nil, false, nil
+	}
+	value.strictBool = strictBool && value.IsBoolFlag()
+	if enset {
+		if _, err := value.record(EnvSource, enval); err != nil {
+			return "", nil, false, tr.errorf(MsgInvalidEnvValue, enval, env, names[0], err)
+		}
+	}
+	if len(config) > 0 {
+		values := make([]string, len(config))
+		for i, v := range config {
+			values[i] = configBool(value, v)
+		}
+		if v, err := value.record(ConfigSource, values...); err != nil {
+			return "", nil, false, tr.errorf(MsgInvalidConfigValue, v, names[0], err)
 		}
 	}
+	if defstr != "" {
+		_, _ = value.record(DefaultSource, defstr)
+		// the pairs of maps are shown sorted, and percentages as such
+		switch value.value.(type) {
+		case *mapValue, *percentValue:
+			defstr = defaultString(value)
+		}
+	}
+	for _, name := range names {
+		cmdline.Var(value, name, usage)
+	}
+	return defstr, value, true, nil
+}
+
+// Parser parses flags as configured by its fields. Once configured, it's safe
+// for concurrent parses, into distinct structs: a parse changes nothing of the
+// Parser but the results of the last parse, such as SetFields, which it
+// records as a whole when it's done, so they are those of the parse finished
+// last. The registration methods such as RegisterValidator and Adopt are
+// guarded. The fields and the loaded config must not be changed while
+// parsing.
+type Parser struct {
+	// Name is the program name in help and the prefix of the command
+	// paths, the first argument of the parse is used if it's empty, less
+	// the directory if TrimProgramPath is true. The first argument is
+	// skipped either way.
+	Name            string
+	TrimProgramPath bool
+
+	Usage UsageFunc
+	// HelpWidth is the column width help text is wrapped to, detected from
+	// the terminal when zero.
+	HelpWidth int
+	// HelpLayout arranges the Options and Commands sections of help.
+	HelpLayout HelpLayout
+	// SortFlags sorts the Options section by flag name instead of keeping
+	// the declaration order, RequiredFirst lists required flags before
+	// the others.
+	SortFlags     bool
+	RequiredFirst bool
+	// Examples are listed at the end of the help, one per line.
+	Examples []string
+	// Description is shown above the usage synopsis, Epilog below
+	// everything else.
+	Description string
+	Epilog      string
+
+	// Output is where the help asked for by -h, -help or the help command
+	// is printed, os.Stdout if nil. ErrOutput is where errors and the help
+	// printed along with them go, os.Stderr if nil.
+	Output    io.Writer
+	ErrOutput io.Writer
+
+	// Translator translates help and the errors of parsing, the text is
+	// English if nil.
+	Translator TranslateFunc
+
+	// SecretReader reads the values of the flags tagged with
+	// `secret:"true"` given as "-" or "prompt", such as -token=prompt. If
+	// nil, the prompt is printed to ErrOutput and a line of Stdin is read
+	// with the echo of the terminal disabled.
+	SecretReader SecretReader
+
+	// ExpandValues expands the ${VAR} and $VAR of the values of the command
+	// line and the config by the environment, as the `expand:"true"` tag
+	// does for a flag. `expand:"false"` opts a flag out.
+	ExpandValues bool
+
+	// Stdin is read by the flags tagged with `stdin:"true"` given as "-"
+	// and by the prompts of secret flags, it's os.Stdin if nil.
+	Stdin io.Reader
+
+	// StrictBool takes the values of bool flags of the command line and the
+	// environment as strconv.ParseBool does, instead of also accepting yes,
+	// on, no and off. The defaults and the config accept them either way.
+	StrictBool bool
+
+	// BoolValuesAsNextArg takes the argument after a boolean flag as its
+	// value if it's a boolean, so that -dry-run false is -dry-run=false
+	// rather than -dry-run and a non-flag argument "false". The other
+	// arguments after boolean flags are left as they are.
+	BoolValuesAsNextArg bool
+
+	// ExplicitFlags only makes flags of the fields with a name tag, exported
+	// fields without one are ignored, unless they have other tags of sflag,
+	// such as usage or env, which panics as the name is likely forgotten.
+	ExplicitFlags bool
+
+	// StrictTags panics on the keys of struct tags sflag doesn't know, and
+	// on the ones not applying to their fields, such as short on a
+	// non-flag. The tags of other packages are accepted once registered by
+	// RegisterTags.
+	StrictTags bool
+
+	// GlobalFlagsAnywhere accepts global flags after the command name too,
+	// up to the first non-flag argument of the command, unless the command
+	// defines a flag of the same name.
+	GlobalFlagsAnywhere bool
+
+	// AllowCombinedShort takes the flags of a single letter combined in an
+	// argument, getopt style: -vx is -v -x if both are boolean, and -p8080
+	// is -p 8080 if -p takes a value. The letters are taken as boolean
+	// flags up to the first one taking a value, which takes the rest of the
+	// argument, or the next argument if nothing is left.
+	AllowCombinedShort bool
+
+	// Precedence orders the sources of flag values, the value of a flag is
+	// taken from the first one supplying any. Sources not listed are
+	// ignored. It defaults to FlagSource, EnvSource, ConfigSource,
+	// DefaultSource.
+	Precedence []Source
+
+	// LookupEnv looks up the environment variables of the env tags, it is
+	// os.LookupEnv if nil. The .env files are consulted for the variables
+	// it doesn't find.
+	LookupEnv func(key string) (string, bool)
+
+	// DisableEnv ignores the environment and the .env files, so that the
+	// parse depends on the arguments and the config only. The env tags are
+	// still shown in help.
+	DisableEnv bool
+
+	// EnvCaseInsensitive matches the env tags to the environment ignoring
+	// case when the exact names aren't set, such as $Http_Proxy for
+	// env:"HTTP_PROXY". It's always done on Windows, where the names of the
+	// environment are case-insensitive. Parser.LookupEnv and the .env files
+	// are matched exactly.
+	EnvCaseInsensitive bool
+
+	// DotEnvFiles are .env files of KEY=VALUE lines consulted for the env
+	// tags after the real environment. Missing files are skipped, unless the
+	// path is prefixed by "!" to mark it required.
+	DotEnvFiles []string
+
+	// ConfigFile is loaded before each parse when not empty, its values are
+	// used by that parse only.
+	ConfigFile string
+	// StrictConfig makes unknown config keys an error instead of a warning.
+	StrictConfig bool
+	// ConfigDecoder decodes the config formats without built-in support.
+	ConfigDecoder ConfigDecoder
+	// ConfigEncoder encodes the formats of WriteConfig without built-in
+	// support.
+	ConfigEncoder ConfigEncoder
+
+	// UsageExitCode is the exit code of parse errors in the Must and Run
+	// functions, 2 if zero. Errors implementing ExitCode() int exit with
+	// their own code.
+	UsageExitCode int
+
+	// PrintConfig adds a hidden -print-config flag to the global flags,
+	// which prints the flags by DumpEffective to stdout and makes the parse
+	// return ErrHelp.
+	PrintConfig bool
+
+	// PreParse is called with a flag set to register the flags of other
+	// packages, such as glog or klog, before the global flags are parsed.
+	// They are parsed and listed in help along with the fields, a name
+	// defined by both is an error.
+	PreParse func(fs *flag.FlagSet)
+
+	// PreRun and PostRun are called by RunCommand around every command.
+	PreRun  func(cmd Command, globalFlags interface{}, args []string) error
+	PostRun func(cmd Command, globalFlags interface{}, args []string) error
+
+	// CommandOptional accepts no command after the global flags, instead of
+	// the error "no command to be run". ParseCommand returns a zero Command,
+	// whose Name is empty, and nil args then, and RunCommand calls NoCommand,
+	// or prints the help if it's nil, globalFlags being the struct of the
+	// command for a command of StructCommands without its nested command. The
+	// hooks of PreRun and PostRun aren't called.
+	CommandOptional bool
+	NoCommand       func(globalFlags interface{})
+
+	CommandResolver CommandResolveFunc
+
+	config      config
+	configFiles configFiles
+	// noValidators skips the validate tags, for the functions defining the
+	// flags of a struct without a Parser of the caller.
+	noValidators bool
+
+	// mu guards the fields below.
+	mu         sync.Mutex
+	adopted    []*flag.FlagSet
+	validators map[string]ValidatorFunc
+	// parsed are the flags of the last parse, the global flags first.
+	parsed []*commandFlags
+}
+
+// setParsed records the flags of a parse, the global flags first, once the
+// parse is done, as they aren't changed afterwards.
+func (p *Parser) setParsed(parsed ...*commandFlags) {
+	p.mu.Lock()
+	p.parsed = parsed
+	p.mu.Unlock()
+}
+
+// lastParsed returns the flags of the last parse.
+func (p *Parser) lastParsed() []*commandFlags {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.parsed
+}
+
+func (p *Parser) output() io.Writer {
+	if p.Output == nil {
+		return os.Stdout
+	}
+	return p.Output
+}
+
+func (p *Parser) errOutput() io.Writer {
+	if p.ErrOutput == nil {
+		return os.Stderr
+	}
+	return p.ErrOutput
+}
+
+// programName returns the name of the program in help, arg0 is the first
+// argument of the parse.
+func (p *Parser) programName(arg0 string) string {
+	switch {
+	case p.Name != "":
+		return p.Name
+	case p.TrimProgramPath:
+		return filepath.Base(arg0)
+	}
+	return arg0
+}
+
+// envFold returns the case-insensitive matching of the env tags for a parse,
+// nil if they are matched exactly.
+func (p *Parser) envFold() *envFold {
+	if !p.EnvCaseInsensitive && !envCaseInsensitive {
+		return nil
+	}
+	return &envFold{}
+}
+
+func (p *Parser) newCommandFlags(name string) *commandFlags {
+	return &commandFlags{
+		name:            name,
+		usage:           p.Usage,
+		tr:              p.translator(),
+		secretReader:    p.SecretReader,
+		expandValues:    p.ExpandValues,
+		disableEnv:      p.DisableEnv,
+		envFold:         p.envFold(),
+		stdin:           &stdinSource{r: p.stdin()},
+		output:          p.output(),
+		errOutput:       p.errOutput(),
+		width:           p.HelpWidth,
+		layout:          p.HelpLayout,
+		sortFlags:       p.SortFlags,
+		requiredFirst:   p.RequiredFirst,
+		footer:          p.Epilog,
+		commandOptional: p.CommandOptional,
+		config:          p.config,
+		configFiles:     p.configFiles,
+		configUsed:      make(map[string]bool),
+		lookupEnv:       p.LookupEnv,
+	}
+}
+
+func (p *Parser) newSubcommandFlags(prog string, cmd Command) *commandFlags {
+	flags := p.newCommandFlags(prog + " " + cmd.Name)
+	flags.command = true
+	flags.configPrefix = cmd.Name + "-"
+	if cmd.UsageFunc != nil {
+		flags.usage = cmd.UsageFunc
+	}
+	flags.desc = cmd.Usage
+	flags.examples = cmd.Examples
+	return flags
+}
+
+func (p *Parser) commandHelp(prog string, root *commandFlags, cmd Command) *commandFlags {
+	flags := p.newSubcommandFlags(prog, cmd)
+	cmdline := flag.NewFlagSet(flags.name, flag.ContinueOnError)
+	flagsPtr := cmd.Flags
+	if cmd.commands != nil {
+		flagsPtr = cmd.nestedFlags
+		flags.subcommands = listedCommands(flags.tr, cmd.commands)
+	}
+	if flagsPtr != nil {
+		p.defineFlags(flags, cmdline, flagsPtr)
+	}
+	flags.addHelpFlags(cmdline)
+	if root != nil {
+		flags.globals = root.helpOptions(root.spec())
+	}
+	return flags
+}
+
+func isHelpArgs(args []string) bool {
+	if len(args) > 0 && args[0] == "help" {
+		return true
+	}
+	for _, arg := range args {
+		switch arg {
+		case "--":
+			return false
+		case "-h", "-help", "--help":
+			return true
+		}
+	}
+	return false
+}
+
+// CommandHelp prints the help of cmd and returns ErrHelp if cmdArgs, as
+// returned by ParseCommand, asks for it with -h, --help or a leading "help".
+// The global flags aren't listed, as they are unknown to it, unlike the help
+// printed by ParseCommandFlags and RunCommand.
+func (p *Parser) CommandHelp(prog string, cmd Command, cmdArgs []string) error {
+	return p.commandHelpOf(prog, nil, cmd, cmdArgs)
+}
+
+// commandHelpOf is CommandHelp listing the global flags of root, if it isn't
+// nil.
+func (p *Parser) commandHelpOf(prog string, root *commandFlags, cmd Command, cmdArgs []string) error {
+	if len(cmdArgs) == 0 || !isHelpArgs(cmdArgs[1:]) {
+		return nil
+	}
+	help := p.commandHelp(prog, root, cmd)
+	help.printHelp(help.output)
+	return ErrHelp
+}
+
+// parseCommandFlags parses cmdArgs into the flags of cmd, root is the global
+// flags of the parse. flags are the ones of Command.Flags, nil if it's nil.
+func (p *Parser) parseCommandFlags(root *commandFlags, cmd Command, cmdArgs []string) (flags *commandFlags, err error) {
+	if cmd.Name == "" {
+		// no command by Parser.CommandOptional
+		return nil, nil
+	}
+	if cmd.commands != nil {
+		// left to the parse of the nested commands
+		return nil, nil
+	}
+	prog := root.name
+	if err := p.commandHelpOf(prog, root, cmd, cmdArgs); err != nil {
+		return nil, err
+	}
+	args := cmdArgs[1:]
+	if cmd.Flags != nil {
+		flags = p.newSubcommandFlags(prog, cmd)
+		flags.dotEnv = root.dotEnv
+		flags.envFold = root.envFold
+		flags.stdin = root.stdin
+		_, _, err := p.parseFlags(flags, args, cmd.Flags, nil)
+		if err == nil {
+			err = flags.validate(cmd.Flags)
+		}
+		if err != nil {
+			return flags, err
+		}
+		args = flags.flagSet.Args()
+	}
+	if cmd.Args != nil {
+		if err := cmd.Args(args); err != nil {
+			help := p.commandHelp(prog, root, cmd)
+			help.printUsageLine(help.errOutput, help.spec())
+			return flags, commandArgsError(help.tr, cmd.Name, err)
+		}
+	}
+	return flags, nil
+}
+
+func (p *Parser) resolveSubCommand(flags *commandFlags, commands []Command, args []string) (Command, []string, error) {
+	cmdname := args[0]
+	lookup := func(name string) (Command, bool) {
+		for _, cmd := range commands {
+			if cmd.Name == name {
+				return cmd, true
+			}
+		}
+		return Command{}, false
+	}
+	cmd, ok := lookup(cmdname)
+	if ok {
+		return cmd, args, nil
+	}
+	if cmdname == "help" {
+		if len(args) == 1 {
+			flags.printHelp(flags.output)
+			return Command{}, nil, ErrHelp
+		}
+		if cmd, ok := lookup(args[1]); ok {
+			help := p.commandHelp(flags.name, flags, cmd)
+			help.printHelp(help.output)
+			return Command{}, nil, ErrHelp
+		}
+		return Command{}, nil, unknownCommandError(flags.tr, args[1], commands)
+	}
+
+	if p.CommandResolver != nil {
+		if args, ok := p.CommandResolver(args, commands); ok {
+			cmd, ok := lookup(args[0])
+			if ok {
+				return cmd, args, nil
+			}
+		}
+	}
+
+	return Command{}, nil, unknownCommandError(flags.tr, cmdname, commands)
+}
+
+func unknownCommandError(tr TranslateFunc, name string, commands []Command) error {
+	var names []string
+	for _, cmd := range visibleCommands(commands) {
+		names = append(names, cmd.Name)
+	}
+	// a lone "-" is an argument, such as stdin, rather than a misspelled
+	// command
+	if similar := suggest(name, names); len(similar) > 0 && name != "-" {
+		return tr.errorf(MsgDidYouMean, name, strings.Join(similar, tr(MsgOr)))
+	}
+	return tr.errorf(MsgUnknownCommand, name)
+}
+
+// undefinedFlagPrefix starts the errors of the flag package for undefined
+// flags.
+const undefinedFlagPrefix = "flag provided but not defined: -"
+
+// parseFlagSet parses args by cmdline, the error of an undefined flag
+// suggests the flags of similar names. The error is printed along with the
+// help, as the flag package does.
+func (c *commandFlags) parseFlagSet(cmdline *flag.FlagSet, args []string) error {
+	output, usage := cmdline.Output(), cmdline.Usage
+	cmdline.SetOutput(ioutil.Discard)
+	cmdline.Usage = func() {}
+	err := cmdline.Parse(args)
+	cmdline.SetOutput(output)
+	cmdline.Usage = usage
+	if err == nil {
+		return nil
+	}
+	if strings.HasPrefix(err.Error(), undefinedFlagPrefix) {
+		name := strings.TrimPrefix(err.Error(), undefinedFlagPrefix)
+		if similar := c.suggestFlags(cmdline, name); len(similar) > 0 {
+			err = c.tr.errorf(MsgDidYouMeanFlag, name, strings.Join(similar, c.tr(MsgOr)))
+		}
+	}
+	fprintln(output, err)
+	usage()
+	return err
+}
+
+// suggestFlags returns the flags of names similar to name, the aliases
+// suggest the flags they are of. Hidden flags aren't suggested.
+func (c *commandFlags) suggestFlags(cmdline *flag.FlagSet, name string) []string {
+	var (
+		names []string
+		// flagOf is the first name of the flag of each name
+		flagOf = make(map[string]string)
+	)
+	for _, fs := range [][]flagInfo{c.flags, c.helpFlags} {
+		for _, f := range fs {
+			if f.Hidden {
+				continue
+			}
+			dashed := strings.Split(f.Name, "/")
+			for _, n := range dashed {
+				names = append(names, n[1:])
+				flagOf[n[1:]] = dashed[0]
+			}
+		}
+	}
+	cmdline.VisitAll(func(f *flag.Flag) {
+		if alias, ok := f.Value.(*aliasValue); ok {
+			if first, ok := flagOf[alias.names[0]]; ok {
+				names = append(names, f.Name)
+				flagOf[f.Name] = first
+			}
+		}
+	})
+	var similar []string
+	for _, n := range suggest(name, names) {
+		if !hasName(similar, flagOf[n]) {
+			similar = append(similar, flagOf[n])
+		}
+	}
+	return similar
+}
+
+// parse parses args into the global flags, it returns them along with the
+// command and its arguments.
+func (p *Parser) parse(args []string, flagsPtr interface{}, commands []Command) (root *commandFlags, subcmd Command, subcommand []string, err error) {
+	return p.parseAs(p.programName(args[0]), args[1:], flagsPtr, commands)
+}
+
+// parseAs is parse of the arguments following the program, prog is the name
+// of the program in help.
+func (p *Parser) parseAs(prog string, args []string, flagsPtr interface{}, commands []Command) (root *commandFlags, subcmd Command, subcommand []string, err error) {
+	flags := p.newCommandFlags(prog)
+	flags.subcommands = listedCommands(flags.tr, commands)
+	flags.examples = p.Examples
+	flags.header = p.Description
+	if len(p.DotEnvFiles) > 0 && !p.DisableEnv {
+		if flags.dotEnv, err = p.loadDotEnvFiles(); err != nil {
+			return flags, subcmd, nil, err
+		}
+	}
+	if p.ConfigFile != "" {
+		if err := p.loadConfigFile(flags, p.ConfigFile); err != nil {
+			return flags, subcmd, nil, err
+		}
+	}
+	subcmd, subcommand, err = p.parseFlags(flags, args, flagsPtr, commands)
+	if err == nil && len(commands) > 0 && p.GlobalFlagsAnywhere {
+		subcommand, err = p.extractGlobalFlags(flags, subcmd, subcommand)
+		flags.fillSetInfo()
+	}
+	if err == nil {
+		err = flags.validate(flagsPtr)
+	}
+	return flags, subcmd, subcommand, err
+}
+
+// extractGlobalFlags applies the global flags found after the command name to
+// the global flags and removes them from cmdArgs. Flags also defined by the
+// command are left to it.
+func (p *Parser) extractGlobalFlags(flags *commandFlags, cmd Command, cmdArgs []string) ([]string, error) {
+	var own *flag.FlagSet
+	if cmd.Flags != nil {
+		cmdFlags := p.newSubcommandFlags(flags.name, cmd)
+		own = flag.NewFlagSet(cmdFlags.name, flag.ContinueOnError)
+		p.defineFlags(cmdFlags, own, cmd.Flags)
+	}
+	rest := []string{cmdArgs[0]}
+	for i := 1; i < len(cmdArgs); i++ {
+		arg := cmdArgs[i]
+		// the flags end at the first non-flag argument as the flag package
+		// does, the rest may be the flags of another program
+		if len(arg) < 2 || arg[0] != '-' || arg == "--" {
+			rest = append(rest, cmdArgs[i:]...)
+			break
+		}
+		name := strings.TrimPrefix(arg[1:], "-")
+		value, hasValue := "", false
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value, hasValue = name[:i], name[i+1:], true
+		}
+		if own != nil {
+			if f := own.Lookup(name); f != nil {
+				rest = append(rest, arg)
+				bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+				isBool := ok && bf.IsBoolFlag()
+				if !hasValue && (!isBool || p.isNextBoolValue(cmdArgs, i)) && i+1 < len(cmdArgs) {
+					i++
+					rest = append(rest, cmdArgs[i])
+				}
+				continue
+			}
+		}
+		f := flags.flagSet.Lookup(name)
+		if f == nil || hasName(flags.helpNames, name) {
+			rest = append(rest, arg)
+			continue
+		}
+		if !hasValue {
+			if bf, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && bf.IsBoolFlag() && !p.isNextBoolValue(cmdArgs, i) {
+				value = "true"
+			} else if i+1 < len(cmdArgs) {
+				i++
+				value = cmdArgs[i]
+			} else {
+				return nil, flags.tr.errorf(MsgFlagNeedsArgument, name)
+			}
+		}
+		if err := f.Value.Set(value); err != nil {
+			return nil, flags.tr.errorf(MsgInvalidFlagValue, value, name, err)
+		}
+	}
+	return rest, nil
+}
+
+type structFields struct {
+	nonFlagFields     []nonFlagField
+	nonFlagSliceField reflect.Value
+	nonFlagSlice      flagInfo
+	// afterTerminator is true if the non-flag slice takes the arguments
+	// after "--" only.
+	afterTerminator bool
+	// passthrough is the field taking the arguments after "--" verbatim,
+	// it's invalid if there isn't one.
+	passthrough   reflect.Value
+	values        []*sourcedValue
+	requiredFlags []*sourcedValue
+	requires      []flagRequires
+	oneofGroups   []flagGroup
+	validated     []flagValidators
+	// resolved are the HostPort flags tagged with resolve.
+	resolved []*sourcedValue
+	// tr translates the errors of parsing the fields.
+	tr  TranslateFunc
+	err error
+}
+
+func (p *Parser) parseFlags(flags *commandFlags, args []string, flagsPtr interface{}, commands []Command) (subcmd Command, subcommand []string, err error) {
+	cmdline := flag.NewFlagSet(flags.name, flag.ContinueOnError)
+	// the flag package calls Usage on errors only, as the help flags are
+	// defined
+	cmdline.SetOutput(flags.errOutput)
+	cmdline.Usage = func() { flags.printHelp(flags.errOutput) }
+	flags.flagSet = cmdline
+	flags.flagsPtr = flagsPtr
+	if flagsPtr == nil {
+		if err := p.addForeignFlags(flags, cmdline); err != nil {
+			return subcmd, nil, err
+		}
+		help := flags.addHelpFlags(cmdline)
+		err := flags.parseFlagSet(cmdline, args)
+		if err != nil {
+			return subcmd, nil, err
+		}
+		if *help {
+			flags.printHelp(flags.output)
+			return subcmd, nil, ErrHelp
+		}
+		nonFlagArgs := cmdline.Args()
+		if len(commands) > 0 {
+			if len(nonFlagArgs) == 0 {
+				return subcmd, nil, flags.noCommand()
+			}
+			return p.resolveSubCommand(flags, commands, nonFlagArgs)
+		}
+		if len(nonFlagArgs) > 0 {
+			return subcmd, nil, flags.tr.errorf(MsgNoCommandArguments)
+		}
+		return subcmd, nil, nil
+	}
+
+	if flags.overlay {
+		// the config and the env tags are left to the parse overlaid
+		flags.config, flags.configFiles, flags.skipEnvTags = nil, nil, true
+	} else if err := p.loadConfigFlag(flags, args, flagsPtr); err != nil {
+		return subcmd, nil, err
+	}
+	fields := p.defineFlags(flags, cmdline, flagsPtr)
+	if fields.nonFlagSliceField.IsValid() && !fields.afterTerminator && len(commands) > 0 {
+		panic(newErrorf("non-flag slice field is not allowed with sub commands: %s", fields.nonFlagSlice.Name))
+	}
+	if fields.err != nil {
+		return subcmd, nil, fields.err
+	}
+	if err := p.addForeignFlags(flags, cmdline); err != nil {
+		return subcmd, nil, err
+	}
+	if err := p.checkConfigKeys(flags, commands); err != nil {
+		return subcmd, nil, err
+	}
+	var printConfig bool
+	if p.PrintConfig && !flags.command && cmdline.Lookup("print-config") == nil {
+		cmdline.BoolVar(&printConfig, "print-config", false, "")
+	}
+	help := flags.addHelpFlags(cmdline)
+
+	args, terminated := fields.splitTerminator(args)
+	if p.AllowCombinedShort {
+		args = splitCombinedShorts(cmdline, args)
+	}
+	if p.BoolValuesAsNextArg {
+		args = p.joinBoolValues(cmdline, args)
+	}
+	if fields.numericNonFlags() {
+		args = endFlagsAtNegativeNumber(cmdline, args)
+	}
+	err = flags.parseFlagSet(cmdline, args)
+	if err != nil {
+		return subcmd, nil, err
+	}
+	if *help {
+		flags.printHelp(flags.output)
+		return subcmd, nil, ErrHelp
+	}
+	precedence := p.precedence()
+	if flags.overlay {
+		precedence = []Source{FlagSource}
+		// the rules across flags were checked by the parse overlaid
+		fields.requiredFlags, fields.requires, fields.oneofGroups = nil, nil, nil
+	}
+	for _, value := range fields.values {
+		value.apply(precedence)
+	}
+	if err := fields.resolvePorts(); err != nil {
+		return subcmd, nil, err
+	}
+	flags.fillSetInfo()
+	if printConfig {
+		flags.dumpEffective(flags.output)
+		return subcmd, nil, ErrHelp
+	}
+	for _, value := range fields.requiredFlags {
+		if !value.provided() {
+			return subcmd, nil, flags.tr.errorf(MsgMissingFlag, value.names[0])
+		}
+	}
+	if err := fields.checkFlagRules(); err != nil {
+		return subcmd, nil, err
+	}
+
+	nonflagArgs := cmdline.Args()
+	if flags.overlay {
+		if len(nonflagArgs) > 0 || len(terminated) > 0 {
+			return subcmd, nil, fields.overlayArgsError(append(nonflagArgs, terminated...))
+		}
+		return subcmd, nil, nil
+	}
+	consumedNonFlagArgs, err := fields.setNonFlags(nonflagArgs)
+	if err != nil {
+		return subcmd, nil, err
+	}
+	if err := fields.checkNonFlagSlice(nonflagArgs); err != nil {
+		return subcmd, nil, err
+	}
+	if err := fields.setTerminated(terminated); err != nil {
+		return subcmd, nil, err
+	}
+	if consumedNonFlagArgs < len(nonflagArgs) {
+		if len(commands) == 0 {
+			return subcmd, nil, fields.nonFlagsError(nonflagArgs[consumedNonFlagArgs:])
+		}
+		return p.resolveSubCommand(flags, commands, nonflagArgs[consumedNonFlagArgs:])
+	}
+	if len(commands) > 0 {
+		return subcmd, nil, flags.noCommand()
+	}
+	return subcmd, nil, nil
+}
+
+// noCommand returns the error of no command given, nil if
+// Parser.CommandOptional is set.
+func (c *commandFlags) noCommand() error {
+	if c.commandOptional {
+		return nil
+	}
+	return c.tr.errorf(MsgNoCommand)
+}
+
+// addForeignFlags adds the flags registered by Parser.PreParse and the
+// adopted flag sets to cmdline and the help of the global flags.
+func (p *Parser) addForeignFlags(flags *commandFlags, cmdline *flag.FlagSet) error {
+	if flags.command {
+		return nil
+	}
+	p.mu.Lock()
+	adopted := p.adopted
+	p.mu.Unlock()
+	for _, fs := range adopted {
+		if err := addFlagSet(flags, cmdline, fs, "adopted flag set "+fs.Name()); err != nil {
+			return err
+		}
+	}
+	if p.PreParse == nil {
+		return nil
+	}
+	fs := flag.NewFlagSet(cmdline.Name(), flag.ContinueOnError)
+	p.PreParse(fs)
+	return addFlagSet(flags, cmdline, fs, "PreParse")
+}
+
+// addFlagSet mirrors the flags of fs to cmdline, fs itself isn't parsed.
+func addFlagSet(flags *commandFlags, cmdline *flag.FlagSet, fs *flag.FlagSet, origin string) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		if cmdline.Lookup(f.Name) != nil {
+			err = newErrorf("flag -%s of %s is defined by %s too", f.Name, origin, flags.flagOrigin(f.Name))
+			return
+		}
+		cmdline.Var(f.Value, f.Name, f.Usage)
+		typ, usage := flag.UnquoteUsage(f)
+		if tn, ok := f.Value.(typeNamer); ok {
+			typ = tn.Type()
+		}
+		var def string
+		switch f.DefValue {
+		case "", "0", "false":
+		default:
+			def = f.DefValue
+		}
+		flags.flags = append(flags.flags, flagInfo{
+			Name:    "-" + f.Name,
+			Usage:   usage,
+			Type:    typ,
+			Default: def,
+			NonFlag: true,
+			origin:  origin,
+		})
+	})
+	return err
+}
+
+func (c *commandFlags) flagOrigin(name string) string {
+	for _, f := range c.flags {
+		for _, n := range strings.Split(f.Name, "/") {
+			if n == "-"+name {
+				return f.origin
+			}
+		}
+	}
+	return "another flag"
+}
+
+// Adopt mirrors the flags of fs, such as flag.CommandLine of packages
+// registering flags in init, to the global flags of every parse. They are
+// parsed and listed in help along with the fields, fs itself isn't parsed.
+// A name defined by both is an error.
+func (p *Parser) Adopt(fs *flag.FlagSet) {
+	p.mu.Lock()
+	p.adopted = append(p.adopted, fs)
+	p.mu.Unlock()
+}
+
+// LastFlagSet returns the flag set of the global flags of the last parse,
+// nil if there isn't any.
+func (p *Parser) LastFlagSet() *flag.FlagSet {
+	parsed := p.lastParsed()
+	if len(parsed) == 0 {
+		return nil
+	}
+	return parsed[0].flagSet
+}
+
+// nonFlagField is a non-flag field taking a single argument. It's optional if
+// it has a default, which it's set to when the argument is missing.
+type nonFlagField struct {
+	name  string
+	value flag.Value
+	def   string
+}
+
+// setNonFlag sets the field f to the argument s.
+func (fields *structFields) setNonFlag(f nonFlagField, s string) error {
+	err := f.value.Set(s)
+	if err == nil {
+		return nil
+	}
+	if cv, ok := f.value.(*commonflagValue); ok {
+		return fields.tr.errorf(MsgInvalidArgument, f.name, nonFlagTypeName(cv.val.Type()), s)
+	}
+	return fields.tr.errorf(MsgArgumentError, f.name, err)
+}
+
+// nonFlagTypeName is the type name of a non-flag field in help, which is
+// "bool" for bool fields as they take an argument unlike bool flags.
+func nonFlagTypeName(t reflect.Type) string {
+	if name := typeName(t); name != "" {
+		return name
+	}
+	return "bool"
+}
+
+// setNonFlags sets the non-flag fields to args in order, and returns the
+// number of args consumed.
+func (fields *structFields) setNonFlags(args []string) (int, error) {
+	if trailing := fields.trailing(); len(trailing) > 0 {
+		return fields.setAroundNonFlagSlice(args, trailing)
+	}
+	var consumed int
+	for i, s := range args {
+		if i < len(fields.nonFlagFields) {
+			if err := fields.setNonFlag(fields.nonFlagFields[i], s); err != nil {
+				return consumed, err
+			}
+			consumed = i + 1
+		} else if fields.nonFlagSliceField.IsValid() && !fields.afterTerminator {
+			if err := fields.setNonFlagSlice(args[i:]); err != nil {
+				return consumed, err
+			}
+			consumed = len(args)
+			break
+		} else {
+			consumed = i
+			break
+		}
+	}
+	for i := len(args); i < len(fields.nonFlagFields); i++ {
+		f := fields.nonFlagFields[i]
+		if f.def == "" {
+			return consumed, fields.tr.errorf(MsgMissingArgument, f.name)
+		}
+		// checked when defined
+		_ = f.value.Set(f.def)
+	}
+	return consumed, nil
+}
+
+// trailing returns the non-flag fields after the non-flag slice which takes
+// the arguments between them and the ones before it.
+func (fields *structFields) trailing() []nonFlagField {
+	if !fields.nonFlagSliceField.IsValid() || fields.afterTerminator {
+		return nil
+	}
+	return fields.nonFlagFields[fields.nonFlagSlice.at:]
+}
+
+// setAroundNonFlagSlice sets the non-flag fields before the non-flag slice
+// to the leading args, the ones after it to the trailing args and the slice
+// to the args between them. All the fields are required, as the trailing ones
+// can't have defaults.
+func (fields *structFields) setAroundNonFlagSlice(args []string, trailing []nonFlagField) (int, error) {
+	leading := fields.nonFlagFields[:fields.nonFlagSlice.at]
+	if len(args) < len(fields.nonFlagFields) {
+		for i, f := range fields.nonFlagFields {
+			if i == len(args) {
+				return i, fields.tr.errorf(MsgMissingArgument, f.name)
+			}
+			if err := fields.setNonFlag(f, args[i]); err != nil {
+				return i, err
+			}
+		}
+	}
+	for i, f := range leading {
+		if err := fields.setNonFlag(f, args[i]); err != nil {
+			return i, err
+		}
+	}
+	end := len(args) - len(trailing)
+	if err := fields.setNonFlagSlice(args[len(leading):end]); err != nil {
+		return len(leading), err
+	}
+	for i, f := range trailing {
+		if err := fields.setNonFlag(f, args[end+i]); err != nil {
+			return end + i, err
+		}
+	}
+	return len(args), nil
+}
+
+// isNonFlagSlice reports whether t is a slice taking the rest of non-flag
+// arguments, which is a slice of the types of non-flag fields.
+func isNonFlagSlice(t reflect.Type) bool {
+	if t.Kind() != reflect.Slice || reflect.PtrTo(t).Implements(flagValueType) {
+		return false
+	}
+	_, ok := newFlagValue(reflect.New(t.Elem()).Elem())
+	return ok
+}
+
+// setNonFlagSlice sets the non-flag slice to args converted to the element
+// type, it's left as is if any of them is invalid.
+func (fields *structFields) setNonFlagSlice(args []string) error {
+	slice := reflect.MakeSlice(fields.nonFlagSliceField.Type(), 0, len(args))
+	for i, s := range args {
+		elem := reflect.New(slice.Type().Elem()).Elem()
+		f := nonFlagField{name: fmt.Sprintf("%s[%d]", fields.nonFlagSlice.Name, i)}
+		f.value, _ = newFlagValue(elem)
+		if err := fields.setNonFlag(f, s); err != nil {
+			return err
+		}
+		slice = reflect.Append(slice, elem)
+	}
+	fields.nonFlagSliceField.Set(slice)
+	return nil
+}
+
+// numericNonFlags reports whether the first non-flag argument may be a
+// number, which may be negative.
+func (fields *structFields) numericNonFlags() bool {
+	var t reflect.Type
+	if len(fields.nonFlagFields) > 0 {
+		cv, ok := fields.nonFlagFields[0].value.(*commonflagValue)
+		if !ok {
+			return false
+		}
+		t = cv.val.Type()
+	} else if fields.nonFlagSliceField.IsValid() {
+		t = fields.nonFlagSliceField.Type().Elem()
+	} else {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Float32, reflect.Float64:
+		return t != durationType
+	}
+	return false
+}
+
+// splitCombinedShorts splits the arguments combining flags of a single letter
+// into one argument for each flag, followed by the value attached to the last
+// one if any. Arguments not made of the letters of defined flags are left
+// as is, and so are the arguments after the flags.
+func splitCombinedShorts(cmdline *flag.FlagSet, args []string) []string {
+	var split []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || len(arg) < 2 || arg[0] != '-' {
+			return append(split, args[i:]...)
+		}
+		name := strings.TrimLeft(arg, "-")
+		if j := strings.IndexByte(name, '='); j >= 0 {
+			name = name[:j]
+		}
+		var takesValue bool
+		if f := cmdline.Lookup(name); f != nil || arg[1] == '-' {
+			split = append(split, arg)
+			takesValue = f != nil && !isBoolValue(f.Value) && !strings.Contains(arg, "=")
+		} else if shorts, needsValue, ok := splitShorts(cmdline, arg[1:]); ok {
+			split = append(split, shorts...)
+			takesValue = needsValue
+		} else {
+			split = append(split, arg)
+		}
+		if takesValue && i+1 < len(args) {
+			// the next argument is the value
+			i++
+			split = append(split, args[i])
+		}
+	}
+	return split
+}
+
+// splitShorts splits s into the flags of its letters, ok is false if a
+// letter isn't a flag. The letters are boolean flags up to the first one
+// taking a value, which takes the rest of s, needsValue is true if nothing is
+// left for it.
+func splitShorts(cmdline *flag.FlagSet, s string) (shorts []string, needsValue, ok bool) {
+	for i, r := range s {
+		name := string(r)
+		f := cmdline.Lookup(name)
+		if f == nil {
+			return nil, false, false
+		}
+		shorts = append(shorts, "-"+name)
+		if isBoolValue(f.Value) {
+			continue
+		}
+		rest := s[i+len(name):]
+		if rest == "" {
+			return shorts, true, true
+		}
+		return append(shorts, rest), false, true
+	}
+	return shorts, false, true
+}
+
+func isBoolValue(v flag.Value) bool {
+	bf, ok := v.(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
+
+// joinBoolValues joins the boolean flags of args followed by a boolean with
+// it, as in -dry-run=false, up to the first non-flag argument.
+func (p *Parser) joinBoolValues(cmdline *flag.FlagSet, args []string) []string {
+	var joined []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || len(arg) < 2 || arg[0] != '-' {
+			return append(joined, args[i:]...)
+		}
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			joined = append(joined, arg)
+			continue
+		}
+		f := cmdline.Lookup(name)
+		switch {
+		case f == nil:
+			joined = append(joined, arg)
+		case isBoolValue(f.Value):
+			if p.isNextBoolValue(args, i) {
+				i++
+				arg += "=" + args[i]
+			}
+			joined = append(joined, arg)
+		default:
+			// the next argument is the value
+			joined = append(joined, arg)
+			if i+1 < len(args) {
+				i++
+				joined = append(joined, args[i])
+			}
+		}
+	}
+	return joined
+}
+
+// isNextBoolValue reports whether the argument after args[i], a boolean
+// flag, is its value by Parser.BoolValuesAsNextArg.
+func (p *Parser) isNextBoolValue(args []string, i int) bool {
+	if !p.BoolValuesAsNextArg || i+1 >= len(args) {
+		return false
+	}
+	var err error
+	if p.StrictBool {
+		_, err = strconv.ParseBool(args[i+1])
+	} else {
+		_, err = parseBool(args[i+1])
+	}
+	return err == nil
+}
+
+// endFlagsAtNegativeNumber inserts "--" before the first negative number of
+// args which isn't the value of a flag, so that it's taken as a non-flag
+// argument rather than an undefined flag.
+func endFlagsAtNegativeNumber(cmdline *flag.FlagSet, args []string) []string {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || len(arg) < 2 || arg[0] != '-' {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if j := strings.IndexByte(name, '='); j >= 0 {
+			name = name[:j]
+		}
+		f := cmdline.Lookup(name)
+		if f == nil {
+			if _, err := strconv.ParseFloat(arg, 64); err == nil {
+				return append(append(args[:i:i], "--"), args[i:]...)
+			}
+			continue
+		}
+		if bf, ok := f.Value.(interface{ IsBoolFlag() bool }); (!ok || !bf.IsBoolFlag()) && !strings.Contains(arg, "=") {
+			// the next argument is the value
+			i++
+		}
+	}
+	return args
+}
+
+// splitTerminator splits args at the first "--" if the non-flag slice or the
+// passthrough field takes the arguments after it, terminated is nil if there
+// isn't one.
+func (fields *structFields) splitTerminator(args []string) (_, terminated []string) {
+	if !fields.afterTerminator && !fields.passthrough.IsValid() {
+		return args, nil
+	}
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i:i], args[i+1:]
+		}
+	}
+	return args, nil
+}
+
+// setTerminated sets the non-flag slice or the passthrough field taking the
+// arguments after "--" to terminated.
+func (fields *structFields) setTerminated(terminated []string) error {
+	if fields.passthrough.IsValid() {
+		if terminated != nil {
+			fields.passthrough.Set(reflect.ValueOf(append(make([]string, 0, len(terminated)), terminated...)))
+		}
+		return nil
+	}
+	if !fields.afterTerminator {
+		return nil
+	}
+	if err := fields.setNonFlagSlice(terminated); err != nil {
+		return err
+	}
+	return fields.checkSliceCount(terminated)
+}
 
-	var fval flag.Value
-	switch val.Kind() {
-	case reflect.Bool,
-		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
-		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
-		reflect.Float32, reflect.Float64,
-		reflect.String:
-		fval = &commonflagValue{val}
-	default:
-		if !reflect.PtrTo(val.Type()).Implements(flagValueType) {
-			return "", false
-		}
-		fval = val.Addr().Interface().(flag.Value)
+// checkNonFlagSlice checks the number of args taken by the non-flag slice
+// against the min and max tags of it.
+func (fields *structFields) checkNonFlagSlice(args []string) error {
+	if !fields.nonFlagSliceField.IsValid() || fields.afterTerminator {
+		return nil
 	}
-	var valApplied bool
-	if env != "" {
-		enval := os.Getenv(env)
-		if enval != "" {
-			valApplied = fval.Set(enval) == nil
-		}
+	var extra []string
+	if len(args) > len(fields.nonFlagFields) {
+		leading := fields.nonFlagSlice.at
+		extra = args[leading : len(args)-len(fields.nonFlagFields)+leading]
 	}
+	return fields.checkSliceCount(extra)
+}
 
-	if defstr != "" {
-		if !valApplied {
-			valApplied = fval.Set(defstr) == nil
-		}
+// checkSliceCount checks the number of args taken by the non-flag slice.
+func (fields *structFields) checkSliceCount(extra []string) error {
+	f := fields.nonFlagSlice
+	if len(extra) < f.Min {
+		return fields.tr.errorf(MsgTooFewArguments, f.Min, f.Name, fields.tr(plural(f.Min, MsgArgument, MsgArguments)), len(extra))
 	}
-	if defstr != "" && val.Kind() == reflect.String {
-		defstr = strconv.Quote(defstr)
+	if f.Max > 0 && len(extra) > f.Max {
+		return fields.tr.errorf(MsgTooManyArguments, f.Max, f.Name, fields.tr(plural(f.Max, MsgArgument, MsgArguments)), len(extra), extra[f.Max:])
 	}
-	iterNames(func(name string) {
-		cmdline.Var(fval, name, usage)
-	})
-
-	return defstr, true
+	return nil
 }
 
-type Parser struct {
-	Usage UsageFunc
-
-	CommandResolver CommandResolveFunc
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
 }
 
-func (p *Parser) resolveSubCommand(commands []Command, args []string) (Command, []string, error) {
-	cmdname := args[0]
-	lookup := func(name string) (Command, bool) {
-		for _, cmd := range commands {
-			if cmd.Name == name {
-				return cmd, true
-			}
-		}
-		return Command{}, false
+// nonFlagsError is the error of the extra args exceeding the non-flag fields.
+func (fields *structFields) nonFlagsError(extra []string) error {
+	quoted := make([]string, len(extra))
+	for i, s := range extra {
+		quoted[i] = strconv.Quote(s)
 	}
-	cmd, ok := lookup(cmdname)
-	if ok {
-		return cmd, args, nil
+	unexpected := fields.tr(plural(len(extra), MsgUnexpectedArgument, MsgUnexpectedArguments), strings.Join(quoted, " "))
+	if len(fields.nonFlagFields) == 0 {
+		return fields.tr.errorf(MsgNoArgumentsExpected, unexpected)
 	}
-
-	if p.CommandResolver != nil {
-		if args, ok := p.CommandResolver(args, commands); ok {
-			cmd, ok := lookup(args[0])
-			if ok {
-				return cmd, args, nil
-			}
-		}
+	names := make([]string, len(fields.nonFlagFields))
+	for i, f := range fields.nonFlagFields {
+		names[i] = f.name
 	}
+	return fields.tr.errorf(MsgExpectedArguments, unexpected, strings.Join(names, " "))
+}
 
-	return Command{}, nil, newErrorf("unknown command: %s", cmdname)
+// overlayArgsError reports the non-flag arguments of an overlay, which sets
+// flags only.
+func (fields *structFields) overlayArgsError(extra []string) error {
+	quoted := make([]string, len(extra))
+	for i, s := range extra {
+		quoted[i] = strconv.Quote(s)
+	}
+	unexpected := fields.tr(plural(len(extra), MsgUnexpectedArgument, MsgUnexpectedArguments), strings.Join(quoted, " "))
+	return fields.tr.errorf(MsgNoArgumentsExpected, unexpected)
 }
 
-func (p *Parser) parse(args []string, flagsPtr interface{}, commands []Command) (subcmd Command, subcommand []string, err error) {
-	flags := commandFlags{
-		name:        args[0],
-		subcommands: commands,
-		usage:       p.Usage,
+// countTag returns the non-negative number of the tag key of field, zero if
+// it's absent.
+func countTag(field reflect.StructField, key string) int {
+	s := field.Tag.Get(key)
+	if s == "" {
+		return 0
 	}
-	cmdline := flag.NewFlagSet(args[0], flag.ContinueOnError)
-	cmdline.Usage = flags.printHelp
-	if flagsPtr == nil {
-		// check for help flag
-		err := cmdline.Parse(args[1:])
-		if err != nil {
-			return subcmd, nil, err
-		}
-		nonFlagArgs := cmdline.Args()
-		if len(commands) > 0 {
-			if len(nonFlagArgs) == 0 {
-				return subcmd, nil, newErrorf("no command to be run")
-			}
-			return p.resolveSubCommand(commands, nonFlagArgs)
-		}
-		if len(nonFlagArgs) > 0 {
-			return subcmd, nil, newErrorf("the command should be runs without arguments")
-		}
-		return subcmd, nil, nil
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		panic(newErrorf("invalid %s tag of non-flag field %s: %q", key, field.Name, s))
 	}
+	return n
+}
 
+func (p *Parser) defineFlags(flags *commandFlags, cmdline *flag.FlagSet, flagsPtr interface{}) *structFields {
 	refv := reflect.ValueOf(flagsPtr)
 	if refv.Kind() != reflect.Ptr {
 		panic("expect pointer of struct")
@@ -298,179 +2175,406 @@ func (p *Parser) parse(args []string, flagsPtr interface{}, commands []Command)
 	if refv.Kind() != reflect.Struct {
 		panic("expect pointer of struct")
 	}
-	numField := refv.NumField()
-	reft := refv.Type()
-
-	var (
-		nonFlagStringFields []reflect.Value
-		nonFlagSliceField   reflect.Value
-	)
 
-	for i := 0; i < numField; i++ {
-		fval := refv.Field(i)
-		ftyp := reft.Field(i)
-		ptr := unsafe.Pointer(fval.UnsafeAddr())
-		if ftyp.Anonymous {
+	if p.StrictTags {
+		checkTags(refv.Type())
+	}
+	fields := structFields{tr: flags.tr}
+	for _, d := range describeStruct(refv.Type(), p.ExplicitFlags).fields {
+		parent := embeddedValue(refv, d.embed)
+		fval := parent.Field(d.index)
+		switch d.kind {
+		case setInfoField:
+			flags.setInfo = fval
 			continue
-		}
-
-		name := ftyp.Tag.Get("name")
-		usage := ftyp.Tag.Get("usage")
-		env := ftyp.Tag.Get("env")
-		if name == "-" {
+		case nonFlagSliceKind:
+			fields.nonFlagSliceField = fval
+			fields.afterTerminator = d.afterTerminator
+			fields.nonFlagSlice = flagInfo{
+				Name:            d.name,
+				Usage:           d.usage,
+				Type:            nonFlagTypeName(d.typ.Elem()),
+				NonFlagSlice:    true,
+				Min:             d.min,
+				Max:             d.max,
+				afterTerminator: d.afterTerminator,
+				at:              d.at,
+			}
+			fields.nonFlagSlice.expandUsage(valueChoices(d.typ.Elem()))
+			flags.sliceNonFlag = append(flags.sliceNonFlag, fields.nonFlagSlice)
 			continue
-		}
-		if strings.HasPrefix(name, "#") {
-			name := strings.TrimPrefix(name, "#")
-			if name == "" {
-				name = strings.ToUpper(ftyp.Name)
+		case passthroughKind:
+			fields.passthrough = fval
+			// listed as a non-flag slice after "--"
+			info := flagInfo{
+				Name:            d.name,
+				Usage:           d.usage,
+				Type:            "string",
+				NonFlagSlice:    true,
+				afterTerminator: true,
 			}
-			switch {
-			case ftyp.Type.Kind() == reflect.String:
-				nonFlagStringFields = append(nonFlagStringFields, fval)
-				flags.stringNonFlags = append(flags.stringNonFlags, flagInfo{
-					Name:    name,
-					Usage:   usage,
-					Type:    "string",
-					NonFlag: true,
-				})
-			case ftyp.Type == reflect.TypeOf((*[]string)(nil)).Elem():
-				if nonFlagSliceField.IsValid() {
-					panic(newErrorf("duplicated non-flag field of type []string: %s", ftyp.Name))
+			info.expandUsage(nil)
+			flags.sliceNonFlag = append(flags.sliceNonFlag, info)
+			continue
+		case nonFlagFieldKind:
+			value, _ := newFlagValue(fval)
+			fields.nonFlagFields = append(fields.nonFlagFields, nonFlagField{d.name, value, d.def})
+			info := flagInfo{
+				Name:    d.name,
+				Usage:   d.usage,
+				Type:    nonFlagTypeName(d.typ),
+				Default: d.def,
+				NonFlag: true,
+			}
+			info.expandUsage(valueChoices(d.typ))
+			flags.nonFlags = append(flags.nonFlags, info)
+			continue
+		}
+		if d.implicit && p.ExplicitFlags {
+			ftyp := parent.Type().Field(d.index)
+			for _, key := range tagKeys(ftyp.Tag) {
+				if sflagTags[key] {
+					panic(newErrorf("field %s has the %s tag but no name tag, which Parser.ExplicitFlags requires", ftyp.Name, key))
 				}
-				nonFlagSliceField = fval
-				flags.sliceNonFlag = append(flags.sliceNonFlag, flagInfo{
-					Name:         name,
-					Usage:        usage,
-					Type:         "string",
-					NonFlagSlice: true,
-				})
-			default:
-				panic(newErrorf("only string/[]string allowed for non-flag field: %s", ftyp.Name))
 			}
-
 			continue
 		}
 
-		if name == "" {
-			if ftyp.Name == "" || !isExported(ftyp.Name) {
+		configValues, configFile := flags.configValues(d.names)
+		expand := flags.expandFunc(d.names[0], d.expand)
+		if expand != nil && len(configValues) > 0 {
+			var err error
+			if configValues, err = expandAll(configValues, expand); err != nil {
+				if fields.err == nil {
+					fields.err = err
+				}
 				continue
 			}
-			v, asShort := ftyp.Tag.Lookup("short")
-			if asShort {
-				if v != "" {
-					asShort, _ = strconv.ParseBool(v)
+		}
+		var (
+			envName, enval string
+			enset          bool
+		)
+		if !flags.skipEnvTags {
+			envName, enval, enset = flags.lookupEnvName(d.env)
+		}
+		if enval == "" && d.ignoreEmptyEnv {
+			enset = false
+		}
+		if enset && d.fromFile {
+			path := enval
+			var err error
+			if enval, err = readValueFile(path); err != nil {
+				if fields.err == nil {
+					fields.err = newErrorf("invalid value %q for $%s (flag -%s): %v", path, envName, d.names[0], err)
 				}
-			}
-			if asShort {
-				name = strings.ToLower(ftyp.Name[:1])
-			} else {
-				name = strings.ToLower(ftyp.Name[:1]) + ftyp.Name[1:]
+				continue
 			}
 		}
-
-		names := splitAndTrim(name)
-		defstr := ftyp.Tag.Get("default")
-		defstr, ok := addFlag(fval, cmdline, names, env, defstr, usage, ptr)
-		if !ok {
+		def := d.def
+		if d.defaultMethod != "" && !flags.overlay {
+			def = computeDefault(parent, d.defaultMethod, d.seps)
+		}
+		defstr, value, _, err := addFlag(flags.tr, fval, cmdline, d.names, d.seps, p.StrictBool, envName, enval, enset, configValues, def, d.usage)
+		if err != nil {
+			if fields.err == nil {
+				fields.err = err
+			}
 			continue
 		}
+		value.configFile = configFile
+		if enset {
+			value.envName = envName
+		}
+		value.expand = expand
+		value.fromFile = d.fromFile
+		value.appendDefault = d.appendDefault
+		for _, alias := range d.aliases {
+			cmdline.Var(&aliasValue{
+				sourcedValue: value,
+				warning:      flags.tr(MsgDeprecatedAlias, alias, d.names[0]),
+				out:          flags.errOutput,
+			}, alias, "")
+		}
+		if d.deprecated != "" {
+			value.deprecated = d.deprecated
+			value.warning = flags.tr(MsgDeprecatedFlag, d.names[0], d.deprecated)
+			value.warnOut = flags.errOutput
+		}
+		if d.stdin {
+			value.readStdin = flags.stdinFunc(d.names[0])
+		}
+		if d.secret {
+			value.prompt = flags.promptFunc(d.names[0])
+			if defstr != "" {
+				defstr = secretMask
+			}
+		}
+		fields.values = append(fields.values, value)
+		if d.required {
+			fields.requiredFlags = append(fields.requiredFlags, value)
+		}
+		if d.resolve {
+			fields.resolved = append(fields.resolved, value)
+		}
+		if len(d.requires) > 0 {
+			fields.requires = append(fields.requires, flagRequires{value, d.requires})
+		}
+		if len(d.validate) > 0 && !p.noValidators {
+			fields.validated = append(fields.validated, p.flagValidators(d.field, value, d.validate))
+		}
+		for _, group := range d.oneof {
+			addToGroup(&fields.oneofGroups, group, value)
+		}
 
-		for i := range names {
-			names[i] = "-" + names[i]
+		dashed := make([]string, len(d.names))
+		for i := range d.names {
+			dashed[i] = "-" + d.names[i]
 		}
-		flags.flags = append(flags.flags, flagInfo{
-			Name:    strings.Join(names, "/"),
-			Usage:   usage,
-			Type:    ftyp.Type.Kind().String(),
-			Env:     env,
-			Default: defstr,
-			NonFlag: true,
-		})
-	}
-	if nonFlagSliceField.IsValid() && len(commands) > 0 {
-		panic(newErrorf("non-flag field of type []string is not allowed with sub commands: %s", flags.sliceNonFlag[0].Name))
+		info := flagInfo{
+			Name:     strings.Join(dashed, "/"),
+			Usage:    d.usage,
+			Type:     d.typeName(),
+			Choices:  valueChoices(d.typ),
+			Env:      d.env,
+			Default:  defstr,
+			Required: d.required,
+			NonFlag:  true,
+			Hidden:   d.hidden,
+			Secret:   d.secret,
+			Config:   d.isConfig,
+			Stdin:    d.stdin,
+			Computed: d.defaultMethod != "" && defstr != "",
+			value:    value,
+			origin:   "field " + d.field,
+		}
+		if info.expandUsage(info.Choices); info.Usage != d.usage {
+			for _, name := range d.names {
+				cmdline.Lookup(name).Usage = info.Usage
+			}
+		}
+		flags.flags = append(flags.flags, info)
 	}
+	return &fields
+}
 
-	err = cmdline.Parse(args[1:])
-	if err != nil {
-		return subcmd, nil, err
+// embeddedValue returns the struct embedded in refv by the path of field
+// indexes embed, nil pointers along the path are allocated.
+func embeddedValue(refv reflect.Value, embed []int) reflect.Value {
+	for _, i := range embed {
+		refv = refv.Field(i)
+		if refv.Kind() != reflect.Ptr {
+			continue
+		}
+		if refv.IsNil() {
+			if !refv.CanSet() {
+				panic(newErrorf("can't allocate the nil embedded field %s, which is unexported", refv.Type().Elem().Name()))
+			}
+			refv.Set(reflect.New(refv.Type().Elem()))
+		}
+		refv = refv.Elem()
 	}
+	return refv
+}
 
-	nonflagArgs := cmdline.Args()
+// computeDefault returns the default of a field computed by the method of
+// the struct refv, the string of its result.
+func computeDefault(refv reflect.Value, method string, seps separators) string {
+	result := refv.Addr().MethodByName(method).Call(nil)[0]
+	val := reflect.New(result.Type()).Elem()
+	val.Set(result)
+	fval, _ := seps.newValue(val)
+	return fval.String()
+}
 
-	var consumedNonFlagArgs int
-	for i, s := range nonflagArgs {
-		if i < len(nonFlagStringFields) {
-			nonFlagStringFields[i].SetString(s)
-			consumedNonFlagArgs = i + 1
-		} else if nonFlagSliceField.IsValid() {
-			nonFlagSliceField.Set(reflect.ValueOf(nonflagArgs[i:]))
-			consumedNonFlagArgs = len(nonflagArgs)
-			break
-		} else {
-			consumedNonFlagArgs = i
-			break
-		}
+func defaultFlagName(ftyp reflect.StructField) string {
+	if ftyp.Name == "" || !isExported(ftyp.Name) {
+		return ""
 	}
-	if consumedNonFlagArgs < len(nonflagArgs) {
-		if len(commands) == 0 {
-			if len(nonFlagStringFields) == 0 {
-				return subcmd, nil, newErrorf("non-flag args not allowed: %v", nonflagArgs)
-			}
-			return subcmd, nil, newErrorf("accept only %d non-flag args: %v", len(nonFlagStringFields), nonflagArgs)
+	v, asShort := ftyp.Tag.Lookup("short")
+	if asShort {
+		if v != "" {
+			asShort, _ = strconv.ParseBool(v)
 		}
-		return p.resolveSubCommand(commands, nonflagArgs[consumedNonFlagArgs:])
 	}
-	if len(commands) > 0 {
-		return subcmd, nil, newErrorf("no command to be run")
+	if asShort {
+		return strings.ToLower(ftyp.Name[:1])
 	}
-	return subcmd, nil, nil
+	return strings.ToLower(ftyp.Name[:1]) + ftyp.Name[1:]
 }
 
 func (p *Parser) Parse(args []string, ptr interface{}) error {
-	_, _, err := p.parse(args, ptr, nil)
+	root, _, _, err := p.parse(args, ptr, nil)
+	p.setParsed(root)
+	return err
+}
+
+// ParseOverlay parses args on top of the last parse of the struct flagsPtr
+// points to, such as the arguments of a file reread on SIGHUP. Only the flags
+// given in args are set, the others keep their values, as the environment,
+// the config and the defaults aren't applied again, and non-flag arguments
+// are an error. The expand tags still expand the variables of the environment
+// in args. The required, requires and oneof-required rules are left to the
+// parse overlaid, the validate tags and the Validate method are checked.
+// SetFields and the SetInfo field keep the sources of the flags not given.
+func (p *Parser) ParseOverlay(args []string, flagsPtr interface{}) error {
+	flags := p.newCommandFlags(p.programName(args[0]))
+	flags.examples = p.Examples
+	flags.header = p.Description
+	flags.overlay = true
+	for _, parsed := range p.lastParsed() {
+		if parsed.flagsPtr == flagsPtr {
+			flags.baseSources = parsed.sources("")
+			break
+		}
+	}
+	_, _, err := p.parseFlags(flags, args[1:], flagsPtr, nil)
+	if err == nil {
+		err = flags.validate(flagsPtr)
+	}
+	p.setParsed(flags)
 	return err
 }
 
 func (p *Parser) MustParse(args []string, flags interface{}) {
 	err := p.Parse(args, flags)
-	handleError(err)
+	p.handleError(err, true)
 }
 
 func (p *Parser) ParseCommand(args []string, globalFlags interface{}, commands ...Command) (cmd Command, cmdArgs []string, err error) {
 	if len(commands) == 0 {
 		panic("should provide at least one command.")
 	}
-	return p.parse(args, globalFlags, commands)
+	root, cmd, cmdArgs, err := p.parse(args, globalFlags, commands)
+	p.setParsed(root)
+	return cmd, cmdArgs, err
+}
+
+// ParseCommandFlags is like ParseCommand, but also handles the help of the
+// command and parses its arguments into Command.Flags when it is set.
+func (p *Parser) ParseCommandFlags(args []string, globalFlags interface{}, commands ...Command) (cmd Command, cmdArgs []string, err error) {
+	_, cmd, cmdArgs, err = p.parseCommand(p.programName(args[0]), args[1:], globalFlags, commands)
+	return cmd, cmdArgs, err
+}
+
+// parseCommand is ParseCommandFlags of the arguments following the program
+// prog, returning the global flags too.
+func (p *Parser) parseCommand(prog string, args []string, globalFlags interface{}, commands []Command) (root *commandFlags, cmd Command, cmdArgs []string, err error) {
+	if len(commands) == 0 {
+		panic("should provide at least one command.")
+	}
+	root, cmd, cmdArgs, err = p.parseAs(prog, args, globalFlags, commands)
+	if err != nil {
+		p.setParsed(root)
+		return nil, cmd, nil, err
+	}
+	flags, err := p.parseCommandFlags(root, cmd, cmdArgs)
+	if flags != nil {
+		p.setParsed(root, flags)
+	} else {
+		p.setParsed(root)
+	}
+	if err != nil {
+		return nil, cmd, nil, err
+	}
+	return root, cmd, cmdArgs, nil
 }
 
 func (p *Parser) MustParseCommand(args []string, globalFlags interface{}, commands ...Command) (cmd Command, cmdArgs []string) {
 	cmd, cmdArgs, err := p.ParseCommand(args, globalFlags, commands...)
-	handleError(err)
+	p.handleError(err, true)
 	return cmd, cmdArgs
 }
 
 func (p *Parser) RunCommand(args []string, globalFlags interface{}, commands ...Command) {
-	cmd, cmdArgs, err := p.ParseCommand(args, globalFlags, commands...)
+	p.RunCommandContext(context.Background(), args, globalFlags, commands...)
+}
+
+// RunCommandContext is like RunCommand, ctx is passed to Command.RunCtx.
+func (p *Parser) RunCommandContext(ctx context.Context, args []string, globalFlags interface{}, commands ...Command) {
+	root, cmd, cmdArgs, err := p.parseCommand(p.programName(args[0]), args[1:], globalFlags, commands)
 	if err != nil {
-		handleError(err)
+		p.handleError(err, true)
 		return
 	}
+	err = p.runCommand(ctx, root, globalFlags, cmd, cmdArgs)
+	p.handleError(err, false)
+}
+
+// RunCommandE is like RunCommand, but returns the error of parsing or of the
+// command instead of exiting.
+func (p *Parser) RunCommandE(args []string, globalFlags interface{}, commands ...Command) error {
+	root, cmd, cmdArgs, err := p.parseCommand(p.programName(args[0]), args[1:], globalFlags, commands)
+	if err != nil {
+		return err
+	}
+	return p.runCommand(context.Background(), root, globalFlags, cmd, cmdArgs)
+}
 
-	if globalFlags == nil {
-		if cmd.Run != nil {
-			cmd.Run(cmdArgs)
+// runCommand runs the hooks outermost first: Parser.PreRun, Command.PreRun,
+// the handler, Command.PostRun, Parser.PostRun. An error of a PreRun aborts
+// the run, the PostRuns are called only if the handler ran.
+func (p *Parser) runCommand(ctx context.Context, root *commandFlags, globalFlags interface{}, cmd Command, cmdArgs []string) error {
+	if cmd.Name == "" {
+		// no command by Parser.CommandOptional
+		if p.NoCommand != nil {
+			p.NoCommand(globalFlags)
 		} else {
-			panic(newErrorf("Command.Run is nil: %s", cmd.Name))
+			root.printHelp(root.output)
 		}
-	} else {
-		if cmd.Run != nil {
-			cmd.Run(cmdArgs)
-		} else {
-			panic(newErrorf("Command.Run is nil: %s", cmd.Name))
+		return nil
+	}
+	if p.PreRun != nil {
+		if err := p.PreRun(cmd, globalFlags, cmdArgs); err != nil {
+			return err
+		}
+	}
+	if cmd.PreRun != nil {
+		if err := cmd.PreRun(cmdArgs); err != nil {
+			return err
+		}
+	}
+	err := p.runHandler(ctx, root, cmd, cmdArgs)
+	if cmd.PostRun != nil {
+		if perr := cmd.PostRun(cmdArgs); err == nil {
+			err = perr
+		}
+	}
+	if p.PostRun != nil {
+		if perr := p.PostRun(cmd, globalFlags, cmdArgs); err == nil {
+			err = perr
+		}
+	}
+	return err
+}
+
+func (p *Parser) runHandler(ctx context.Context, root *commandFlags, cmd Command, cmdArgs []string) error {
+	if cmd.commands != nil {
+		nested, sub, subArgs, err := p.parseCommand(root.name+" "+cmd.Name, cmdArgs[1:], cmd.nestedFlags, cmd.commands)
+		if err != nil && !errors.Is(err, ErrHelp) {
+			err = usageError{err}
 		}
+		if err != nil {
+			return err
+		}
+		return p.runCommand(ctx, nested, cmd.nestedFlags, sub, subArgs)
+	}
+	var err error
+	switch {
+	case cmd.RunCtx != nil:
+		err = cmd.RunCtx(ctx, cmdArgs)
+	case cmd.RunE != nil:
+		err = cmd.RunE(cmdArgs)
+	case cmd.Run != nil:
+		cmd.Run(cmdArgs)
+	default:
+		panic(newErrorf("Command.Run, Command.RunE and Command.RunCtx are nil: %s", cmd.Name))
+	}
+	if errors.Is(err, ErrHelp) {
+		help := p.commandHelp(root.name, root, cmd)
+		help.printHelp(help.output)
 	}
+	return err
 }
 
 func Parse(args []string, ptr interface{}) error {
@@ -482,27 +2586,140 @@ func MustParse(args []string, ptr interface{}) {
 func ParseCommand(args []string, globalFlagsPtr interface{}, commands ...Command) (cmd Command, cmdArgs []string, err error) {
 	return (&Parser{}).ParseCommand(args, globalFlagsPtr, commands...)
 }
+func ParseCommandFlags(args []string, globalFlagsPtr interface{}, commands ...Command) (cmd Command, cmdArgs []string, err error) {
+	return (&Parser{}).ParseCommandFlags(args, globalFlagsPtr, commands...)
+}
 func MustParseCommand(args []string, globalFlagsPtr interface{}, commands ...Command) (cmd Command, cmdArgs []string) {
 	return (&Parser{}).MustParseCommand(args, globalFlagsPtr, commands...)
 }
 func RunCommand(args []string, globalFlagsPtr interface{}, commands ...Command) {
 	(&Parser{}).RunCommand(args, globalFlagsPtr, commands...)
 }
-func handleError(err error) {
-	if err != nil {
-		if errors.Is(err, ErrHelp) {
-			os.Exit(0)
-		} else {
-			var se sflagError
-			if errors.As(err, &se) {
-				fprintln(os.Stderr, err)
-			}
-			os.Exit(1)
+func RunCommandContext(ctx context.Context, args []string, globalFlagsPtr interface{}, commands ...Command) {
+	(&Parser{}).RunCommandContext(ctx, args, globalFlagsPtr, commands...)
+}
+func RunCommandE(args []string, globalFlagsPtr interface{}, commands ...Command) error {
+	return (&Parser{}).RunCommandE(args, globalFlagsPtr, commands...)
+}
+func ParseArgs(flagsPtr interface{}) error {
+	return (&Parser{}).ParseArgs(flagsPtr)
+}
+func Run(flagsPtr interface{}, commands ...Command) {
+	(&Parser{}).Run(flagsPtr, commands...)
+}
+
+// ParseArgs parses os.Args into the struct flagsPtr points to.
+func (p *Parser) ParseArgs(flagsPtr interface{}) error {
+	return p.Parse(os.Args, flagsPtr)
+}
+
+// Run parses os.Args into the struct flagsPtr points to and runs the command
+// of them, the way RunCommand does. Without commands, it returns once the
+// flags are parsed. It exits on errors, or with 0 once help is printed.
+func (p *Parser) Run(flagsPtr interface{}, commands ...Command) {
+	if len(commands) == 0 {
+		p.MustParse(os.Args, flagsPtr)
+		return
+	}
+	p.RunCommand(os.Args, flagsPtr, commands...)
+}
+
+var osExit = os.Exit
+
+// handleError prints err and exits with the code of it. Errors of parsing
+// are usage errors, the flag package has already printed its own ones.
+func (p *Parser) handleError(err error, usage bool) {
+	if err == nil {
+		return
+	}
+	if errors.As(err, new(usageError)) {
+		usage = true
+	}
+	if errors.Is(err, ErrHelp) {
+		osExit(0)
+		return
+	}
+	var (
+		se   sflagError
+		ve   validationError
+		ee   ExitError
+		ec   interface{ ExitCode() int }
+		code = 1
+	)
+	if usage {
+		code = p.UsageExitCode
+		if code == 0 {
+			code = 2
 		}
 	}
+	if errors.As(err, &ec) {
+		code = ec.ExitCode()
+	}
+	switch {
+	case errors.As(err, &ee) && ee.Err == nil:
+	case !usage || errors.As(err, &se) || errors.As(err, &ve):
+		fprintln(p.errOutput(), err)
+	}
+	osExit(code)
+}
+
+// usageError is an error of parsing the arguments of nested commands, which
+// is reported as the ones of the top level.
+type usageError struct {
+	error
+}
+
+func (e usageError) Unwrap() error {
+	return e.error
+}
+
+// ExitError makes RunCommand exit with Code, Err is printed if not nil.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e ExitError) Error() string {
+	if e.Err == nil {
+		return "exit status " + strconv.Itoa(e.Code)
+	}
+	return e.Err.Error()
+}
+func (e ExitError) Unwrap() error {
+	return e.Err
+}
+func (e ExitError) ExitCode() int {
+	return e.Code
 }
 func tabWriter(out io.Writer, width int) *tabwriter.Writer {
-	return tabwriter.NewWriter(out, 0, 0, width, ' ', 0)
+	return tabwriter.NewWriter(&trimSpaceWriter{w: out}, 0, 0, width, ' ', 0)
+}
+
+// trimSpaceWriter drops the trailing padding tabwriter leaves after empty
+// cells.
+type trimSpaceWriter struct {
+	w       io.Writer
+	pending int
+}
+
+func (t *trimSpaceWriter) Write(p []byte) (int, error) {
+	buf := make([]byte, 0, len(p))
+	for _, b := range p {
+		switch b {
+		case ' ':
+			t.pending++
+			continue
+		case '\n':
+			t.pending = 0
+		default:
+			for ; t.pending > 0; t.pending-- {
+				buf = append(buf, ' ')
+			}
+		}
+		buf = append(buf, b)
+	}
+	_, err := t.w.Write(buf)
+	return len(p), err
 }
 
 func isExported(name string) bool {
@@ -523,6 +2740,140 @@ func splitAndTrim(name string) []string {
 	return names[:end]
 }
 
+// Wrap wraps s to lines of at most width columns, indenting every line but the
+// first by indent spaces so the text hangs under a description column that
+// starts at indent.
+func Wrap(s string, width, indent int) string {
+	lines := wrapLines(s, wrapWidth(width, indent))
+	return strings.Join(lines, "\n"+strings.Repeat(" ", indent))
+}
+
+const minWrapWidth = 20
+
+func wrapWidth(width, indent int) int {
+	if width <= 0 {
+		return 0
+	}
+	return maxInt(width-indent, minWrapWidth)
+}
+
+func wrapLines(s string, width int) []string {
+	if s == "" {
+		return nil
+	}
+	var lines []string
+	for _, para := range strings.Split(s, "\n") {
+		if width <= 0 {
+			lines = append(lines, para)
+			continue
+		}
+		var (
+			line      string
+			lineWidth int
+			words     = strings.Fields(para)
+		)
+		if len(words) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		for _, word := range words {
+			w := displayWidth(word)
+			if line != "" && lineWidth+1+w > width {
+				lines = append(lines, line)
+				line, lineWidth = "", 0
+			}
+			for w > width {
+				if line != "" {
+					lines = append(lines, line)
+					line, lineWidth = "", 0
+				}
+				head := splitWidth(word, width)
+				lines = append(lines, word[:head])
+				word = word[head:]
+				w = displayWidth(word)
+			}
+			if line != "" {
+				line += " "
+				lineWidth++
+			}
+			line += word
+			lineWidth += w
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// splitWidth returns the length of the longest prefix of s taking at most
+// width columns, at least one rune so that a wide rune wider than width
+// isn't split forever.
+func splitWidth(s string, width int) int {
+	var n, w int
+	for i, r := range s {
+		rw := displayWidth(string(r))
+		if i > 0 && w+rw > width {
+			return i
+		}
+		n, w = i+utf8.RuneLen(r), w+rw
+	}
+	return n
+}
+
+// suggest returns the candidates within a small edit distance of name or
+// having it as prefix, a candidate sharing no rune with name, such as
+// another single letter, isn't similar.
+func suggest(name string, candidates []string) []string {
+	var similar []string
+	for _, c := range candidates {
+		d := editDistance(strings.ToLower(name), strings.ToLower(c))
+		if (d <= maxInt(1, utf8.RuneCountInString(name)/3) && d < utf8.RuneCountInString(c)) || (len(name) > 1 && strings.HasPrefix(c, name)) {
+			similar = append(similar, c)
+		}
+	}
+	return similar
+}
+
+// editDistance is the optimal string alignment distance of a and b, which
+// counts a transposition of adjacent runes as one edit.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(minInt(d[i-1][j]+1, d[i][j-1]+1), d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 func fprintf(w io.Writer, format string, v ...interface{}) {
 	_, _ = fmt.Fprintf(w, format, v...)
 }
@@ -540,3 +2891,8 @@ func newErrorf(format string, v ...interface{}) error {
 func (e sflagError) Error() string {
 	return e.err
 }
+
+// padRight pads s with spaces to width columns.
+func padRight(s string, width int) string {
+	return s + strings.Repeat(" ", maxInt(width-displayWidth(s), 0))
+}
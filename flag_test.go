@@ -0,0 +1,1603 @@
+package sflag
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// helpOutput returns the help printed by p for args, which must ask for it.
+func helpOutput(t *testing.T, p *Parser, args []string, flagsPtr interface{}) string {
+	t.Helper()
+	var out bytes.Buffer
+	p.Usage = func(printDefaults func(w io.Writer)) { printDefaults(&out) }
+	if err := p.Parse(args, flagsPtr); err != ErrHelp {
+		t.Fatalf("Parse(%q) = %v, want ErrHelp", args, err)
+	}
+	return out.String()
+}
+
+func checkHelp(t *testing.T, got, want string) {
+	t.Helper()
+	if got != want {
+		t.Errorf("help:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+type labelValue string
+
+func (l *labelValue) String() string     { return string(*l) }
+func (l *labelValue) Set(s string) error { *l = labelValue(s); return nil }
+func (l *labelValue) Type() string       { return "label" }
+
+func TestHelpTypeNames(t *testing.T) {
+	var flags struct {
+		Verbose bool
+		Timeout time.Duration
+		Since   time.Time
+		Config  File
+		Limit   Size
+		Count   int64
+		Ratio   float64
+		Name    string
+		Label   labelValue
+	}
+	checkHelp(t, helpOutput(t, &Parser{}, []string{"prog", "-h"}, &flags), `Usage: prog [OPTION]...
+
+Options:
+  -verbose
+  -timeout  duration
+  -since    time
+  -config   file
+  -limit    size
+  -count    int
+  -ratio    float
+  -name     string
+  -label    label
+  -h/-help
+            show this help
+`)
+}
+
+func TestRequiredFlags(t *testing.T) {
+	t.Parallel()
+	type options struct {
+		Token string `name:"token,t" env:"SFLAG_TEST_TOKEN" required:"true"`
+		Debug bool
+	}
+	var flags options
+	if err := Parse([]string{"prog", "-debug"}, &flags); err == nil || err.Error() != "missing required flag: -token" {
+		t.Errorf("Parse without -token = %v", err)
+	}
+	if err := Parse([]string{"prog", "-t", "x"}, &flags); err != nil || flags.Token != "x" {
+		t.Errorf("Parse(-t x) = %v, %+v", err, flags)
+	}
+	flags = options{}
+	p := &Parser{LookupEnv: mapEnv(map[string]string{"SFLAG_TEST_TOKEN": "y"})}
+	if err := p.Parse([]string{"prog"}, &flags); err != nil || flags.Token != "y" {
+		t.Errorf("Parse with $SFLAG_TEST_TOKEN = %v, %+v", err, flags)
+	}
+	if out := helpOutput(t, &Parser{}, []string{"prog", "-h"}, &options{}); !bytes.Contains([]byte(out), []byte("  -token/-t  string (required, env: SFLAG_TEST_TOKEN)\n")) {
+		t.Errorf("help = %q", out)
+	}
+}
+
+func TestHelpFlagOrder(t *testing.T) {
+	type options struct {
+		Zeta  string `usage:"last"`
+		Beta  bool   `name:"Beta,b"`
+		Yank  string `required:"true"`
+		Apple int
+		Dir   string `name:"#DIR"`
+	}
+	tests := []struct {
+		sort, requiredFirst bool
+		want                string
+	}{
+		{false, false, `
+  -zeta     string
+            last
+  -Beta/-b
+  -yank     string (required)
+  -apple    int
+`},
+		{true, false, `
+  -apple    int
+  -Beta/-b
+  -yank     string (required)
+  -zeta     string
+            last
+`},
+		{true, true, `
+  -yank     string (required)
+  -apple    int
+  -Beta/-b
+  -zeta     string
+            last
+`},
+		{false, true, `
+  -yank     string (required)
+  -zeta     string
+            last
+  -Beta/-b
+  -apple    int
+`},
+	}
+	for _, tt := range tests {
+		p := &Parser{SortFlags: tt.sort, RequiredFirst: tt.requiredFirst}
+		var flags options
+		checkHelp(t, helpOutput(t, p, []string{"prog", "-h"}, &flags), `Usage: prog [OPTION]... -yank YANK DIR
+
+Options:`+tt.want+`  DIR       string
+  -h/-help
+            show this help
+`)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	for _, c := range []struct {
+		s             string
+		width, indent int
+		want          string
+	}{
+		{"", 30, 4, ""},
+		{"short", 30, 4, "short"},
+		// the lines after the first hang under the indent
+		{"the quick brown fox jumps over the lazy dog", 30, 4, "the quick brown fox jumps\n    over the lazy dog"},
+		{"first\nsecond line", 30, 2, "first\n  second line"},
+		// the width is at least 20 columns whatever the indent
+		{"aaaa bbbb cccc dddd eeee", 10, 8, "aaaa bbbb cccc dddd\n        eeee"},
+		// words longer than the width are split
+		{"x abcdefghijklmnopqrstuvwxyz", 20, 0, "x\nabcdefghijklmnopqrst\nuvwxyz"},
+		// multi-byte runes are kept whole
+		{"ééééééééééééééééééééééé", 20, 0, "éééééééééééééééééééé\nééé"},
+		// wide runes take two columns
+		{"主机主机主机主机主机主机", 20, 0, "主机主机主机主机主机\n主机"},
+		{"主 机", 21, 0, "主 机"},
+		// no wrapping without a width
+		{"the quick brown fox", 0, 4, "the quick brown fox"},
+	} {
+		if got := Wrap(c.s, c.width, c.indent); got != c.want {
+			t.Errorf("Wrap(%q, %d, %d) = %q, want %q", c.s, c.width, c.indent, got, c.want)
+		}
+	}
+}
+
+func TestHelpWidth(t *testing.T) {
+	var out bytes.Buffer
+	for _, c := range []struct {
+		width, want int
+	}{
+		// not a terminal
+		{0, defaultHelpWidth},
+		{40, 40},
+	} {
+		flags := (&Parser{HelpWidth: c.width}).newCommandFlags("prog")
+		if got := flags.helpWidth(&out); got != c.want {
+			t.Errorf("helpWidth with HelpWidth %d = %d, want %d", c.width, got, c.want)
+		}
+	}
+}
+
+func TestHelpExamples(t *testing.T) {
+	var out bytes.Buffer
+	p := &Parser{
+		HelpWidth: 30,
+		Examples:  []string{"prog serve -port 8080 -dir /srv/www/a/long/path"},
+		Usage:     func(printDefaults func(w io.Writer)) { printDefaults(&out) },
+	}
+	var global struct{ V bool }
+	commands := []Command{{Name: "serve", Usage: "serve files", Examples: []string{"prog serve -port 80"}}}
+	if _, _, err := p.ParseCommand([]string{"prog", "-h"}, &global, commands...); err != ErrHelp {
+		t.Fatalf("ParseCommand = %v, want ErrHelp", err)
+	}
+	checkHelp(t, out.String(), `Usage: prog [OPTION] COMMAND [ARGUMENT]...
+
+Options:
+  -v
+  -h/-help
+            show this help
+
+Commands:
+  serve  serve files
+  help   show help of the
+         program or a command
+
+Examples:
+  prog serve -port 8080 -dir /srv/www/a/long/path
+`)
+	out.Reset()
+	cmd, cmdArgs, err := p.ParseCommand([]string{"prog", "serve", "-h"}, &global, commands...)
+	if err != nil {
+		t.Fatalf("ParseCommand: %v", err)
+	}
+	if err := p.CommandHelp("prog", cmd, cmdArgs); err != ErrHelp {
+		t.Fatalf("CommandHelp = %v, want ErrHelp", err)
+	}
+	checkHelp(t, out.String(), `Usage: prog serve
+
+serve files
+
+Examples:
+  prog serve -port 80
+`)
+}
+
+func TestHelpExamplesOfUsageFunc(t *testing.T) {
+	var flags struct{ V bool }
+	var out bytes.Buffer
+	p := &Parser{Examples: []string{"prog -v"}}
+	p.Usage = func(printDefaults func(w io.Writer)) {
+		fmt.Fprintln(&out, "custom")
+		printDefaults(&out)
+	}
+	if err := p.Parse([]string{"prog", "-h"}, &flags); err != ErrHelp {
+		t.Fatalf("Parse = %v, want ErrHelp", err)
+	}
+	checkHelp(t, out.String(), `custom
+Usage: prog [OPTION]
+
+Options:
+  -v
+  -h/-help
+            show this help
+
+Examples:
+  prog -v
+`)
+}
+
+func TestHelpDescriptionAndEpilog(t *testing.T) {
+	var flags struct{ V bool }
+	p := &Parser{
+		HelpWidth:   40,
+		Description: "Prog copies files between hosts, resuming interrupted transfers.",
+		Epilog:      "Report bugs to https://example.com/issues and include the output of -version.",
+	}
+	checkHelp(t, helpOutput(t, p, []string{"prog", "-h"}, &flags), `Prog copies files between hosts,
+resuming interrupted transfers.
+
+Usage: prog [OPTION]
+
+Options:
+  -v
+  -h/-help
+            show this help
+
+Report bugs to
+https://example.com/issues and include
+the output of -version.
+`)
+	help := helpOutput(t, &Parser{}, []string{"prog", "-h"}, &flags)
+	checkHelp(t, help, `Usage: prog [OPTION]
+
+Options:
+  -v
+  -h/-help
+            show this help
+`)
+	for _, line := range strings.Split(help, "\n") {
+		if strings.TrimRight(line, " ") != line {
+			t.Errorf("trailing spaces in help line %q", line)
+		}
+	}
+}
+
+func TestHiddenCommand(t *testing.T) {
+	var out bytes.Buffer
+	p := &Parser{Usage: func(printDefaults func(w io.Writer)) { printDefaults(&out) }}
+	var ran []string
+	commands := []Command{
+		{Name: "serve", Usage: "serve files", Run: func([]string) { ran = append(ran, "serve") }},
+		{Name: "migrate", Usage: "migrate the data", Hidden: true, Run: func([]string) { ran = append(ran, "migrate") }},
+	}
+	cmd, cmdArgs, err := p.ParseCommand([]string{"prog", "migrate"}, nil, commands...)
+	if err != nil {
+		t.Fatalf("ParseCommand: %v", err)
+	}
+	cmd.Run(cmdArgs)
+	if len(ran) != 1 || ran[0] != "migrate" {
+		t.Errorf("ran %q, want the hidden command", ran)
+	}
+	if _, _, err := p.ParseCommand([]string{"prog", "-h"}, nil, commands...); err != ErrHelp {
+		t.Fatalf("ParseCommand = %v, want ErrHelp", err)
+	}
+	checkHelp(t, out.String(), `Usage: prog COMMAND [ARGUMENT]...
+
+Commands:
+  serve  serve files
+  help   show help of the program or a command
+`)
+}
+
+func TestPreParse(t *testing.T) {
+	type options struct {
+		Name string
+	}
+	var verbosity *int
+	p := &Parser{PreParse: func(fs *flag.FlagSet) {
+		verbosity = fs.Int("v", 1, "log `level`")
+		fs.Bool("logtostderr", false, "log to stderr")
+	}}
+	var flags options
+	if err := p.Parse([]string{"prog", "-name", "a", "-v", "3"}, &flags); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if flags.Name != "a" || *verbosity != 3 {
+		t.Errorf("-name %q, -v %d, want both parsed", flags.Name, *verbosity)
+	}
+	if f := p.LastFlagSet().Lookup("v"); f == nil || f.Value.String() != "3" {
+		t.Errorf("LastFlagSet().Lookup(v) = %v, want the flag of PreParse", f)
+	}
+	checkHelp(t, helpOutput(t, p, []string{"prog", "-h"}, &flags), `Usage: prog [OPTION]...
+
+Options:
+  -name         string
+  -logtostderr
+                log to stderr
+  -v            level (default: 1)
+                log level
+  -h/-help
+                show this help
+`)
+
+	p.PreParse = func(fs *flag.FlagSet) { fs.String("name", "", "") }
+	if err := p.Parse([]string{"prog"}, &flags); err == nil || err.Error() != "flag -name of PreParse is defined by field Name too" {
+		t.Errorf("Parse = %v, want the collision", err)
+	}
+}
+
+func TestVisitFlags(t *testing.T) {
+	var flags struct {
+		Zeta    string `usage:"last" env:"VISIT_ZETA"`
+		Workers int    `name:"workers,w" default:"4"`
+		Debug   bool   `hidden:"true"`
+		Dir     string `name:"#DIR"`
+	}
+	p := &Parser{
+		SortFlags: true,
+		PreParse:  func(fs *flag.FlagSet) { fs.Int("v", 1, "verbosity") },
+	}
+	if err := p.Parse([]string{"prog", "-zeta", "z", "-debug", "dir"}, &flags); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	var got []string
+	p.VisitFlags(func(f Flag) {
+		got = append(got, fmt.Sprintf("%s %s=%q default %q env %q hidden %v %s set %v",
+			f.Type, strings.Join(f.Names, ","), f.Value, f.Default, f.Env, f.Hidden, f.Source, f.IsSet()))
+	})
+	want := []string{
+		` debug="true" default "" env "" hidden true flag set true`,
+		`int v="1" default "1" env "" hidden false none set false`,
+		`int workers,w="4" default "4" env "" hidden false default set false`,
+		`string zeta="z" default "" env "VISIT_ZETA" hidden false flag set true`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("VisitFlags:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestFlagGetter(t *testing.T) {
+	var flags struct {
+		Port    int               `name:"port"`
+		Timeout time.Duration     `name:"timeout"`
+		Tags    []string          `name:"tag"`
+		Labels  map[string]string `name:"label"`
+		Rate    float64           `name:"rate" format:"percent"`
+		Token   string            `name:"token" secret:"true"`
+	}
+	p := &Parser{PreParse: func(fs *flag.FlagSet) { fs.Uint("v", 1, "verbosity") }}
+	args := []string{"prog", "-port", "42", "-timeout", "1m", "-tag", "a,b", "-label", "k=v", "-rate", "5%", "-token", "t"}
+	if err := p.Parse(args, &flags); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"port":    42,
+		"timeout": time.Minute,
+		"tag":     []string{"a", "b"},
+		"label":   map[string]string{"k": "v"},
+		"rate":    0.05,
+		"token":   "t",
+		"v":       uint(1),
+		"h":       false,
+		"help":    false,
+	}
+	p.LastFlagSet().VisitAll(func(f *flag.Flag) {
+		if got := f.Value.(flag.Getter).Get(); !reflect.DeepEqual(got, want[f.Name]) {
+			t.Errorf("Get of -%s = %#v, want %#v", f.Name, got, want[f.Name])
+		}
+	})
+	want["token"] = nil
+	p.VisitFlags(func(f Flag) {
+		if !reflect.DeepEqual(f.Typed, want[f.Names[0]]) {
+			t.Errorf("Typed of -%s = %#v, want %#v", f.Names[0], f.Typed, want[f.Names[0]])
+		}
+	})
+
+	v := &commonflagValue{reflect.ValueOf(new(int)).Elem()}
+	_ = v.Set("42")
+	if got := v.Get(); got != interface{}(42) {
+		t.Errorf("Get = %#v, want int(42)", got)
+	}
+}
+
+func TestMarshalArgs(t *testing.T) {
+	type options struct {
+		Name    string
+		Workers int `name:"workers,w" default:"4"`
+		Verbose bool
+		Color   bool `default:"true"`
+		Timeout time.Duration
+		Limit   Size
+		Ratio   float32
+		Dir     string   `name:"#DIR" default:"."`
+		Files   []string `name:"#"`
+	}
+	for _, args := range [][]string{
+		{"-name", "a b", "-w", "8", "-verbose", "-color=false", "-timeout", "90s", "-limit", "1.5k", "-ratio", "0.1", "dir", "f1", "f2"},
+		{"-w", "4", "--", "-dir"},
+		{},
+	} {
+		var flags options
+		if err := Parse(append([]string{"prog"}, args...), &flags); err != nil {
+			t.Fatalf("Parse %q: %v", args, err)
+		}
+		marshaled, err := MarshalArgs(&flags)
+		if err != nil {
+			t.Fatalf("MarshalArgs: %v", err)
+		}
+		var parsed options
+		if err := Parse(append([]string{"prog"}, marshaled...), &parsed); err != nil {
+			t.Fatalf("Parse %q: %v", marshaled, err)
+		}
+		if !reflect.DeepEqual(parsed, flags) {
+			t.Errorf("Parse(MarshalArgs(%q)) = %+v, want %+v", args, parsed, flags)
+		}
+	}
+
+	flags := options{Workers: 4, Color: true, Dir: "d"}
+	if args, _ := MarshalArgs(&flags); strings.Join(args, " ") != "d" {
+		t.Errorf("MarshalArgs = %q, want only the non-flag", args)
+	}
+	args, _ := MarshalAllArgs(&flags)
+	if want := "-name= -workers=4 -verbose=false -color=true -timeout=0s -limit=0 -ratio=0 d"; strings.Join(args, " ") != want {
+		t.Errorf("MarshalAllArgs = %q, want %q", args, want)
+	}
+}
+
+func TestMarshalArgsPassthrough(t *testing.T) {
+	type options struct {
+		Image string   `name:"image"`
+		Args  []string `name:"#ARG"`
+		Rest  []string `name:"#REST" passthrough:"true"`
+	}
+	for _, c := range []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-image", "foo", "a", "--", "/bin/sh", "-c", "echo hi"}, []string{"-image=foo", "a", "--", "/bin/sh", "-c", "echo hi"}},
+		{[]string{"a", "--"}, []string{"a", "--"}},
+		{[]string{"a"}, []string{"a"}},
+	} {
+		var flags options
+		if err := Parse(append([]string{"prog"}, c.args...), &flags); err != nil {
+			t.Fatalf("Parse %q: %v", c.args, err)
+		}
+		marshaled, err := MarshalArgs(&flags)
+		if err != nil || !reflect.DeepEqual(marshaled, c.want) {
+			t.Errorf("MarshalArgs(%q) = %q, %v, want %q", c.args, marshaled, err, c.want)
+			continue
+		}
+		var parsed options
+		if err := Parse(append([]string{"prog"}, marshaled...), &parsed); err != nil || !reflect.DeepEqual(parsed, flags) {
+			t.Errorf("Parse(MarshalArgs(%q)) = %+v, %v, want %+v", c.args, parsed, err, flags)
+		}
+	}
+
+	flags := options{Args: []string{"-x"}}
+	if _, err := MarshalArgs(&flags); err == nil || err.Error() != `non-flag argument "-x" can't be marshaled before --` {
+		t.Errorf("MarshalArgs = %v, want the non-flag looking like a flag rejected", err)
+	}
+}
+
+func TestMarshalArgsDetached(t *testing.T) {
+	// MarshalArgs has no Parser to take LookupEnv
+	os.Setenv("ZZ_WORKERS", "abc")
+	defer os.Unsetenv("ZZ_WORKERS")
+	flags := detachedOpts{Name: "x", Workers: 2}
+	if args, err := MarshalArgs(&flags); err != nil || !reflect.DeepEqual(args, []string{"-name=x", "-workers=2"}) {
+		t.Errorf("MarshalArgs = %q, %v, want the values of the struct", args, err)
+	}
+	if flags.DetachedCommon != nil {
+		t.Error("MarshalArgs allocated the embedded pointer")
+	}
+}
+
+func TestAdopt(t *testing.T) {
+	legacy := flag.NewFlagSet("legacy", flag.ContinueOnError)
+	workers := legacy.Int("workers", 2, "number of `worker`s")
+	limit := Size(0)
+	legacy.Var(&limit, "limit", "memory limit")
+	var flags struct {
+		Name string
+	}
+	p := &Parser{}
+	p.Adopt(legacy)
+	if err := p.Parse([]string{"prog", "-workers", "8", "-limit", "1k", "-name", "a"}, &flags); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if *workers != 8 || limit != 1024 || flags.Name != "a" {
+		t.Errorf("-workers %d, -limit %d, -name %q, want all parsed", *workers, limit, flags.Name)
+	}
+	if legacy.Parsed() {
+		t.Errorf("the adopted flag set is parsed")
+	}
+	checkHelp(t, helpOutput(t, p, []string{"prog", "-h"}, &flags), `Usage: prog [OPTION]...
+
+Options:
+  -name     string
+  -limit    size
+            memory limit
+  -workers  worker (default: 2)
+            number of workers
+  -h/-help
+            show this help
+`)
+
+	legacy.String("name", "", "")
+	if err := p.Parse([]string{"prog"}, &flags); err == nil || err.Error() != "flag -name of adopted flag set legacy is defined by field Name too" {
+		t.Errorf("Parse = %v, want the collision", err)
+	}
+}
+
+func TestNonFlagSliceCount(t *testing.T) {
+	type options struct {
+		Dir   string   `name:"#"`
+		Files []string `name:"#FILES" min:"1" max:"2"`
+	}
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"d", "a"}, ""},
+		{[]string{"d", "a", "b"}, ""},
+		{[]string{"d"}, "expected at least 1 FILES argument, got 0"},
+		{[]string{"d", "a", "b", "c", "e"}, "expected at most 2 FILES arguments, got 4: extra [c e]"},
+	} {
+		var flags options
+		err := Parse(append([]string{"prog"}, c.args...), &flags)
+		if (err == nil && c.err != "") || (err != nil && err.Error() != c.err) {
+			t.Errorf("Parse %q = %v, want %q", c.args, err, c.err)
+		}
+	}
+
+	var flags options
+	checkHelp(t, helpOutput(t, &Parser{}, []string{"prog", "-h"}, &flags), `Usage: prog DIR FILES...
+
+Options:
+  DIR       string
+  FILES     string
+  -h/-help
+            show this help
+`)
+	var optional struct {
+		Files []string `name:"#FILE"`
+	}
+	if out := helpOutput(t, &Parser{}, []string{"prog", "-h"}, &optional); !strings.HasPrefix(out, "Usage: prog [FILE...]\n") {
+		t.Errorf("help = %q", out)
+	}
+}
+
+func TestTypedNonFlags(t *testing.T) {
+	type options struct {
+		Width  int     `name:"#WIDTH"`
+		Height uint    `name:"#HEIGHT"`
+		Scale  float64 `name:"#SCALE"`
+		Size   Size    `name:"#SIZE"`
+	}
+	var flags options
+	if err := Parse([]string{"prog", "640", "480", "1.5", "2KB"}, &flags); err != nil {
+		t.Fatal(err)
+	}
+	if flags.Width != 640 || flags.Height != 480 || flags.Scale != 1.5 || flags.Size != 2000 {
+		t.Errorf("flags = %+v", flags)
+	}
+	err := Parse([]string{"prog", "abc"}, &flags)
+	if err == nil || err.Error() != `argument WIDTH: invalid int "abc"` {
+		t.Errorf("Parse = %v", err)
+	}
+	checkHelp(t, helpOutput(t, &Parser{}, []string{"prog", "-h"}, &flags), `Usage: prog WIDTH HEIGHT SCALE SIZE
+
+Options:
+  WIDTH     int
+  HEIGHT    uint
+  SCALE     float
+  SIZE      size
+  -h/-help
+            show this help
+`)
+}
+
+func TestOptionalNonFlags(t *testing.T) {
+	type options struct {
+		Host string `name:"#HOST"`
+		Port int    `name:"#PORT" default:"8080"`
+	}
+	var flags options
+	if err := Parse([]string{"prog", "example.com"}, &flags); err != nil || flags.Port != 8080 {
+		t.Errorf("Parse = %v, flags %+v", err, flags)
+	}
+	if err := Parse([]string{"prog", "example.com", "80"}, &flags); err != nil || flags.Port != 80 {
+		t.Errorf("Parse = %v, flags %+v", err, flags)
+	}
+	if err := Parse([]string{"prog"}, &flags); err == nil || err.Error() != "missing required argument: HOST" {
+		t.Errorf("Parse = %v", err)
+	}
+	checkHelp(t, helpOutput(t, &Parser{}, []string{"prog", "-h"}, &flags), `Usage: prog HOST [PORT]
+
+Options:
+  HOST      string
+  PORT      int (default: 8080)
+  -h/-help
+            show this help
+`)
+
+	defer func() {
+		if r := recover(); r == nil || fmt.Sprint(r) != "required non-flag field Host follows optional ones" {
+			t.Errorf("recover() = %v", r)
+		}
+	}()
+	var misordered struct {
+		Port int    `name:"#PORT" default:"8080"`
+		Host string `name:"#HOST"`
+	}
+	_ = Parse([]string{"prog"}, &misordered)
+}
+
+func TestTypedNonFlagSlice(t *testing.T) {
+	type options struct {
+		Offset  int   `name:"offset"`
+		Verbose bool  `name:"v"`
+		Numbers []int `name:"#NUMBERS"`
+	}
+	for _, c := range []struct {
+		args    []string
+		numbers []int
+		err     string
+	}{
+		{[]string{"1", "-2", "3"}, []int{1, -2, 3}, ""},
+		{[]string{"-2", "3"}, []int{-2, 3}, ""},
+		{[]string{"-v", "-offset", "-1", "-2"}, []int{-2}, ""},
+		{[]string{"1", "x", "y"}, nil, `argument NUMBERS[1]: invalid int "x"`},
+		{[]string{"-x"}, nil, "flag provided but not defined: -x"},
+	} {
+		var flags options
+		err := (&Parser{Usage: func(func(io.Writer)) {}}).Parse(append([]string{"prog"}, c.args...), &flags)
+		if (err == nil && c.err != "") || (err != nil && err.Error() != c.err) {
+			t.Errorf("Parse %q = %v, want %q", c.args, err, c.err)
+		}
+		if err == nil && !reflect.DeepEqual(flags.Numbers, c.numbers) {
+			t.Errorf("Parse %q = %v, want %v", c.args, flags.Numbers, c.numbers)
+		}
+	}
+
+	var flags options
+	if out := helpOutput(t, &Parser{}, []string{"prog", "-h"}, &flags); !strings.Contains(out, "  NUMBERS   int\n") {
+		t.Errorf("help = %q", out)
+	}
+}
+
+func TestNonFlagsErrors(t *testing.T) {
+	var flags struct {
+		Src string `name:"#SRC"`
+		Dst string `name:"#DST"`
+	}
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"a"}, "missing required argument: DST"},
+		{[]string{"a", "b", "c"}, `unexpected argument "c" (expected: SRC DST)`},
+		{[]string{"a", "b", "c", "d"}, `unexpected arguments "c" "d" (expected: SRC DST)`},
+	} {
+		err := Parse(append([]string{"prog"}, c.args...), &flags)
+		if err == nil || err.Error() != c.err {
+			t.Errorf("Parse %q = %v, want %q", c.args, err, c.err)
+		}
+	}
+	var none struct{ V bool }
+	if err := Parse([]string{"prog", "a"}, &none); err == nil || err.Error() != `unexpected argument "a" (no arguments expected)` {
+		t.Errorf("Parse = %v", err)
+	}
+}
+
+func TestNonFlagSliceInMiddle(t *testing.T) {
+	type options struct {
+		Mode string   `name:"#MODE"`
+		Src  []string `name:"#SRC" min:"1"`
+		Dst  string   `name:"#DST"`
+	}
+	for _, c := range []struct {
+		args []string
+		want options
+		err  string
+	}{
+		{[]string{"cp", "a", "b"}, options{"cp", []string{"a"}, "b"}, ""},
+		{[]string{"cp", "a", "b", "c"}, options{"cp", []string{"a", "b"}, "c"}, ""},
+		{[]string{"cp", "a"}, options{}, "expected at least 1 SRC argument, got 0"},
+		{[]string{"cp"}, options{}, "missing required argument: DST"},
+	} {
+		var flags options
+		err := Parse(append([]string{"prog"}, c.args...), &flags)
+		if (err == nil && c.err != "") || (err != nil && err.Error() != c.err) {
+			t.Errorf("Parse %q = %v, want %q", c.args, err, c.err)
+			continue
+		}
+		if err == nil {
+			if !reflect.DeepEqual(flags, c.want) {
+				t.Errorf("Parse %q = %+v, want %+v", c.args, flags, c.want)
+			}
+			marshaled, _ := MarshalArgs(&flags)
+			if !reflect.DeepEqual(marshaled, c.args) {
+				t.Errorf("MarshalArgs = %q, want %q", marshaled, c.args)
+			}
+		}
+	}
+	var flags options
+	if out := helpOutput(t, &Parser{}, []string{"prog", "-h"}, &flags); !strings.HasPrefix(out, "Usage: prog MODE SRC... DST\n") {
+		t.Errorf("help = %q", out)
+	}
+}
+
+func TestPosTag(t *testing.T) {
+	type options struct {
+		Files []string `name:"#FILE" pos:"rest"`
+		Dst   string   `name:"#DST" pos:"2" usage:"destination"`
+		Mode  string   `name:"#MODE" pos:"1" usage:"copy mode"`
+		Level int      `name:"#LEVEL" pos:"3" default:"1"`
+	}
+	var flags options
+	if err := Parse([]string{"prog", "cp", "out", "2", "a", "b"}, &flags); err != nil {
+		t.Fatal(err)
+	}
+	if want := (options{[]string{"a", "b"}, "out", "cp", 2}); !reflect.DeepEqual(flags, want) {
+		t.Errorf("Parse = %+v, want %+v", flags, want)
+	}
+	checkHelp(t, helpOutput(t, &Parser{}, []string{"prog", "-h"}, &options{}), `Usage: prog MODE DST [LEVEL] [FILE...]
+
+Options:
+  MODE      string
+            copy mode
+  DST       string
+            destination
+  LEVEL     int (default: 1)
+  FILE      string
+  -h/-help
+            show this help
+`)
+
+	type mixed struct {
+		Src string `name:"#SRC" pos:"1"`
+		Dst string `name:"#DST"`
+	}
+	type duplicated struct {
+		Src string `name:"#SRC" pos:"1"`
+		Dst string `name:"#DST" pos:"1"`
+	}
+	type gap struct {
+		Src string `name:"#SRC" pos:"1"`
+		Dst string `name:"#DST" pos:"3"`
+	}
+	type misordered struct {
+		Src string `name:"#SRC" pos:"2"`
+		Dst string `name:"#DST" pos:"1" default:"-"`
+	}
+	type invalid struct {
+		Files []string `name:"#FILE" pos:"2"`
+	}
+	for _, c := range []struct {
+		flags interface{}
+		want  string
+	}{
+		{&mixed{}, "non-flag fields Src have pos tags but Dst don't, tag all of them or none as the order of declaration is used without"},
+		{&duplicated{}, "non-flag fields Src and Dst have the same pos tag 1"},
+		{&gap{}, "no non-flag field has the pos tag 2, the positions must follow each other from 1"},
+		{&misordered{}, "required non-flag field Src follows optional ones"},
+		{&invalid{}, `invalid pos tag of non-flag slice Files: "2", expect "rest"`},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil || fmt.Sprint(r) != c.want {
+					t.Errorf("recover() = %v, want %q", r, c.want)
+				}
+			}()
+			_ = Parse([]string{"prog"}, c.flags)
+		}()
+	}
+}
+
+type unexportedOpts struct {
+	Timeout time.Duration `name:"timeout"`
+	retries int
+	timeout time.Duration `name:"internal-timeout"`
+}
+
+func TestUnexportedFields(t *testing.T) {
+	var exported struct {
+		Timeout time.Duration `name:"timeout"`
+		retries int
+		skipped int `name:"-"`
+	}
+	if err := Parse([]string{"prog", "-timeout", "1s"}, &exported); err != nil || exported.Timeout != time.Second {
+		t.Errorf("Parse = %v, flags %+v", err, exported)
+	}
+
+	defer func() {
+		want := `field unexportedOpts.timeout is unexported; export it or tag it name:"-"`
+		if r := recover(); r == nil || fmt.Sprint(r) != want {
+			t.Errorf("recover() = %v, want %q", r, want)
+		}
+	}()
+	var unexported unexportedOpts
+	_ = Parse([]string{"prog"}, &unexported)
+}
+
+type benchFlags struct {
+	Name    string        `name:"name,n" usage:"the name" env:"BENCH_NAME"`
+	Workers int           `name:"workers,w" default:"4" usage:"number of workers"`
+	Timeout time.Duration `default:"30s"`
+	Verbose bool          `name:"verbose,v"`
+	Limit   Size          `default:"1MB" validate:"nonzero"`
+	Mode    string        `required:"true"`
+	TLS     bool          `name:"tls" requires:"cert"`
+	Cert    string        `name:"cert"`
+	Dir     string        `name:"#DIR"`
+	Files   []string      `name:"#FILES"`
+}
+
+var benchArgs = []string{"prog", "-n", "x", "-w", "8", "-v", "-mode", "fast", "dir", "a", "b"}
+
+func BenchmarkParse(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		var flags benchFlags
+		if err := Parse(benchArgs, &flags); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseUncached(b *testing.B) {
+	typ := reflect.TypeOf(benchFlags{})
+	for i := 0; i < b.N; i++ {
+		structDescs.Delete(structKey{typ, false})
+		var flags benchFlags
+		if err := Parse(benchArgs, &flags); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestConcurrentParse(t *testing.T) {
+	type otherFlags struct {
+		Port  int     `default:"80" validate:"even"`
+		Ratio float64 `name:"#RATIO" default:"0.5"`
+	}
+	p := &Parser{DotEnvFiles: []string{"missing.env"}, Usage: func(func(io.Writer)) {}}
+	p.RegisterValidator("even", func(v interface{}) error {
+		if v.(int)%2 != 0 {
+			return fmt.Errorf("odd")
+		}
+		return nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(4)
+		go func(i int) {
+			defer wg.Done()
+			var flags benchFlags
+			if err := p.Parse(append(benchArgs[:1:1], "-w", strconv.Itoa(i), "-mode", "m", "dir"), &flags); err != nil || flags.Workers != i {
+				t.Errorf("Parse = %v, workers %d, want %d", err, flags.Workers, i)
+			}
+			_ = p.SetFields()
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			var flags otherFlags
+			if err := p.Parse([]string{"prog", "-port", strconv.Itoa(2 * i)}, &flags); err != nil || flags.Port != 2*i || flags.Ratio != 0.5 {
+				t.Errorf("Parse = %v, flags %+v", err, flags)
+			}
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			p.RegisterValidator("v"+strconv.Itoa(i), func(interface{}) error { return nil })
+		}(i)
+		// the results of the last parse are read while others run
+		go func() {
+			defer wg.Done()
+			_ = p.SetFields()
+			p.VisitFlags(func(Flag) {})
+			p.DumpEffective(ioutil.Discard, &otherFlags{})
+			_ = p.LastFlagSet()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestInvalidDefaultAndEnv(t *testing.T) {
+	t.Parallel()
+	var flags struct {
+		Workers int `env:"SFLAG_TEST_WORKERS" default:"4"`
+	}
+	p := &Parser{LookupEnv: mapEnv(map[string]string{"SFLAG_TEST_WORKERS": "ten"})}
+	err := p.Parse([]string{"prog"}, &flags)
+	want := `invalid value "ten" for $SFLAG_TEST_WORKERS (flag -workers): strconv.ParseInt: parsing "ten": invalid syntax`
+	if err == nil || err.Error() != want {
+		t.Errorf("Parse = %v, want %q", err, want)
+	}
+
+	defer func() {
+		want := `invalid default "ten" of field Workers: strconv.ParseInt: parsing "ten": invalid syntax`
+		if r := recover(); r == nil || fmt.Sprint(r) != want {
+			t.Errorf("recover() = %v, want %q", r, want)
+		}
+	}()
+	var invalid struct {
+		Workers int `default:"ten"`
+	}
+	_ = Parse([]string{"prog"}, &invalid)
+}
+
+func TestFloatRoundTrip(t *testing.T) {
+	var f32 float32
+	var f64 float64
+	for _, c := range []struct {
+		val  reflect.Value
+		in   string
+		want string
+	}{
+		{reflect.ValueOf(&f32).Elem(), "0.1", "0.1"},
+		{reflect.ValueOf(&f32).Elem(), "3.4028235e38", "340282350000000000000000000000000000000"},
+		{reflect.ValueOf(&f32).Elem(), "1e-45", "0.000000000000000000000000000000000000000000001"},
+		{reflect.ValueOf(&f32).Elem(), "-1.5", "-1.5"},
+		{reflect.ValueOf(&f64).Elem(), "0.1", "0.1"},
+		{reflect.ValueOf(&f64).Elem(), "1.7976931348623157e308", strconv.FormatFloat(math.MaxFloat64, 'f', -1, 64)},
+		{reflect.ValueOf(&f64).Elem(), "5e-324", strconv.FormatFloat(5e-324, 'f', -1, 64)},
+	} {
+		v := &commonflagValue{c.val}
+		if err := v.Set(c.in); err != nil {
+			t.Fatalf("Set(%q): %v", c.in, err)
+		}
+		got := v.String()
+		if got != c.want {
+			t.Errorf("%s Set(%q).String() = %q, want %q", c.val.Type(), c.in, got, c.want)
+		}
+		before := c.val.Float()
+		if err := v.Set(got); err != nil || c.val.Float() != before {
+			t.Errorf("%s Set(%q) = %v, value %v, want %v", c.val.Type(), got, err, c.val.Float(), before)
+		}
+	}
+
+	var flags struct {
+		Ratio float32 `default:"0.1"`
+	}
+	if err := Parse([]string{"prog"}, &flags); err != nil {
+		t.Fatal(err)
+	}
+	args, _ := MarshalAllArgs(&flags)
+	if want := []string{"-ratio=0.1"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("MarshalAllArgs = %q, want %q", args, want)
+	}
+}
+
+func TestInvalidNameTags(t *testing.T) {
+	for _, c := range []struct {
+		flags interface{}
+		want  string
+	}{
+		{&struct {
+			V bool `name:","`
+		}{}, `invalid name tag "," of field V`},
+		{&struct {
+			V bool `name:" , "`
+		}{}, `invalid name tag " , " of field V`},
+		{&struct {
+			V bool `name:"a b"`
+		}{}, `invalid name tag "a b" of field V`},
+		{&struct {
+			V bool `name:"v,a=b"`
+		}{}, `invalid name tag "v,a=b" of field V`},
+		{&struct {
+			V bool `name:"---"`
+		}{}, `invalid name tag "---" of field V`},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil || fmt.Sprint(r) != c.want {
+					t.Errorf("recover() = %v, want %q", r, c.want)
+				}
+			}()
+			_ = Parse([]string{"prog"}, c.flags)
+		}()
+	}
+}
+
+type dupOpts struct {
+	Verbose bool `name:"verbose,v"`
+	Version bool `short:"true"`
+}
+
+func TestDuplicateFlagNames(t *testing.T) {
+	for _, c := range []struct {
+		flags interface{}
+		want  string
+	}{
+		{&dupOpts{}, "flag -v defined by both dupOpts.Verbose and dupOpts.Version"},
+		{&struct {
+			Host string `name:"h,host"`
+			Hub  string `name:"hub,host"`
+		}{}, "flag -host defined by both Host and Hub"},
+		{&struct {
+			Name  string
+			Other string `name:"name"`
+		}{}, "flag -name defined by both Name and Other"},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil || fmt.Sprint(r) != c.want {
+					t.Errorf("recover() = %v, want %q", r, c.want)
+				}
+			}()
+			_ = Parse([]string{"prog"}, c.flags)
+		}()
+	}
+}
+
+func TestHelpFlagCollision(t *testing.T) {
+	var flags struct {
+		Host string `name:"h" usage:"host to connect"`
+	}
+	if err := Parse([]string{"prog", "-h", "example.com"}, &flags); err != nil || flags.Host != "example.com" {
+		t.Fatalf("Parse = %v, %q, want example.com", err, flags.Host)
+	}
+	checkHelp(t, helpOutput(t, &Parser{}, []string{"prog", "-help"}, &flags), `Usage: prog [OPTION]
+
+Options:
+  -h     string
+         host to connect
+  -help
+         show this help
+`)
+}
+
+func TestHelpOutputs(t *testing.T) {
+	var flags struct {
+		V bool `usage:"verbose"`
+	}
+	const help = `Usage: prog [OPTION]
+
+Options:
+  -v
+            verbose
+  -h/-help
+            show this help
+`
+	for _, c := range []struct {
+		args             []string
+		err              error
+		wantOut, wantErr string
+	}{
+		{[]string{"prog", "-h"}, ErrHelp, help, ""},
+		{[]string{"prog", "-x"}, nil, "", "flag provided but not defined: -x\n" + help},
+	} {
+		var stdout, stderr bytes.Buffer
+		p := &Parser{Output: &stdout, ErrOutput: &stderr}
+		err := p.Parse(c.args, &flags)
+		if c.err != nil && err != c.err || c.err == nil && err == nil {
+			t.Errorf("Parse %q = %v, want %v", c.args, err, c.err)
+		}
+		if stdout.String() != c.wantOut || stderr.String() != c.wantErr {
+			t.Errorf("Parse %q printed %q to Output and %q to ErrOutput, want %q and %q", c.args, stdout.String(), stderr.String(), c.wantOut, c.wantErr)
+		}
+	}
+}
+
+func TestDeprecatedFlag(t *testing.T) {
+	type options struct {
+		Old int `name:"old" deprecated:"use -new"`
+		New int `name:"new"`
+	}
+	var (
+		flags  options
+		errOut bytes.Buffer
+	)
+	p := &Parser{ErrOutput: &errOut}
+	if err := p.Parse([]string{"prog", "-old", "1", "-new", "2", "-old", "3"}, &flags); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if flags != (options{Old: 3, New: 2}) {
+		t.Errorf("Parse = %+v, want the deprecated flag set", flags)
+	}
+	if want := "flag -old is deprecated, use -new\n"; errOut.String() != want {
+		t.Errorf("warnings %q, want %q", errOut.String(), want)
+	}
+}
+
+func TestFlagAliases(t *testing.T) {
+	type options struct {
+		Listen  string `name:"listen" alias:"addr,bind" usage:"address to listen"`
+		Verbose bool   `name:"v" alias:"verbose"`
+	}
+	var (
+		flags  options
+		errOut bytes.Buffer
+	)
+	p := &Parser{ErrOutput: &errOut}
+	if err := p.Parse([]string{"prog", "-addr=:80", "-verbose", "-addr=:81"}, &flags); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if flags != (options{Listen: ":81", Verbose: true}) {
+		t.Errorf("Parse = %+v, want the aliases set the flags", flags)
+	}
+	if want := "flag -addr is deprecated, use -listen\nflag -verbose is deprecated, use -v\n"; errOut.String() != want {
+		t.Errorf("warnings %q, want %q", errOut.String(), want)
+	}
+	if args, _ := MarshalArgs(&flags); !reflect.DeepEqual(args, []string{"-listen=:81", "-v=true"}) {
+		t.Errorf("MarshalArgs = %q, want the canonical names", args)
+	}
+	if got := (&Parser{}).Complete([]string{"-"}, &flags); !reflect.DeepEqual(got, []string{"-listen", "-v"}) {
+		t.Errorf("Complete = %q, want no aliases", got)
+	}
+	checkHelp(t, helpOutput(t, &Parser{}, []string{"prog", "-h"}, &flags), `Usage: prog [OPTION]...
+
+Options:
+  -listen   string
+            address to listen
+  -v
+  -h/-help
+            show this help
+`)
+
+	defer func() {
+		if r := recover(); r == nil || fmt.Sprint(r) != "flag -listen defined by both Listen and Addr" {
+			t.Errorf("recover() = %v, want the alias colliding", r)
+		}
+	}()
+	_ = Parse([]string{"prog"}, &struct {
+		Listen string `name:"listen"`
+		Addr   string `name:"addr" alias:"listen"`
+	}{})
+}
+
+type CommonOpts struct {
+	Verbose bool   `name:"v" env:"EMBED_VERBOSE"`
+	Level   int    `name:"level" default:"2"`
+	Dir     string `name:"#DIR"`
+}
+
+type innerOpts struct {
+	*CommonOpts
+}
+
+func TestEmbeddedStructs(t *testing.T) {
+	var byPointer struct {
+		*CommonOpts
+		Name string `name:"name"`
+	}
+	if err := Parse([]string{"prog", "-v", "-name", "x", "tmp"}, &byPointer); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if byPointer.CommonOpts == nil || *byPointer.CommonOpts != (CommonOpts{Verbose: true, Level: 2, Dir: "tmp"}) || byPointer.Name != "x" {
+		t.Errorf("Parse = %+v, want the embedded flags set", byPointer.CommonOpts)
+	}
+
+	var byEnv struct {
+		innerOpts
+	}
+	p := &Parser{LookupEnv: mapEnv(map[string]string{"EMBED_VERBOSE": "true"})}
+	if err := p.Parse([]string{"prog", "tmp"}, &byEnv); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if byEnv.CommonOpts == nil || *byEnv.CommonOpts != (CommonOpts{Verbose: true, Level: 2, Dir: "tmp"}) {
+		t.Errorf("Parse = %+v, want the embedded pointer allocated for the env and default", byEnv.CommonOpts)
+	}
+
+	var byValue struct {
+		CommonOpts
+	}
+	if err := p.Parse([]string{"prog", "-level", "3", "tmp"}, &byValue); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if byValue.CommonOpts != (CommonOpts{Verbose: true, Level: 3, Dir: "tmp"}) {
+		t.Errorf("Parse = %+v, want the embedded flags set", byValue.CommonOpts)
+	}
+
+	defer func() {
+		want := "flag -v defined by both twiceOpts.CommonOpts.Verbose and twiceOpts.innerOpts.CommonOpts.Verbose"
+		if r := recover(); r == nil || fmt.Sprint(r) != want {
+			t.Errorf("recover() = %v, want %q", r, want)
+		}
+	}()
+	type twiceOpts struct {
+		*CommonOpts
+		innerOpts
+	}
+	_ = Parse([]string{"prog"}, &twiceOpts{})
+}
+
+func TestParseOverlay(t *testing.T) {
+	type options struct {
+		Host    string `name:"host" env:"OVERLAY_HOST" required:"true"`
+		Port    int    `name:"port" default:"80"`
+		Level   int    `name:"level"`
+		Verbose bool   `name:"v"`
+		Info    SetInfo
+	}
+	env := map[string]string{"OVERLAY_HOST": "env"}
+	var flags options
+	p := &Parser{ErrOutput: io.Discard, LookupEnv: mapEnv(env)}
+	if err := p.Parse([]string{"prog", "-level", "2"}, &flags); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	env["OVERLAY_HOST"] = "changed"
+	flags.Port = 81
+	if err := p.ParseOverlay([]string{"prog", "-v"}, &flags); err != nil {
+		t.Fatalf("ParseOverlay: %v", err)
+	}
+	wantInfo := SetInfo{"host": EnvSource, "port": DefaultSource, "level": FlagSource, "v": FlagSource}
+	want := options{Host: "env", Port: 81, Level: 2, Verbose: true, Info: wantInfo}
+	if !reflect.DeepEqual(flags, want) {
+		t.Errorf("ParseOverlay = %+v, want %+v", flags, want)
+	}
+	if !reflect.DeepEqual(p.SetFields(), wantInfo) {
+		t.Errorf("SetFields = %v, want %v", p.SetFields(), wantInfo)
+	}
+
+	if err := p.ParseOverlay([]string{"prog", "-level", "3", "-port=x"}, &flags); err == nil {
+		t.Error("ParseOverlay = nil, want the error of the invalid port")
+	}
+	err := p.ParseOverlay([]string{"prog", "-level", "4", "x"}, &flags)
+	if want := `unexpected argument "x" (no arguments expected)`; err == nil || err.Error() != want {
+		t.Errorf("ParseOverlay = %v, want %q", err, want)
+	}
+}
+
+func TestParseOverlayExpand(t *testing.T) {
+	type options struct {
+		Dir   string `name:"dir" env:"OVERLAY_DIR" expand:"true"`
+		Cache string `name:"cache" expand:"strict"`
+	}
+	env := map[string]string{"ROOT": "/srv", "OVERLAY_DIR": "/a"}
+	p := &Parser{LookupEnv: func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}}
+	var flags options
+	if err := p.Parse([]string{"prog"}, &flags); err != nil || flags.Dir != "/a" {
+		t.Fatalf("Parse = %v, %+v, want the env", err, flags)
+	}
+	env["OVERLAY_DIR"] = "/changed"
+	if err := p.ParseOverlay([]string{"prog", "-cache", "$ROOT/cache"}, &flags); err != nil {
+		t.Fatalf("ParseOverlay: %v", err)
+	}
+	if want := (options{Dir: "/a", Cache: "/srv/cache"}); flags != want {
+		t.Errorf("ParseOverlay = %+v, want %+v", flags, want)
+	}
+	if err := p.ParseOverlay([]string{"prog", "-dir", "${ROOT}/b"}, &flags); err != nil || flags.Dir != "/srv/b" {
+		t.Errorf("ParseOverlay = %v, %+v, want -dir expanded", err, flags)
+	}
+}
+
+func TestCombinedShort(t *testing.T) {
+	type options struct {
+		Verbose bool     `name:"v"`
+		Trace   bool     `name:"x"`
+		Port    int      `name:"p"`
+		Output  string   `name:"o"`
+		Name    string   `name:"name"`
+		Args    []string `name:"#ARGS"`
+	}
+	p := &Parser{AllowCombinedShort: true, ErrOutput: io.Discard}
+	for _, c := range []struct {
+		args []string
+		want options
+		err  string
+	}{
+		{args: []string{"-vx"}, want: options{Verbose: true, Trace: true}},
+		{args: []string{"-p8080"}, want: options{Port: 8080}},
+		{args: []string{"-ofile.txt"}, want: options{Output: "file.txt"}},
+		{args: []string{"-vp8080"}, want: options{Verbose: true, Port: 8080}},
+		{args: []string{"-xvp", "8080"}, want: options{Verbose: true, Trace: true, Port: 8080}},
+		{args: []string{"-ov"}, want: options{Output: "v"}},
+		{args: []string{"-o", "-vx"}, want: options{Output: "-vx"}},
+		{args: []string{"-name", "n", "-v"}, want: options{Verbose: true, Name: "n"}},
+		{args: []string{"-p=1", "a", "-vx"}, want: options{Port: 1, Args: []string{"a", "-vx"}}},
+		{args: []string{"--", "-vx"}, want: options{Args: []string{"-vx"}}},
+		{args: []string{"-vq"}, err: "flag provided but not defined: -vq"},
+		{args: []string{"-pv"}, err: `invalid value "v" for flag -p: strconv.ParseInt: parsing "v": invalid syntax`},
+	} {
+		var flags options
+		err := p.Parse(append([]string{"prog"}, c.args...), &flags)
+		switch {
+		case c.err != "":
+			if err == nil || err.Error() != c.err {
+				t.Errorf("Parse(%q) = %v, want %q", c.args, err, c.err)
+			}
+		case err != nil:
+			t.Errorf("Parse(%q): %v", c.args, err)
+		case !reflect.DeepEqual(flags, c.want):
+			t.Errorf("Parse(%q) = %+v, want %+v", c.args, flags, c.want)
+		}
+	}
+
+	var flags options
+	if err := (&Parser{ErrOutput: io.Discard}).Parse([]string{"prog", "-vx"}, &flags); err == nil {
+		t.Error("Parse = nil, want -vx undefined without AllowCombinedShort")
+	}
+}
+
+func TestSuggestFlags(t *testing.T) {
+	type options struct {
+		Verbose bool   `name:"verbose"`
+		Output  string `name:"output,o"`
+		Listen  string `name:"listen" alias:"bind"`
+		Debug   bool   `name:"debug" hidden:"true"`
+	}
+	for _, c := range []struct {
+		arg  string
+		want string
+	}{
+		{"-verbsoe", "flag provided but not defined: -verbsoe (did you mean -verbose?)"},
+		{"--verbsoe", "flag provided but not defined: -verbsoe (did you mean -verbose?)"},
+		{"-outptu=x", "flag provided but not defined: -outptu (did you mean -output?)"},
+		{"-bnid", "flag provided but not defined: -bnid (did you mean -listen?)"},
+		{"-debgu", "flag provided but not defined: -debgu"},
+		{"-zzz", "flag provided but not defined: -zzz"},
+	} {
+		var (
+			flags  options
+			errOut bytes.Buffer
+		)
+		err := (&Parser{ErrOutput: &errOut}).Parse([]string{"prog", c.arg}, &flags)
+		if err == nil || err.Error() != c.want {
+			t.Errorf("Parse(%q) = %v, want %q", c.arg, err, c.want)
+		}
+		if !strings.HasPrefix(errOut.String(), c.want+"\nUsage: prog") {
+			t.Errorf("Parse(%q) printed %q, want the error and the help", c.arg, errOut.String())
+		}
+	}
+}
+
+func TestHelpLayout(t *testing.T) {
+	var flags struct {
+		Port    int    `name:"port,p" default:"80" usage:"port to listen"`
+		Verbose bool   `name:"v" usage:"verbose output"`
+		Config  string `name:"configuration-file-path" env:"APP_CONFIG" usage:"path of the config"`
+	}
+	p := &Parser{HelpLayout: HelpLayout{Inline: true, Indent: 4, MaxNameWidth: 16}}
+	checkHelp(t, helpOutput(t, p, []string{"prog", "-h"}, &flags), `Usage: prog [OPTION]...
+
+Options:
+    -port/-p int      port to listen (default: 80)
+    -v                verbose output
+    -configuration-file-path string
+                      path of the config (env: APP_CONFIG)
+    -h/-help          show this help
+`)
+
+	p = &Parser{HelpLayout: HelpLayout{MaxNameWidth: 8}}
+	checkHelp(t, helpOutput(t, p, []string{"prog", "-h"}, &flags), `Usage: prog [OPTION]...
+
+Options:
+  -port/-p  int (default: 80)
+            port to listen
+  -v
+            verbose output
+  -configuration-file-path  string (env: APP_CONFIG)
+            path of the config
+  -h/-help
+            show this help
+`)
+
+	var out bytes.Buffer
+	p = &Parser{
+		HelpLayout: HelpLayout{Indent: 1, MaxNameWidth: 6},
+		Usage:      func(printDefaults func(w io.Writer)) { printDefaults(&out) },
+	}
+	_, _, err := p.ParseCommand([]string{"prog", "-h"}, nil,
+		Command{Name: "run", Usage: "run the job"},
+		Command{Name: "inspect-all", Usage: "inspect the jobs"})
+	if err != ErrHelp {
+		t.Fatalf("ParseCommand = %v, want ErrHelp", err)
+	}
+	checkHelp(t, out.String(), `Usage: prog COMMAND [ARGUMENT]...
+
+Commands:
+ run     run the job
+ inspect-all
+         inspect the jobs
+ help    show help of the program or a command
+`)
+}
+
+func TestSynopsis(t *testing.T) {
+	for _, c := range []struct {
+		flagsPtr interface{}
+		want     string
+	}{
+		{&struct{}{}, "prog"},
+		{&struct {
+			Token string `name:"token" required:"true"`
+		}{}, "prog -token TOKEN"},
+		{&struct {
+			Token string `name:"t,api-token" required:"true"`
+			Force bool   `name:"force" required:"true"`
+			Debug bool   `name:"debug" hidden:"true"`
+		}{}, "prog -t API_TOKEN -force"},
+		{&struct {
+			Token   string `name:"token" required:"true"`
+			Verbose bool   `name:"v"`
+		}{}, "prog [OPTION] -token TOKEN"},
+		{&struct {
+			Token   string `name:"token" required:"true"`
+			Verbose bool   `name:"v"`
+			Port    int    `name:"port"`
+		}{}, "prog [OPTION]... -token TOKEN"},
+		{&struct {
+			Src  string   `name:"#SRC"`
+			Dst  string   `name:"#DST" default:"."`
+			Rest []string `name:"#REST"`
+		}{}, "prog SRC [DST] [REST...]"},
+		{&struct {
+			Files []string `name:"#FILE" min:"1"`
+			Out   string   `name:"#OUT"`
+		}{}, "prog FILE... OUT"},
+		{&struct {
+			Verbose bool     `name:"v"`
+			Args    []string `name:"#ARG" after-terminator:"true"`
+		}{}, "prog [OPTION] [-- ARG...]"},
+	} {
+		flags := (&Parser{}).newCommandFlags("prog")
+		(&Parser{}).defineFlags(flags, flag.NewFlagSet("prog", flag.ContinueOnError), c.flagsPtr)
+		spec := flags.spec()
+		if got := flags.synopsis(spec, flags.helpOptions(spec)); got != c.want {
+			t.Errorf("synopsis of %T = %q, want %q", c.flagsPtr, got, c.want)
+		}
+	}
+}
+
+func TestBoolWords(t *testing.T) {
+	type options struct {
+		TLS     bool            `name:"enable-tls"`
+		Feature bool            `name:"feature" env:"FEATURE"`
+		Debug   bool            `name:"debug" default:"On"`
+		Modes   map[string]bool `name:"mode"`
+	}
+	env := map[string]string{"FEATURE": "off"}
+	lookupEnv := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+	opts := options{Feature: true}
+	args := []string{"prog", "-enable-tls=YES", "-mode", "a=no,b=on"}
+	if err := (&Parser{LookupEnv: lookupEnv}).Parse(args, &opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := (options{TLS: true, Debug: true, Modes: map[string]bool{"a": false, "b": true}}); !reflect.DeepEqual(opts, want) {
+		t.Errorf("got %+v, want %+v", opts, want)
+	}
+	if got, err := MarshalArgs(&opts); err != nil || !reflect.DeepEqual(got, []string{"-enable-tls=true", "-mode=a=false,b=true"}) {
+		t.Errorf("MarshalArgs = %q, %v", got, err)
+	}
+
+	for _, c := range []struct {
+		p    *Parser
+		args []string
+		err  string
+	}{
+		{&Parser{}, []string{"-enable-tls=Y"}, `invalid boolean value "Y" for -enable-tls: strconv.ParseBool: parsing "Y": invalid syntax`},
+		{&Parser{}, []string{"-enable-tls=n"}, `invalid boolean value "n" for -enable-tls: strconv.ParseBool: parsing "n": invalid syntax`},
+		{&Parser{StrictBool: true}, []string{"-enable-tls=yes"}, `invalid boolean value "yes" for -enable-tls: strconv.ParseBool: parsing "yes": invalid syntax`},
+		{&Parser{StrictBool: true, LookupEnv: lookupEnv}, nil, `invalid value "off" for $FEATURE (flag -feature): strconv.ParseBool: parsing "off": invalid syntax`},
+	} {
+		c.p.ErrOutput = &strings.Builder{}
+		var opts options
+		if err := c.p.Parse(append([]string{"prog"}, c.args...), &opts); err == nil || err.Error() != c.err {
+			t.Errorf("Parse(%q) = %v, want %s", c.args, err, c.err)
+		}
+	}
+	// the defaults accept the words either way
+	var strict options
+	if err := (&Parser{StrictBool: true}).Parse([]string{"prog", "-enable-tls=1"}, &strict); err != nil || !strict.TLS || !strict.Debug {
+		t.Errorf("Parse with StrictBool = %+v, %v", strict, err)
+	}
+}
+
+func TestBoolValuesAsNextArg(t *testing.T) {
+	type options struct {
+		DryRun bool     `name:"dry-run"`
+		Force  bool     `name:"force"`
+		Name   string   `name:"name"`
+		Files  []string `name:"#FILE"`
+	}
+	for _, c := range []struct {
+		p     *Parser
+		args  []string
+		want  options
+		files []string
+	}{
+		{&Parser{BoolValuesAsNextArg: true}, []string{"-dry-run", "false"}, options{}, nil},
+		{&Parser{BoolValuesAsNextArg: true}, []string{"-dry-run", "0", "a"}, options{}, []string{"a"}},
+		{&Parser{BoolValuesAsNextArg: true}, []string{"-dry-run", "off", "-force", "TRUE"}, options{Force: true}, nil},
+		{&Parser{BoolValuesAsNextArg: true}, []string{"-dry-run", "file.txt"}, options{DryRun: true}, []string{"file.txt"}},
+		{&Parser{BoolValuesAsNextArg: true}, []string{"-dry-run", "-force"}, options{DryRun: true, Force: true}, nil},
+		{&Parser{BoolValuesAsNextArg: true}, []string{"-force", "-dry-run"}, options{DryRun: true, Force: true}, nil},
+		{&Parser{BoolValuesAsNextArg: true}, []string{"-dry-run=true", "false"}, options{DryRun: true}, []string{"false"}},
+		{&Parser{BoolValuesAsNextArg: true}, []string{"-name", "-force", "false"}, options{Name: "-force"}, []string{"false"}},
+		{&Parser{BoolValuesAsNextArg: true}, []string{"a", "-dry-run", "false"}, options{}, []string{"a", "-dry-run", "false"}},
+		{&Parser{BoolValuesAsNextArg: true, StrictBool: true}, []string{"-dry-run", "no"}, options{DryRun: true}, []string{"no"}},
+		{&Parser{}, []string{"-dry-run", "false"}, options{DryRun: true}, []string{"false"}},
+	} {
+		var opts options
+		if err := c.p.Parse(append([]string{"prog"}, c.args...), &opts); err != nil {
+			t.Errorf("Parse(%q) = %v", c.args, err)
+			continue
+		}
+		if c.want.Files = c.files; !reflect.DeepEqual(opts, c.want) {
+			t.Errorf("Parse(%q) = %+v, want %+v", c.args, opts, c.want)
+		}
+	}
+}
+
+func TestNumberUnderscores(t *testing.T) {
+	type options struct {
+		MaxBytes int64   `name:"max-bytes" default:"1_024"`
+		Count    uint    `name:"count" env:"COUNT"`
+		Ratio    float64 `name:"ratio"`
+		Small    int8    `name:"small"`
+	}
+	p := &Parser{LookupEnv: func(key string) (string, bool) { return "10_000", key == "COUNT" }}
+	var opts options
+	if err := p.Parse([]string{"prog", "-max-bytes", "-1_073_741_824", "-ratio", "1_000.000_5e1_0"}, &opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := (options{MaxBytes: -1073741824, Count: 10000, Ratio: 1000.0005e10}); opts != want {
+		t.Errorf("got %+v, want %+v", opts, want)
+	}
+	if err := p.Parse([]string{"prog"}, &opts); err != nil || opts.MaxBytes != 1024 {
+		t.Errorf("default = %d, %v", opts.MaxBytes, err)
+	}
+	opts = options{MaxBytes: 1000000}
+	if got, err := MarshalArgs(&opts); err != nil || !reflect.DeepEqual(got, []string{"-max-bytes=1000000"}) {
+		t.Errorf("MarshalArgs = %q, %v", got, err)
+	}
+
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-max-bytes", "_1"}, `invalid value "_1" for flag -max-bytes: strconv.ParseInt: parsing "_1": invalid syntax`},
+		{[]string{"-max-bytes", "1_"}, `invalid value "1_" for flag -max-bytes: strconv.ParseInt: parsing "1_": invalid syntax`},
+		{[]string{"-max-bytes", "1__0"}, `invalid value "1__0" for flag -max-bytes: strconv.ParseInt: parsing "1__0": invalid syntax`},
+		{[]string{"-max-bytes", "-_1"}, `invalid value "-_1" for flag -max-bytes: strconv.ParseInt: parsing "-_1": invalid syntax`},
+		{[]string{"-max-bytes", "0x_FF"}, `invalid value "0x_FF" for flag -max-bytes: strconv.ParseInt: parsing "0x_FF": invalid syntax`},
+		{[]string{"-ratio", "1_.5"}, `invalid value "1_.5" for flag -ratio: strconv.ParseFloat: parsing "1_.5": invalid syntax`},
+		{[]string{"-small", "1_000"}, `invalid value "1_000" for flag -small: strconv.ParseInt: parsing "1_000": value out of range`},
+	} {
+		p := &Parser{ErrOutput: &strings.Builder{}}
+		var opts options
+		if err := p.Parse(append([]string{"prog"}, c.args...), &opts); err == nil || err.Error() != c.err {
+			t.Errorf("Parse(%q) = %v, want %s", c.args, err, c.err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package sflag
+
+import (
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// HostPort is a network address of a host and a port, such as :8080,
+// 0.0.0.0:8080, example.com:443 or [::1]:443, which is labeled as host:port
+// in help. The host may be empty, and the port is a number or the name of a
+// service, such as http, which the `resolve:"true"` tag turns into its
+// number. The host isn't resolved.
+type HostPort string
+
+func (hp *HostPort) String() string {
+	return string(*hp)
+}
+
+// Set checks s is a host and a port, and sets hp to its canonical form, in
+// which an IPv6 host is in brackets.
+func (hp *HostPort) Set(s string) error {
+	host, port, err := splitHostPort(s)
+	if err != nil {
+		return err
+	}
+	*hp = HostPort(net.JoinHostPort(host, port))
+	return nil
+}
+
+func (hp *HostPort) Type() string {
+	return "host:port"
+}
+
+// Host returns the host of hp, less the brackets of IPv6.
+func (hp HostPort) Host() string {
+	host, _, _ := net.SplitHostPort(string(hp))
+	return host
+}
+
+// Port returns the port of hp, which is a number unless it's the name of a
+// service.
+func (hp HostPort) Port() string {
+	_, port, _ := net.SplitHostPort(string(hp))
+	return port
+}
+
+// resolve replaces the service name of the port by its number.
+func (hp *HostPort) resolve() error {
+	host, port, err := splitHostPort(string(*hp))
+	if err != nil {
+		return err
+	}
+	n, err := lookupPort("tcp", port)
+	if err != nil {
+		return err
+	}
+	*hp = HostPort(net.JoinHostPort(host, strconv.Itoa(n)))
+	return nil
+}
+
+var (
+	hostPortType = reflect.TypeOf(HostPort(""))
+	tcpAddrType  = reflect.TypeOf(net.TCPAddr{})
+	udpAddrType  = reflect.TypeOf(net.UDPAddr{})
+)
+
+// splitHostPort splits s into a host and a port, the port must be a number
+// from 0 to 65535 or the name of a service.
+func splitHostPort(s string) (host, port string, err error) {
+	host, port, err = net.SplitHostPort(s)
+	if err != nil {
+		if ae, ok := err.(*net.AddrError); ok {
+			return "", "", newErrorf("%s %q", ae.Err, s)
+		}
+		return "", "", err
+	}
+	switch {
+	case port == "":
+		return "", "", newErrorf("missing port in address %q", s)
+	case strings.Trim(port, "0123456789") == "":
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return "", "", newErrorf("port %s out of range in address %q", port, s)
+		}
+	case !isServiceName(port):
+		return "", "", newErrorf("invalid port %q in address %q", port, s)
+	}
+	if strings.IndexFunc(host, func(r rune) bool { return r <= ' ' || r == '/' }) >= 0 {
+		return "", "", newErrorf("invalid host %q in address %q", host, s)
+	}
+	return host, port, nil
+}
+
+// isServiceName reports whether s may be the name of a service, which is
+// made of letters, digits and dashes.
+func isServiceName(s string) bool {
+	return strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-") == ""
+}
+
+// lookupPort returns the number of the port, which may be the name of a
+// service.
+func lookupPort(network, port string) (int, error) {
+	n, err := net.LookupPort(network, port)
+	if err != nil {
+		return 0, newErrorf("unknown port %q", port)
+	}
+	return n, nil
+}
+
+// setAddr sets the net.TCPAddr or net.UDPAddr val to s, of which the host
+// must be empty or an IP address, maybe with a zone, such as
+// [fe80::1%eth0]:80. A port which is the name of a service is looked up.
+func setAddr(val reflect.Value, s string) error {
+	host, port, err := splitHostPort(s)
+	if err != nil {
+		return err
+	}
+	var ip net.IP
+	var zone string
+	if host != "" {
+		addr := host
+		if i := strings.LastIndexByte(host, '%'); i >= 0 {
+			addr, zone = host[:i], host[i+1:]
+		}
+		if ip = net.ParseIP(addr); ip == nil || zone != "" && ip.To4() != nil {
+			return newErrorf("host %q isn't an IP address", host)
+		}
+	}
+	network := "tcp"
+	if val.Type() == udpAddrType {
+		network = "udp"
+	}
+	n, err := lookupPort(network, port)
+	if err != nil {
+		return err
+	}
+	if network == "udp" {
+		val.Set(reflect.ValueOf(net.UDPAddr{IP: ip, Port: n, Zone: zone}))
+	} else {
+		val.Set(reflect.ValueOf(net.TCPAddr{IP: ip, Port: n, Zone: zone}))
+	}
+	return nil
+}
+
+// addrString returns the string of the net.TCPAddr or net.UDPAddr val, which
+// is empty for the zero address.
+func addrString(val reflect.Value) string {
+	if val.IsZero() {
+		return ""
+	}
+	return val.Addr().Interface().(net.Addr).String()
+}
+
+// resolvePorts resolves the service names of the ports of the HostPort flags
+// tagged with resolve.
+func (fields *structFields) resolvePorts() error {
+	for _, value := range fields.resolved {
+		hp := value.field.Addr().Interface().(*HostPort)
+		if *hp == "" {
+			continue
+		}
+		if err := hp.resolve(); err != nil {
+			return newErrorf("invalid value %q for flag -%s: %v", string(*hp), value.names[0], err)
+		}
+	}
+	return nil
+}
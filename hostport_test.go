@@ -0,0 +1,117 @@
+package sflag
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHostPort(t *testing.T) {
+	for _, c := range []struct {
+		in, want, host, port string
+	}{
+		{":8080", ":8080", "", "8080"},
+		{"0.0.0.0:8080", "0.0.0.0:8080", "0.0.0.0", "8080"},
+		{"[::1]:443", "[::1]:443", "::1", "443"},
+		{"example.com:https", "example.com:https", "example.com", "https"},
+		{"[fe80::1%eth0]:80", "[fe80::1%eth0]:80", "fe80::1%eth0", "80"},
+	} {
+		var hp HostPort
+		if err := hp.Set(c.in); err != nil {
+			t.Errorf("Set(%q): %v", c.in, err)
+			continue
+		}
+		if hp.String() != c.want || hp.Host() != c.host || hp.Port() != c.port {
+			t.Errorf("Set(%q) = %q, host %q, port %q", c.in, hp, hp.Host(), hp.Port())
+		}
+		var again HostPort
+		if err := again.Set(hp.String()); err != nil || again != hp {
+			t.Errorf("Set(%q) = %q, %v, want it round-trips", hp, again, err)
+		}
+	}
+	for _, c := range []struct {
+		in, err string
+	}{
+		{"8080", `missing port in address "8080"`},
+		{"localhost:", `missing port in address "localhost:"`},
+		{"::1:80", `too many colons in address "::1:80"`},
+		{"[::1:80", `missing ']' in address "[::1:80"`},
+		{"host:70000", `port 70000 out of range in address "host:70000"`},
+		{"host:8_0", `invalid port "8_0" in address "host:8_0"`},
+		{"my host:80", `invalid host "my host" in address "my host:80"`},
+	} {
+		var hp HostPort
+		if err := hp.Set(c.in); err == nil || err.Error() != c.err {
+			t.Errorf("Set(%q) = %v, want %s", c.in, err, c.err)
+		}
+	}
+}
+
+func TestAddrFlags(t *testing.T) {
+	type options struct {
+		Listen  net.TCPAddr `name:"listen" default:":8080" usage:"listen address"`
+		Metrics net.UDPAddr `name:"metrics"`
+		Peer    HostPort    `name:"peer" default:"localhost:http" resolve:"true"`
+		Proxy   HostPort    `name:"proxy"`
+	}
+	var opts options
+	args := []string{"prog", "-listen", "[::1]:https", "-metrics", "127.0.0.1:8125", "-proxy", "proxy:http"}
+	if err := (&Parser{}).Parse(args, &opts); err != nil {
+		t.Fatal(err)
+	}
+	want := options{
+		Listen:  net.TCPAddr{IP: net.ParseIP("::1"), Port: 443},
+		Metrics: net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8125},
+		Peer:    "localhost:80",
+		Proxy:   "proxy:http",
+	}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("got %+v, want %+v", opts, want)
+	}
+
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-listen", "localhost:80"}, `invalid value "localhost:80" for flag -listen: host "localhost" isn't an IP address`},
+		{[]string{"-listen", "1.2.3.4"}, `invalid value "1.2.3.4" for flag -listen: missing port in address "1.2.3.4"`},
+		{[]string{"-metrics", ":no-such-service"}, `invalid value ":no-such-service" for flag -metrics: unknown port "no-such-service"`},
+		{[]string{"-peer", "host:no-such-service"}, `invalid value "host:no-such-service" for flag -peer: unknown port "no-such-service"`},
+	} {
+		var opts options
+		p := &Parser{ErrOutput: &strings.Builder{}}
+		if err := p.Parse(append([]string{"prog"}, c.args...), &opts); err == nil || err.Error() != c.err {
+			t.Errorf("Parse(%q) = %v, want %s", c.args, err, c.err)
+		}
+	}
+
+	var help struct {
+		Listen net.TCPAddr `name:"listen" default:"[::1]:8080" usage:"listen address"`
+		Peer   HostPort    `name:"peer" usage:"peer address"`
+	}
+	checkHelp(t, helpOutput(t, &Parser{}, []string{"prog", "-h"}, &help), `Usage: prog [OPTION]...
+
+Options:
+  -listen   host:port (default: [::1]:8080)
+            listen address
+  -peer     host:port
+            peer address
+  -h/-help
+            show this help
+`)
+	help.Listen = net.TCPAddr{Port: 9090}
+	if got, err := MarshalArgs(&help); err != nil || !reflect.DeepEqual(got, []string{"-listen=:9090"}) {
+		t.Errorf("MarshalArgs = %q, %v", got, err)
+	}
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(error).Error(), "resolve tag") {
+			t.Errorf("recovered %v, want the resolve tag panic", r)
+		}
+	}()
+	var bad struct {
+		Addr string `name:"addr" resolve:"true"`
+	}
+	_ = (&Parser{}).Parse([]string{"prog"}, &bad)
+}
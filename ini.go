@@ -0,0 +1,88 @@
+package sflag
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// LoadConfigINI loads flag values from an INI document, it is the same as
+// LoadConfig(r, "ini").
+//
+// Keys of a [section] set the flags prefixed by the section name, so "port"
+// of section [server] sets the flag -server-port. Lines starting with ';'
+// or '#' are comments, quotes around values are trimmed, and a repeated key
+// gives all of its values to the flag.
+func (p *Parser) LoadConfigINI(r io.Reader) error {
+	doc, err := parseINI(r)
+	if err != nil {
+		return err
+	}
+	return p.addConfig(doc, "")
+}
+
+func parseINI(r io.Reader) (map[string]interface{}, error) {
+	var (
+		root    = make(map[string]interface{})
+		section = root
+		scanner = bufio.NewScanner(r)
+		lineno  int
+	)
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			if !strings.HasSuffix(line, "]") {
+				return nil, newErrorf("ini: line %d: expect ] after section name", lineno)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if name == "" {
+				return nil, newErrorf("ini: line %d: empty section name", lineno)
+			}
+			sub, ok := root[name].(map[string]interface{})
+			if !ok {
+				sub = make(map[string]interface{})
+				root[name] = sub
+			}
+			section = sub
+			continue
+		}
+		i := strings.IndexAny(line, "=:")
+		if i < 0 {
+			return nil, newErrorf("ini: line %d: expect key = value", lineno)
+		}
+		key, value := strings.TrimSpace(line[:i]), iniValue(strings.TrimSpace(line[i+1:]))
+		if key == "" {
+			return nil, newErrorf("ini: line %d: empty key", lineno)
+		}
+		switch prev := section[key].(type) {
+		case nil:
+			section[key] = value
+		case string:
+			section[key] = []interface{}{prev, value}
+		case []interface{}:
+			section[key] = append(prev, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// iniValue trims the quotes around value, or the inline comment following
+// an unquoted one.
+func iniValue(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	for i := 1; i < len(value); i++ {
+		if (value[i] == ';' || value[i] == '#') && (value[i-1] == ' ' || value[i-1] == '\t') {
+			return strings.TrimSpace(value[:i])
+		}
+	}
+	return value
+}
@@ -0,0 +1,79 @@
+package sflag
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseINI(t *testing.T) {
+	doc, err := parseINI(strings.NewReader(`; service
+name = "a b"
+count: 3 ; comment
+tags = x
+tags = 'y'
+# db
+[db]
+host = h#1
+port = 1
+`))
+	if err != nil {
+		t.Fatalf("parseINI: %v", err)
+	}
+	got := make(config)
+	if err := flattenConfig(got, "", doc); err != nil {
+		t.Fatalf("flattenConfig: %v", err)
+	}
+	want := config{
+		"name": {"a b"}, "count": {"3"}, "tags": {"x", "y"}, "db-host": {"h#1"}, "db-port": {"1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseINI = %v, want %v", got, want)
+	}
+}
+
+func TestParseINIErrors(t *testing.T) {
+	tests := []struct {
+		doc, err string
+	}{
+		{"name = a\n[db\n", "ini: line 2: expect ] after section name"},
+		{"[ ]\n", "ini: line 1: empty section name"},
+		{"name\n", "ini: line 1: expect key = value"},
+		{"= a\n", "ini: line 1: empty key"},
+	}
+	for _, tt := range tests {
+		if _, err := parseINI(strings.NewReader(tt.doc)); err == nil || err.Error() != tt.err {
+			t.Errorf("parseINI(%q) = %v, want %s", tt.doc, err, tt.err)
+		}
+	}
+}
+
+func TestLoadConfigINI(t *testing.T) {
+	p := &Parser{}
+	err := p.LoadConfigINI(strings.NewReader(`name = cfg
+verbose = true
+
+[db]
+host = h
+port = 5432
+`))
+	if err != nil {
+		t.Fatalf("LoadConfigINI: %v", err)
+	}
+	var flags configFlags
+	if err := p.Parse([]string{"prog", "-db.port", "1"}, &flags); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := configFlags{Name: "cfg", Verbose: true, DBHost: "h", DBPort: 1}
+	if !reflect.DeepEqual(flags, want) {
+		t.Errorf("flags %+v, want %+v", flags, want)
+	}
+
+	p = &Parser{}
+	if err := p.LoadConfig(strings.NewReader("count = x\n"), "conf"); err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if err := p.Parse([]string{"prog"}, &flags); err == nil || !strings.HasPrefix(err.Error(), `invalid config value "x" for flag -count`) {
+		t.Errorf("Parse = %v, want the invalid value", err)
+	}
+}
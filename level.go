@@ -0,0 +1,44 @@
+//go:build go1.21
+// +build go1.21
+
+package sflag
+
+import (
+	"flag"
+	"log/slog"
+	"reflect"
+	"strings"
+)
+
+func init() {
+	typeValues[reflect.TypeOf(slog.Level(0))] = func(val reflect.Value) flag.Value {
+		return &levelValue{val.Addr().Interface().(*slog.Level)}
+	}
+}
+
+// levelValue sets a slog.Level field to the name of a level, such as debug
+// or warn, case-insensitively, maybe with an offset, such as info+2.
+type levelValue struct {
+	level *slog.Level
+}
+
+// String returns the lowercase name of the level, such as info or warn-1.
+func (v *levelValue) String() string {
+	return strings.ToLower(v.level.String())
+}
+
+func (v *levelValue) Set(s string) error {
+	return v.level.UnmarshalText([]byte(s))
+}
+
+func (v *levelValue) Get() interface{} {
+	return *v.level
+}
+
+func (v *levelValue) Type() string {
+	return "level"
+}
+
+func (v *levelValue) Choices() []string {
+	return []string{"debug", "info", "warn", "error"}
+}
@@ -0,0 +1,71 @@
+//go:build go1.21
+// +build go1.21
+
+package sflag
+
+import (
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLevel(t *testing.T) {
+	type options struct {
+		Level slog.Level `name:"log-level" default:"info" usage:"log level"`
+	}
+	for _, c := range []struct {
+		args []string
+		want slog.Level
+	}{
+		{nil, slog.LevelInfo},
+		{[]string{"-log-level", "DEBUG"}, slog.LevelDebug},
+		{[]string{"-log-level", "Warn"}, slog.LevelWarn},
+		{[]string{"-log-level", "info+2"}, slog.LevelInfo + 2},
+		{[]string{"-log-level", "error-1"}, slog.LevelError - 1},
+	} {
+		var opts options
+		if err := (&Parser{}).Parse(append([]string{"prog"}, c.args...), &opts); err != nil || opts.Level != c.want {
+			t.Errorf("Parse(%q) = %v, %v, want %v", c.args, opts.Level, err, c.want)
+		}
+	}
+	var opts options
+	if err := (&Parser{ErrOutput: &strings.Builder{}}).Parse([]string{"prog", "-log-level", "loud"}, &opts); err == nil {
+		t.Errorf("Parse(loud) = %v, want an error", opts.Level)
+	}
+
+	checkHelp(t, helpOutput(t, &Parser{}, []string{"prog", "-h"}, &opts), `Usage: prog [OPTION]
+
+Options:
+  -log-level  level (default: info, one of: debug|info|warn|error)
+              log level
+  -h/-help
+              show this help
+`)
+	opts.Level = slog.LevelWarn + 1
+	if got, err := MarshalArgs(&opts); err != nil || !reflect.DeepEqual(got, []string{"-log-level=warn+1"}) {
+		t.Errorf("MarshalArgs = %q, %v", got, err)
+	}
+
+	var described struct {
+		Level slog.Level `name:"log-level" usage:"one of {choices}"`
+	}
+	if spec, err := Describe(&described); err != nil || spec.Flags[0].Usage != "one of debug|info|warn|error" {
+		t.Errorf("Describe = %+v, %v", spec, err)
+	}
+
+	commands := []Command{{Name: "run", Flags: &options{}}}
+	for _, c := range []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-log-level", "w"}, []string{"warn"}},
+		{[]string{"-log-level="}, []string{"-log-level=debug", "-log-level=info", "-log-level=warn", "-log-level=error"}},
+		{[]string{"run", "-log-level", ""}, []string{"debug", "info", "warn", "error"}},
+		{[]string{"run", "--log-level=e"}, []string{"--log-level=error"}},
+	} {
+		if got := (&Parser{}).Complete(c.args, &options{}, commands...); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Complete(%q) = %q, want %q", c.args, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package sflag
+
+import (
+	"flag"
+	"reflect"
+	"time"
+)
+
+var locationType = reflect.TypeOf((*time.Location)(nil))
+
+func init() {
+	typeValues[locationType] = func(val reflect.Value) flag.Value {
+		return &locationValue{val.Addr().Interface().(**time.Location)}
+	}
+}
+
+// locationValue sets a *time.Location field to the time zone of a name of
+// the tz database, such as Europe/Berlin, or Local or UTC. The zone is
+// loaded when set, so a missing tz database fails the values only.
+type locationValue struct {
+	loc **time.Location
+}
+
+// String returns the name of the zone, which is empty for a nil one.
+func (v *locationValue) String() string {
+	if *v.loc == nil {
+		return ""
+	}
+	return (*v.loc).String()
+}
+
+func (v *locationValue) Set(s string) error {
+	loc, err := time.LoadLocation(s)
+	if err != nil {
+		return newErrorf("time zone %q not found in the tz database", s)
+	}
+	*v.loc = loc
+	return nil
+}
+
+func (v *locationValue) Get() interface{} {
+	return *v.loc
+}
+
+func (v *locationValue) Type() string {
+	return "timezone"
+}
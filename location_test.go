@@ -0,0 +1,94 @@
+package sflag
+
+import (
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubLocations replaces the time zones of *time.Location flags by fixed
+// zones of the names in zones.
+func stubLocations(t *testing.T, zones map[string]int) {
+	orig := typeValues[locationType]
+	typeValues[locationType] = func(val reflect.Value) flag.Value {
+		return stubLocationValue{val.Addr().Interface().(**time.Location), zones}
+	}
+	t.Cleanup(func() { typeValues[locationType] = orig })
+}
+
+type stubLocationValue struct {
+	loc   **time.Location
+	zones map[string]int
+}
+
+func (v stubLocationValue) String() string {
+	if *v.loc == nil {
+		return ""
+	}
+	return (*v.loc).String()
+}
+
+func (v stubLocationValue) Set(s string) error {
+	offset, ok := v.zones[s]
+	if !ok {
+		return newErrorf("time zone %q not found in the tz database", s)
+	}
+	*v.loc = time.FixedZone(s, offset)
+	return nil
+}
+
+func TestLocation(t *testing.T) {
+	type options struct {
+		TZ      *time.Location `name:"tz" env:"TZ_NAME" default:"UTC" usage:"time zone"`
+		Display *time.Location `name:"display"`
+	}
+	var opts options
+	if err := (&Parser{}).Parse([]string{"prog", "-display", "Local"}, &opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.TZ != time.UTC || opts.Display != time.Local {
+		t.Errorf("got %v %v, want UTC Local", opts.TZ, opts.Display)
+	}
+	p := &Parser{ErrOutput: &strings.Builder{}}
+	if err := p.Parse([]string{"prog", "-tz", "Mars/Olympus_Mons"}, &opts); err == nil || !strings.Contains(err.Error(), `time zone "Mars/Olympus_Mons" not found in the tz database`) {
+		t.Errorf("Parse(Mars/Olympus_Mons) = %v", err)
+	}
+	checkHelp(t, helpOutput(t, &Parser{}, []string{"prog", "-h"}, &options{}), `Usage: prog [OPTION]...
+
+Options:
+  -tz       timezone (default: UTC, env: TZ_NAME)
+            time zone
+  -display  timezone
+  -h/-help
+            show this help
+`)
+
+	stubLocations(t, map[string]int{"UTC": 0, "Europe/Berlin": 3600, "Asia/Tokyo": 9 * 3600})
+	for _, c := range []struct {
+		args []string
+		env  string
+		want string
+	}{
+		{nil, "", "UTC"},
+		{nil, "Asia/Tokyo", "Asia/Tokyo"},
+		{[]string{"-tz", "Europe/Berlin"}, "Asia/Tokyo", "Europe/Berlin"},
+	} {
+		var opts options
+		p := &Parser{LookupEnv: func(key string) (string, bool) {
+			return c.env, key == "TZ_NAME" && c.env != ""
+		}}
+		if err := p.Parse(append([]string{"prog"}, c.args...), &opts); err != nil {
+			t.Errorf("Parse(%q): %v", c.args, err)
+			continue
+		}
+		if opts.TZ.String() != c.want {
+			t.Errorf("Parse(%q) with $TZ_NAME=%q = %v, want %s", c.args, c.env, opts.TZ, c.want)
+		}
+	}
+	p = &Parser{LookupEnv: func(string) (string, bool) { return "Mars/Olympus_Mons", true }}
+	if err := p.Parse([]string{"prog"}, &options{}); err == nil || err.Error() != `invalid value "Mars/Olympus_Mons" for $TZ_NAME (flag -tz): time zone "Mars/Olympus_Mons" not found in the tz database` {
+		t.Errorf("Parse with an unknown zone of the environment = %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package sflag
+
+import (
+	"flag"
+	"reflect"
+)
+
+// MarshalArgs returns the command line arguments that parse to the values of
+// the struct flagsPtr points to: a -name=value argument for every flag whose
+// value differs from its default, followed by the non-flag fields in the
+// order of declaration, and "--" followed by the passthrough field if it's
+// set. The values of the flags tagged with `secret:"true"` are masked. Neither
+// the environment nor the config is read.
+func MarshalArgs(flagsPtr interface{}) ([]string, error) {
+	return MarshalArgsWith(flagsPtr, MarshalOptions{})
+}
+
+// MarshalAllArgs is like MarshalArgs, but includes the flags of the default
+// value too.
+func MarshalAllArgs(flagsPtr interface{}) ([]string, error) {
+	return MarshalArgsWith(flagsPtr, MarshalOptions{All: true})
+}
+
+// MarshalOptions are the options of MarshalArgsWith.
+type MarshalOptions struct {
+	// All includes the flags of the default value too.
+	All bool
+	// OmitSecrets leaves out the flags tagged with `secret:"true"` instead
+	// of masking their values.
+	OmitSecrets bool
+	// ExplicitFlags leaves out the fields without a name tag, as
+	// Parser.ExplicitFlags does.
+	ExplicitFlags bool
+}
+
+// MarshalArgsWith is MarshalArgs with options.
+func MarshalArgsWith(flagsPtr interface{}, opts MarshalOptions) ([]string, error) {
+	p := &Parser{noValidators: true, ExplicitFlags: opts.ExplicitFlags}
+	flags := p.newCommandFlags("")
+	fields := p.defineCopy(flags, flag.NewFlagSet("", flag.ContinueOnError), flagsPtr)
+	if fields.err != nil {
+		return nil, fields.err
+	}
+
+	var args []string
+	for _, f := range flags.flags {
+		value := f.value.String()
+		if !opts.All && value == defaultString(f.value) {
+			continue
+		}
+		if f.Secret {
+			if opts.OmitSecrets {
+				continue
+			}
+			value = secretMask
+		}
+		args = append(args, "-"+f.value.names[0]+"="+value)
+	}
+
+	var nonFlags, slice []string
+	if fields.nonFlagSliceField.IsValid() {
+		for i := 0; i < fields.nonFlagSliceField.Len(); i++ {
+			fval, _ := newFlagValue(fields.nonFlagSliceField.Index(i))
+			slice = append(slice, fval.String())
+		}
+	}
+	trailing := fields.trailing()
+	for _, field := range fields.nonFlagFields[:len(fields.nonFlagFields)-len(trailing)] {
+		nonFlags = append(nonFlags, field.value.String())
+	}
+	if !fields.afterTerminator {
+		nonFlags = append(nonFlags, slice...)
+	}
+	for _, field := range trailing {
+		nonFlags = append(nonFlags, field.value.String())
+	}
+	for _, arg := range nonFlags {
+		// the non-flag arguments must not be parsed as flags, a lone "-"
+		// isn't one
+		if !isFlagArg(arg) {
+			continue
+		}
+		if fields.afterTerminator || fields.passthrough.IsValid() {
+			return nil, newErrorf("non-flag argument %q can't be marshaled before --", arg)
+		}
+		args = append(args, "--")
+		break
+	}
+	args = append(args, nonFlags...)
+	if fields.afterTerminator && len(slice) > 0 {
+		args = append(append(args, "--"), slice...)
+	}
+	// a nil passthrough field is of no "--"
+	if fields.passthrough.IsValid() && !fields.passthrough.IsNil() {
+		args = append(args, "--")
+		args = append(args, fields.passthrough.Interface().([]string)...)
+	}
+	return args, nil
+}
+
+// defaultString returns the string of the default value of v, which is the
+// zero value if there is no default.
+func defaultString(v *sourcedValue) string {
+	fval, _ := v.seps.newValue(reflect.New(v.field.Type()).Elem())
+	for _, s := range v.values[DefaultSource] {
+		_ = fval.Set(s)
+	}
+	return fval.String()
+}
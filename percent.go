@@ -0,0 +1,55 @@
+package sflag
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// percentValue sets a float field tagged with `format:"percent"`, which takes
+// a percentage such as 2.5% as the fraction 0.025, or a number without the %
+// as is. The field is printed as a percentage.
+type percentValue struct {
+	val reflect.Value
+}
+
+func (v *percentValue) String() string {
+	// rounded so that 0.07 prints as 7% rather than 7.000000000000001%
+	f, _ := strconv.ParseFloat(strconv.FormatFloat(v.val.Float()*100, 'g', 12, 64), 64)
+	return strconv.FormatFloat(f, 'f', -1, 64) + "%"
+}
+
+func (v *percentValue) Set(s string) error {
+	num := strings.TrimSuffix(s, "%")
+	f, err := strconv.ParseFloat(stripUnderscores(num), int(v.val.Type().Size()*8))
+	if err != nil {
+		return numError(err, s)
+	}
+	if num != s {
+		f /= 100
+	}
+	v.val.SetFloat(f)
+	return nil
+}
+
+// Get returns the fraction, such as 0.025 for 2.5%.
+func (v *percentValue) Get() interface{} {
+	return v.val.Interface()
+}
+
+func (v *percentValue) Type() string {
+	return "percent"
+}
+
+// parseFormatTag parses the format tag of the field of type t, which is
+// percent for floats.
+func parseFormatTag(tag string, t reflect.Type) (percent bool, ok bool) {
+	switch tag {
+	case "":
+		return false, true
+	case "percent":
+		k := t.Kind()
+		return true, k == reflect.Float32 || k == reflect.Float64
+	}
+	return false, false
+}
@@ -0,0 +1,88 @@
+package sflag
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPercentFormat(t *testing.T) {
+	type options struct {
+		SampleRate float64 `name:"sample-rate" format:"percent" default:"2.5%" usage:"sampled requests"`
+		Growth     float32 `name:"growth" format:"percent" env:"GROWTH"`
+		Ratio      float64 `name:"ratio" format:"percent" validate:"non-negative"`
+	}
+	p := &Parser{LookupEnv: func(key string) (string, bool) { return "150%", key == "GROWTH" }}
+	p.RegisterValidator("non-negative", func(value interface{}) error {
+		if value.(float64) < 0 {
+			return errors.New("must not be negative")
+		}
+		return nil
+	})
+	var opts options
+	if err := p.Parse([]string{"prog", "-ratio", "0.5"}, &opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := (options{SampleRate: 0.025, Growth: 1.5, Ratio: 0.5}); opts != want {
+		t.Errorf("got %+v, want %+v", opts, want)
+	}
+	if err := p.Parse([]string{"prog", "-sample-rate", "1_000%", "-ratio", "7%"}, &opts); err != nil {
+		t.Fatal(err)
+	}
+	if opts.SampleRate != 10 || opts.Ratio != 0.07 {
+		t.Errorf("got %+v", opts)
+	}
+	if got, err := MarshalArgs(&opts); err != nil || !reflect.DeepEqual(got, []string{"-sample-rate=1000%", "-growth=150%", "-ratio=7%"}) {
+		t.Errorf("MarshalArgs = %q, %v", got, err)
+	}
+
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-sample-rate", "50 %"}, `invalid value "50 %" for flag -sample-rate: strconv.ParseFloat: parsing "50 %": invalid syntax`},
+		{[]string{"-sample-rate", "%"}, `invalid value "%" for flag -sample-rate: strconv.ParseFloat: parsing "%": invalid syntax`},
+		{[]string{"-sample-rate", "5%%"}, `invalid value "5%%" for flag -sample-rate: strconv.ParseFloat: parsing "5%%": invalid syntax`},
+		{[]string{"-ratio", "-5%"}, `flag -ratio failed validator non-negative: must not be negative`},
+	} {
+		p.ErrOutput = &strings.Builder{}
+		var opts options
+		if err := p.Parse(append([]string{"prog"}, c.args...), &opts); err == nil || !strings.HasSuffix(err.Error(), c.err) {
+			t.Errorf("Parse(%q) = %v, want %s", c.args, err, c.err)
+		}
+	}
+
+	var help struct {
+		SampleRate float64 `name:"sample-rate" format:"percent" default:"0.025" usage:"sampled requests"`
+	}
+	checkHelp(t, helpOutput(t, &Parser{}, []string{"prog", "-h"}, &help), `Usage: prog [OPTION]
+
+Options:
+  -sample-rate  percent (default: 2.5%)
+                sampled requests
+  -h/-help
+                show this help
+`)
+
+	for _, c := range []struct {
+		ptr interface{}
+		err string
+	}{
+		{&struct {
+			N int `format:"percent"`
+		}{}, `invalid format tag "percent" of field N`},
+		{&struct {
+			F float64 `format:"ratio"`
+		}{}, `invalid format tag "ratio" of field F`},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(r.(error).Error(), c.err) {
+					t.Errorf("recovered %v, want %s", r, c.err)
+				}
+			}()
+			_ = (&Parser{}).Parse([]string{"prog"}, c.ptr)
+		}()
+	}
+}
@@ -0,0 +1,55 @@
+package sflag
+
+import "strings"
+
+// PrefixResolver is a CommandResolveFunc resolving a unique prefix of the
+// name of a visible command to the command, such as "sta" to "status". An
+// ambiguous prefix, such as "st" of "start" and "status", isn't resolved, so
+// the error of the unknown command suggests the similar ones.
+func PrefixResolver(args []string, commands []Command) ([]string, bool) {
+	var match string
+	for _, cmd := range visibleCommands(commands) {
+		if !strings.HasPrefix(cmd.Name, args[0]) || cmd.Name == match {
+			continue
+		}
+		if match != "" {
+			return args, false
+		}
+		match = cmd.Name
+	}
+	if match == "" {
+		return args, false
+	}
+	return replaceCommand(args, match), true
+}
+
+// AliasResolver returns a CommandResolveFunc resolving the keys of aliases to
+// the names of the commands they map to, such as "rm" to "remove".
+func AliasResolver(aliases map[string]string) CommandResolveFunc {
+	return func(args []string, commands []Command) ([]string, bool) {
+		name, ok := aliases[args[0]]
+		if !ok {
+			return args, false
+		}
+		return replaceCommand(args, name), true
+	}
+}
+
+// ChainResolvers returns a CommandResolveFunc trying resolvers in order, the
+// first one resolving the command wins.
+func ChainResolvers(resolvers ...CommandResolveFunc) CommandResolveFunc {
+	return func(args []string, commands []Command) ([]string, bool) {
+		for _, resolve := range resolvers {
+			if resolved, ok := resolve(args, commands); ok {
+				return resolved, true
+			}
+		}
+		return args, false
+	}
+}
+
+// replaceCommand returns a copy of args with the command name replaced by
+// name.
+func replaceCommand(args []string, name string) []string {
+	return append([]string{name}, args[1:]...)
+}
@@ -0,0 +1,45 @@
+package sflag
+
+import (
+	"strconv"
+	"unicode/utf8"
+)
+
+// Rune is a single character, such as the delimiter of a CSV tool, which is
+// labeled as char in help. It's given as itself, such as , or 日, or as an
+// escape sequence of Go, such as \t, \n or \u65e5.
+type Rune rune
+
+// String returns the character, which is empty for the zero Rune.
+func (r *Rune) String() string {
+	if *r == 0 {
+		return ""
+	}
+	return string(rune(*r))
+}
+
+func (r *Rune) Set(s string) error {
+	if len(s) > 1 && s[0] == '\\' {
+		c, _, tail, err := strconv.UnquoteChar(s, '\'')
+		if err != nil || tail != "" {
+			return newErrorf("invalid escape sequence %q", s)
+		}
+		*r = Rune(c)
+		return nil
+	}
+	c, size := utf8.DecodeRuneInString(s)
+	switch {
+	case s == "":
+		return newErrorf("missing character")
+	case c == utf8.RuneError && size == 1:
+		return newErrorf("invalid UTF-8 %q", s)
+	case size != len(s):
+		return newErrorf("%q is %d characters, expected one", s, utf8.RuneCountInString(s))
+	}
+	*r = Rune(c)
+	return nil
+}
+
+func (r *Rune) Type() string {
+	return "char"
+}
@@ -0,0 +1,61 @@
+package sflag
+
+import "testing"
+
+func TestRune(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Rune
+	}{
+		{",", ','},
+		{"日", '日'},
+		{"é", 'é'},
+		{`\`, '\\'},
+		{`\\`, '\\'},
+		{`\t`, '\t'},
+		{`\n`, '\n'},
+		{`\'`, '\''},
+		{`\x1f`, 0x1f},
+		{`\u65e5`, '日'},
+	}
+	for _, tt := range tests {
+		var r Rune
+		if err := r.Set(tt.in); err != nil {
+			t.Errorf("Set(%q): %v", tt.in, err)
+			continue
+		}
+		if r != tt.want || r.String() != string(rune(tt.want)) {
+			t.Errorf("Set(%q) = %q %q, want %q", tt.in, rune(r), r.String(), rune(tt.want))
+		}
+	}
+	for _, c := range []struct {
+		in, err string
+	}{
+		{"", "missing character"},
+		{"ab", `"ab" is 2 characters, expected one`},
+		{"日本", `"日本" is 2 characters, expected one`},
+		{`\q`, `invalid escape sequence "\\q"`},
+		{`\tx`, `invalid escape sequence "\\tx"`},
+		{"\xff", `invalid UTF-8 "\xff"`},
+	} {
+		var r Rune
+		if err := r.Set(c.in); err == nil || err.Error() != c.err {
+			t.Errorf("Set(%q) = %v, want %s", c.in, err, c.err)
+		}
+	}
+
+	var opts struct {
+		Delim Rune `name:"d" default:"," usage:"field delimiter"`
+	}
+	if err := (&Parser{}).Parse([]string{"prog", "-d", `\t`}, &opts); err != nil || opts.Delim != '\t' {
+		t.Errorf("Parse(-d \\t) = %q, %v", rune(opts.Delim), err)
+	}
+	checkHelp(t, helpOutput(t, &Parser{}, []string{"prog", "-h"}, &opts), `Usage: prog [OPTION]
+
+Options:
+  -d        char (default: ,)
+            field delimiter
+  -h/-help
+            show this help
+`)
+}
@@ -0,0 +1,81 @@
+package sflag
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// SecretReader reads the values of secret flags interactively.
+type SecretReader interface {
+	// ReadSecret prints prompt and returns the line read, without echoing
+	// it if possible.
+	ReadSecret(prompt string) (string, error)
+}
+
+// promptValues are the values of secret flags asking for them to be read by
+// the SecretReader.
+var promptValues = []string{"-", "prompt"}
+
+// stdinSecretReader reads secrets from in, the stdin of the parse, with the
+// echo of the terminal disabled, or as plain lines if it isn't a terminal.
+// The prompt is printed to out.
+type stdinSecretReader struct {
+	in  io.Reader
+	out io.Writer
+}
+
+func (r stdinSecretReader) ReadSecret(prompt string) (string, error) {
+	fprintf(r.out, "%s", prompt)
+	if f, ok := r.in.(*os.File); ok {
+		if restore, ok := disableEcho(f); ok {
+			defer func() {
+				restore()
+				// the newline typed isn't echoed
+				fprintln(r.out)
+			}()
+		}
+	}
+	return readLine(r.in)
+}
+
+// readLine reads a line of r byte by byte, so that nothing after it is
+// consumed.
+func readLine(r io.Reader) (string, error) {
+	var (
+		line []byte
+		b    [1]byte
+	)
+	for {
+		n, err := r.Read(b[:])
+		if n > 0 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF && len(line) > 0 {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSuffix(string(line), "\r"), nil
+}
+
+// promptFunc returns the function reading the value of the secret flag of
+// name, by the SecretReader of the parser or from the stdin of the parse.
+func (c *commandFlags) promptFunc(name string) func() (string, error) {
+	return func() (string, error) {
+		reader := c.secretReader
+		if reader == nil {
+			reader = stdinSecretReader{in: c.stdin.r, out: c.errOutput}
+		}
+		s, err := reader.ReadSecret(c.tr(MsgSecretPrompt, name))
+		if err != nil {
+			return "", newErrorf("read -%s: %v", name, err)
+		}
+		return s, nil
+	}
+}
@@ -0,0 +1,109 @@
+package sflag
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type fakeSecretReader struct {
+	secret  string
+	err     error
+	prompts []string
+}
+
+func (r *fakeSecretReader) ReadSecret(prompt string) (string, error) {
+	r.prompts = append(r.prompts, prompt)
+	return r.secret, r.err
+}
+
+type secretOpts struct {
+	Token string `name:"token" secret:"true" default:"dev-token"`
+	User  string `name:"user"`
+}
+
+func TestSecretPrompt(t *testing.T) {
+	for _, args := range [][]string{{"-token=prompt"}, {"-token", "-"}} {
+		reader := &fakeSecretReader{secret: "s3cret"}
+		var flags secretOpts
+		if err := (&Parser{SecretReader: reader}).Parse(append([]string{"prog"}, args...), &flags); err != nil {
+			t.Fatalf("Parse %q: %v", args, err)
+		}
+		if flags.Token != "s3cret" || !reflect.DeepEqual(reader.prompts, []string{"value of -token: "}) {
+			t.Errorf("Parse %q = %q, prompts %q, want s3cret read once", args, flags.Token, reader.prompts)
+		}
+	}
+
+	// only secret flags are prompted for
+	reader := &fakeSecretReader{secret: "s3cret"}
+	var flags secretOpts
+	if err := (&Parser{SecretReader: reader}).Parse([]string{"prog", "-user=prompt"}, &flags); err != nil || flags.User != "prompt" || len(reader.prompts) != 0 {
+		t.Errorf("Parse -user=prompt = %v, %q, prompts %q", err, flags.User, reader.prompts)
+	}
+
+	// read from Stdin, prompted on ErrOutput
+	var errOut bytes.Buffer
+	p := &Parser{Stdin: strings.NewReader("s3cret\nrest"), ErrOutput: &errOut}
+	if err := p.Parse([]string{"prog", "-token=prompt"}, &flags); err != nil || flags.Token != "s3cret" || errOut.String() != "value of -token: " {
+		t.Errorf("Parse -token=prompt = %v, %q, prompted %q, want s3cret read from Stdin", err, flags.Token, errOut.String())
+	}
+
+	reader = &fakeSecretReader{err: errors.New("no terminal")}
+	err := (&Parser{SecretReader: reader}).Parse([]string{"prog", "-token=prompt"}, &flags)
+	if err == nil || !strings.Contains(err.Error(), "read -token: no terminal") {
+		t.Errorf("Parse = %v, want the read error", err)
+	}
+}
+
+func TestSecretMasked(t *testing.T) {
+	flags := secretOpts{Token: "s3cret"}
+	args, err := MarshalArgs(&flags)
+	if err != nil || !reflect.DeepEqual(args, []string{"-token=****"}) {
+		t.Errorf("MarshalArgs = %q, %v, want the token masked", args, err)
+	}
+	if args, err := MarshalArgsWith(&flags, MarshalOptions{OmitSecrets: true}); err != nil || len(args) != 0 {
+		t.Errorf("MarshalArgsWith = %q, %v, want the token omitted", args, err)
+	}
+	help := helpOutput(t, &Parser{}, []string{"prog", "-h"}, &flags)
+	if strings.Contains(help, "dev-token") || !strings.Contains(help, "(default: ****)") {
+		t.Errorf("help shows the default of the secret:\n%s", help)
+	}
+
+	var keyed struct {
+		Key  string `name:"key" env:"SECRET_TEST_KEY" secret:"true" default:"dev-key"`
+		User string `name:"user"`
+	}
+	p := &Parser{LookupEnv: mapEnv(map[string]string{"SECRET_TEST_KEY": "env-s3cret"})}
+	if err := p.Parse([]string{"prog", "-user", "u"}, &keyed); err != nil || keyed.Key != "env-s3cret" {
+		t.Fatalf("Parse = %v, key %q, want the value of the env untouched", err, keyed.Key)
+	}
+	var dump strings.Builder
+	p.DumpEffective(&dump, &keyed)
+	if strings.Contains(dump.String(), "s3cret") || strings.Contains(dump.String(), "dev-key") {
+		t.Errorf("DumpEffective shows the secret:\n%s", dump.String())
+	}
+	p.VisitFlags(func(f Flag) {
+		if f.Names[0] != "key" {
+			return
+		}
+		if !f.Secret || f.Value != "****" || f.Default != "****" {
+			t.Errorf("VisitFlags = %+v, want the secret masked", f)
+		}
+	})
+	help = helpOutput(t, p, []string{"prog", "-h"}, &keyed)
+	if strings.Contains(help, "s3cret") || strings.Contains(help, "dev-key") {
+		t.Errorf("help shows the secret:\n%s", help)
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	r := strings.NewReader("pass\r\nnext")
+	if line, err := readLine(r); err != nil || line != "pass" {
+		t.Errorf("readLine = %q, %v, want pass", line, err)
+	}
+	if line, err := readLine(r); err != nil || line != "next" {
+		t.Errorf("readLine = %q, %v, want the unterminated line", line, err)
+	}
+}
@@ -0,0 +1,123 @@
+// Package sflagcobra converts sflag commands to cobra commands, in its own
+// module so that sflag doesn't depend on cobra.
+package sflagcobra
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/zhuah/sflag"
+	"github.com/zhuah/sflag/sflagpflag"
+)
+
+// ToCobra returns a cobra command named root running cmds as subcommands. The
+// fields of globalFlags are bound as persistent flags of the root and the
+// fields of Command.Flags as flags of the commands, their non-flag fields
+// take the arguments of the commands before Command.Args checks them.
+// Handlers receive the arguments following the command name with the name as
+// the first, as they do when run by sflag. The non-flag fields of globalFlags
+// are not supported.
+//
+// The nested commands of StructCommands are subcommands of their command,
+// with the flags before them bound as its persistent flags.
+//
+// It panics if a struct can't be bound, as sflag does for invalid structs.
+func ToCobra(root string, globalFlags interface{}, cmds ...sflag.Command) *cobra.Command {
+	rootCmd := &cobra.Command{
+		Use:           root,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	addCommands(rootCmd, globalFlags, cmds)
+	return rootCmd
+}
+
+// addCommands binds flags as persistent flags of parent and adds cmds to it.
+func addCommands(parent *cobra.Command, flags interface{}, cmds []sflag.Command) {
+	if flags != nil {
+		if err := sflagpflag.BindPFlagSet(parent.PersistentFlags(), flags); err != nil {
+			panic(err)
+		}
+	}
+	groups := make(map[string]bool)
+	for _, cmd := range cmds {
+		c := toCobra(cmd)
+		if cmd.Group != "" {
+			if !groups[cmd.Group] {
+				groups[cmd.Group] = true
+				parent.AddGroup(&cobra.Group{ID: cmd.Group, Title: cmd.Group + " Commands:"})
+			}
+			c.GroupID = cmd.Group
+		}
+		parent.AddCommand(c)
+	}
+}
+
+func toCobra(cmd sflag.Command) *cobra.Command {
+	if subs, flags := cmd.Subcommands(); subs != nil {
+		c := &cobra.Command{
+			Use:    cmd.Name,
+			Short:  cmd.Usage,
+			Hidden: cmd.Hidden,
+		}
+		addCommands(c, flags, subs)
+		return c
+	}
+	cmdArgs := func(args []string) []string {
+		return append([]string{cmd.Name}, args...)
+	}
+	c := &cobra.Command{
+		Use:    cmd.Name,
+		Short:  cmd.Usage,
+		Hidden: cmd.Hidden,
+		RunE: func(c *cobra.Command, args []string) error {
+			switch {
+			case cmd.RunCtx != nil:
+				return cmd.RunCtx(c.Context(), cmdArgs(args))
+			case cmd.RunE != nil:
+				return cmd.RunE(cmdArgs(args))
+			case cmd.Run != nil:
+				cmd.Run(cmdArgs(args))
+				return nil
+			}
+			panic("Command.Run, Command.RunE and Command.RunCtx are nil: " + cmd.Name)
+		},
+	}
+	if len(cmd.Examples) > 0 {
+		c.Example = "  " + strings.Join(cmd.Examples, "\n  ")
+	}
+	if cmd.PreRun != nil {
+		c.PreRunE = func(_ *cobra.Command, args []string) error {
+			return cmd.PreRun(cmdArgs(args))
+		}
+	}
+	if cmd.PostRun != nil {
+		c.PostRunE = func(_ *cobra.Command, args []string) error {
+			return cmd.PostRun(cmdArgs(args))
+		}
+	}
+	if cmd.Complete != nil {
+		c.ValidArgsFunction = func(_ *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return cmd.Complete(args, toComplete), cobra.ShellCompDirectiveDefault
+		}
+	}
+	if cmd.Flags != nil {
+		if err := sflagpflag.BindPFlagSet(c.Flags(), cmd.Flags); err != nil {
+			panic(err)
+		}
+	}
+	if cmd.Flags != nil || cmd.Args != nil {
+		c.Args = func(_ *cobra.Command, args []string) error {
+			if cmd.Flags != nil {
+				if err := sflag.SetNonFlags(cmd.Flags, args); err != nil {
+					return err
+				}
+			}
+			if cmd.Args != nil {
+				return cmd.Args(args)
+			}
+			return nil
+		}
+	}
+	return c
+}
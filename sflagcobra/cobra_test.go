@@ -0,0 +1,197 @@
+package sflagcobra
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/zhuah/sflag"
+)
+
+type globalFlags struct {
+	Verbose bool   `name:"verbose,v"`
+	Config  string `name:"config" default:"app.json"`
+}
+
+type serveFlags struct {
+	Port  int      `name:"port,p" default:"8080"`
+	Host  string   `name:"host"`
+	Dir   string   `name:"#DIR" default:"."`
+	Files []string `name:"#"`
+}
+
+// run parses args by sflag if viaCobra is false, by the cobra command
+// otherwise, and returns the parsed flags and the arguments of the command.
+func run(t *testing.T, args []string, viaCobra bool) (globalFlags, serveFlags, []string) {
+	t.Helper()
+	var (
+		global  globalFlags
+		serve   serveFlags
+		cmdArgs []string
+	)
+	commands := []sflag.Command{
+		{
+			Name:  "serve",
+			Usage: "serve files",
+			Flags: &serve,
+			Run:   func(args []string) { cmdArgs = args },
+		},
+		{
+			Name:   "migrate",
+			Hidden: true,
+			Group:  "Admin",
+			Run:    func(args []string) { cmdArgs = args },
+		},
+	}
+	if err := execute(args, viaCobra, &global, commands); err != nil {
+		t.Fatalf("run %q (cobra %v): %v", args, viaCobra, err)
+	}
+	return global, serve, cmdArgs
+}
+
+// execute runs the commands by sflag or by the cobra commands of them.
+func execute(args []string, viaCobra bool, global interface{}, commands []sflag.Command) error {
+	if viaCobra {
+		root := ToCobra("prog", global, commands...)
+		root.SetArgs(args)
+		return root.Execute()
+	}
+	return (&sflag.Parser{ErrOutput: ioutil.Discard}).RunCommandE(append([]string{"prog"}, args...), global, commands...)
+}
+
+func TestToCobra(t *testing.T) {
+	for _, args := range [][]string{
+		{"-v", "serve", "-p", "80", "--host", "h", "dir", "a", "b"},
+		{"--config", "other.json", "serve", "dir"},
+		{"serve"},
+		{"migrate"},
+	} {
+		global, serve, cmdArgs := run(t, args, false)
+		cobraGlobal, cobraServe, cobraArgs := run(t, args, true)
+		if cmdArgs[0] != "serve" {
+			// cobra sets the defaults of all the commands when they are bound
+			serve, cobraServe = serveFlags{}, serveFlags{}
+		}
+		if !reflect.DeepEqual(cobraGlobal, global) || !reflect.DeepEqual(cobraServe, serve) {
+			t.Errorf("%q: cobra parsed %+v %+v, want %+v %+v", args, cobraGlobal, cobraServe, global, serve)
+		}
+		// sflag passes the non-flag arguments, cobra the ones without flags
+		if cobraArgs[0] != cmdArgs[0] {
+			t.Errorf("%q: cobra ran %q, want %q", args, cobraArgs, cmdArgs)
+		}
+	}
+
+	root := ToCobra("prog", nil, sflag.Command{Name: "migrate", Hidden: true, Group: "Admin", Run: func([]string) {}})
+	migrate, _, err := root.Find([]string{"migrate"})
+	if err != nil || !migrate.Hidden || migrate.GroupID != "Admin" {
+		t.Errorf("migrate = %+v, %v, want a hidden command of group Admin", migrate, err)
+	}
+}
+
+func TestToCobraRequiredNonFlag(t *testing.T) {
+	type copyFlags struct {
+		Dir string `name:"#DIR"`
+		Dst string `name:"#DST" default:"."`
+	}
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"cp"}, "missing required argument: DIR"},
+		{[]string{"cp", "a"}, ""},
+		{[]string{"cp", "a", "b"}, ""},
+	} {
+		var errs [2]string
+		for i, viaCobra := range []bool{false, true} {
+			var flags copyFlags
+			cmd := sflag.Command{Name: "cp", Flags: &flags, Run: func([]string) {}}
+			if err := execute(c.args, viaCobra, nil, []sflag.Command{cmd}); err != nil {
+				errs[i] = err.Error()
+			}
+		}
+		if errs[0] != c.err || errs[1] != c.err {
+			t.Errorf("%q: sflag error %q, cobra error %q, want %q", c.args, errs[0], errs[1], c.err)
+		}
+	}
+}
+
+func TestToCobraArgs(t *testing.T) {
+	type copyFlags struct {
+		Force bool     `name:"force,f"`
+		Files []string `name:"#"`
+	}
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"cp", "a"}, ""},
+		{[]string{"cp", "-f", "a"}, ""},
+		{[]string{"cp", "a", "b"}, "accepts 1 argument, got 2"},
+	} {
+		var errs [2]string
+		for i, viaCobra := range []bool{false, true} {
+			var flags copyFlags
+			cmd := sflag.Command{Name: "cp", Flags: &flags, Args: sflag.ExactArgs(1), Run: func([]string) {}}
+			if err := execute(c.args, viaCobra, nil, []sflag.Command{cmd}); err != nil {
+				errs[i] = err.Error()
+			}
+		}
+		// sflag names the command in the error
+		if c.err == "" && errs[0] != "" || c.err != "" && errs[0] != `command "cp" `+c.err || errs[1] != c.err {
+			t.Errorf("%q: sflag error %q, cobra error %q, want %q", c.args, errs[0], errs[1], c.err)
+		}
+	}
+
+	root := ToCobra("prog", nil, sflag.Command{Name: "version", Args: sflag.NoArgs, Run: func([]string) {}})
+	root.SetArgs([]string{"version", "x"})
+	if err := root.Execute(); err == nil {
+		t.Error("version x: no error, want Args to reject the argument")
+	}
+}
+
+type remoteAddCmd struct {
+	URL   string   `name:"url" required:"true"`
+	Names []string `name:"#NAME"`
+	ran   *[]string
+}
+
+func (c *remoteAddCmd) Run(args []string) error {
+	*c.ran = args
+	return nil
+}
+
+type remoteCmd struct {
+	Verbose bool         `name:"v"`
+	Add     remoteAddCmd `cmd:"add" group:"Edit"`
+}
+
+type structRoot struct {
+	Debug  bool      `name:"debug"`
+	Remote remoteCmd `cmd:"remote" usage:"manage remotes"`
+}
+
+func TestToCobraNestedCommands(t *testing.T) {
+	args := []string{"--debug", "remote", "-v", "add", "--url", "git@x", "origin"}
+	var roots [2]structRoot
+	var ran [2][]string
+	for i, viaCobra := range []bool{false, true} {
+		roots[i].Remote.Add.ran = &ran[i]
+		if err := execute(args, viaCobra, &roots[i], sflag.StructCommands(&roots[i])); err != nil {
+			t.Fatalf("run %q (cobra %v): %v", args, viaCobra, err)
+		}
+		roots[i].Remote.Add.ran = nil
+	}
+	want := structRoot{Debug: true, Remote: remoteCmd{Verbose: true, Add: remoteAddCmd{URL: "git@x", Names: []string{"origin"}}}}
+	if !reflect.DeepEqual(roots[0], want) || !reflect.DeepEqual(roots[1], want) {
+		t.Errorf("sflag parsed %+v, cobra %+v, want %+v", roots[0], roots[1], want)
+	}
+	if len(ran[0]) == 0 || ran[0][0] != "add" || len(ran[1]) == 0 || ran[1][0] != "add" {
+		t.Errorf("sflag ran add with %q, cobra with %q", ran[0], ran[1])
+	}
+
+	var root structRoot
+	add, _, err := ToCobra("prog", &root, sflag.StructCommands(&root)...).Find([]string{"remote", "add"})
+	if err != nil || add.Name() != "add" || add.GroupID != "Edit" || add.Parent().Short != "manage remotes" {
+		t.Errorf("remote add = %+v, %v, want a subcommand of group Edit of remote", add, err)
+	}
+}
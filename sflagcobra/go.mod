@@ -0,0 +1,14 @@
+module github.com/zhuah/sflag/sflagcobra
+
+go 1.15
+
+require (
+	github.com/spf13/cobra v1.10.2
+	github.com/zhuah/sflag v0.0.0-00010101000000-000000000000
+	github.com/zhuah/sflag/sflagpflag v0.0.0-00010101000000-000000000000
+)
+
+replace (
+	github.com/zhuah/sflag => ../
+	github.com/zhuah/sflag/sflagpflag => ../sflagpflag
+)
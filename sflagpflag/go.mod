@@ -0,0 +1,10 @@
+module github.com/zhuah/sflag/sflagpflag
+
+go 1.12
+
+require (
+	github.com/spf13/pflag v1.0.5
+	github.com/zhuah/sflag v0.0.0-00010101000000-000000000000
+)
+
+replace github.com/zhuah/sflag => ../
@@ -0,0 +1,69 @@
+// Package sflagpflag registers the fields of sflag structs to pflag flag
+// sets, in its own module so that sflag doesn't depend on pflag.
+package sflagpflag
+
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+	"github.com/zhuah/sflag"
+)
+
+// pflagValue adds the Type method of pflag.Value to the values of sflag.
+type pflagValue struct {
+	sflag.BoundFlag
+}
+
+func (v pflagValue) String() string     { return v.Value.String() }
+func (v pflagValue) Set(s string) error { return v.Value.Set(s) }
+func (v pflagValue) Type() string {
+	if v.BoundFlag.Type == "" {
+		return "value"
+	}
+	return v.BoundFlag.Type
+}
+
+// BindPFlagSet registers the fields of the struct flagsPtr points to as flags
+// of fs, by the struct tags of sflag. The single letter name of a field is
+// the shorthand, the other names are registered as hidden aliases. The
+// fields are set to the values of their environment variables or defaults.
+func BindPFlagSet(fs *pflag.FlagSet, flagsPtr interface{}) error {
+	return BindPFlagSetParser(&sflag.Parser{}, fs, flagsPtr)
+}
+
+// BindPFlagSetParser is like BindPFlagSet, the environment, config values and
+// precedence of p are used.
+func BindPFlagSetParser(p *sflag.Parser, fs *pflag.FlagSet, flagsPtr interface{}) error {
+	return p.BindFlags(flagsPtr, func(f sflag.BoundFlag) error {
+		value := pflagValue{f}
+		if err := checkUnused(fs, f.Name, f.Shorthand); err != nil {
+			return err
+		}
+		flag := fs.VarPF(value, f.Name, f.Shorthand, f.Usage)
+		if f.IsBool() {
+			flag.NoOptDefVal = "true"
+		}
+		flag.Hidden = f.Hidden
+		flag.Deprecated = f.Deprecated
+		for _, alias := range f.Aliases {
+			if err := checkUnused(fs, alias, ""); err != nil {
+				return err
+			}
+			aliasFlag := fs.VarPF(value, alias, "", f.Usage)
+			aliasFlag.NoOptDefVal = flag.NoOptDefVal
+			aliasFlag.Hidden = true
+		}
+		return nil
+	})
+}
+
+// checkUnused reports the names defined already, on which pflag panics.
+func checkUnused(fs *pflag.FlagSet, name, shorthand string) error {
+	if fs.Lookup(name) != nil {
+		return fmt.Errorf("flag --%s is defined already", name)
+	}
+	if shorthand != "" && fs.ShorthandLookup(shorthand) != nil {
+		return fmt.Errorf("shorthand -%s of flag --%s is defined already", shorthand, name)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package sflagpflag
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+type options struct {
+	Workers int           `name:"workers,w" default:"4" usage:"number of workers"`
+	Verbose bool          `name:"verbose,v"`
+	Timeout time.Duration `name:"timeout,wait"`
+	Old     string        `deprecated:"use --name instead"`
+	Debug   bool          `hidden:"true"`
+}
+
+func TestBindPFlagSet(t *testing.T) {
+	fs := pflag.NewFlagSet("prog", pflag.ContinueOnError)
+	var flags options
+	if err := BindPFlagSet(fs, &flags); err != nil {
+		t.Fatalf("BindPFlagSet: %v", err)
+	}
+	if flags.Workers != 4 {
+		t.Errorf("workers %d, want the default", flags.Workers)
+	}
+	if err := fs.Parse([]string{"-w", "8", "-v", "--wait", "1m", "--debug"}); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := options{Workers: 8, Verbose: true, Timeout: time.Minute, Debug: true}
+	if !reflect.DeepEqual(flags, want) {
+		t.Errorf("flags %+v, want %+v", flags, want)
+	}
+	if f := fs.Lookup("workers"); f == nil || f.Shorthand != "w" || f.DefValue != "4" || f.Value.Type() != "int" {
+		t.Errorf("--workers = %+v, want shorthand w, default 4 and type int", f)
+	}
+	if f := fs.Lookup("old"); f == nil || f.Deprecated != "use --name instead" {
+		t.Errorf("--old = %+v, want deprecated", f)
+	}
+	if f := fs.Lookup("debug"); f == nil || !f.Hidden {
+		t.Errorf("--debug = %+v, want hidden", f)
+	}
+	if f := fs.Lookup("wait"); f == nil || !f.Hidden {
+		t.Errorf("--wait = %+v, want a hidden alias", f)
+	}
+
+	if err := BindPFlagSet(fs, &flags); err == nil || err.Error() != "flag --workers is defined already" {
+		t.Errorf("BindPFlagSet again = %v, want the collision", err)
+	}
+}
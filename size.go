@@ -0,0 +1,68 @@
+package sflag
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Size is a count of bytes given with an optional unit, such as 512, 10KB,
+// 1.5GiB or 64M. The units KB, MB, GB and TB are powers of 1000, KiB, MiB,
+// GiB and TiB and the single letters K, M, G and T are powers of 1024. Units
+// are case-insensitive.
+type Size int64
+
+var sizeUnits = []struct {
+	name string
+	size float64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30}, {"tib", 1 << 40},
+	{"kb", 1e3}, {"mb", 1e6}, {"gb", 1e9}, {"tb", 1e12},
+	{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30}, {"t", 1 << 40},
+	{"b", 1},
+}
+
+// binaryUnits are the units of String, the largest first.
+var binaryUnits = []struct {
+	name string
+	size int64
+}{
+	{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+}
+
+// String formats the size by the largest binary unit dividing it, such as
+// 1536 as 1536 and 1048576 as 1MiB.
+func (s *Size) String() string {
+	n := int64(*s)
+	for _, unit := range binaryUnits {
+		if n != 0 && n%unit.size == 0 {
+			return strconv.FormatInt(n/unit.size, 10) + unit.name
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func (s *Size) Set(v string) error {
+	num := v
+	lower := strings.ToLower(num)
+	scale := 1.0
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(lower, unit.name) {
+			num, scale = num[:len(num)-len(unit.name)], unit.size
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return newErrorf("invalid size %q", v)
+	}
+	if f*scale > math.MaxInt64 {
+		return newErrorf("size %q out of range", v)
+	}
+	*s = Size(f * scale)
+	return nil
+}
+
+func (s *Size) Type() string {
+	return "size"
+}
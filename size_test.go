@@ -0,0 +1,35 @@
+package sflag
+
+import "testing"
+
+func TestSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Size
+		str  string
+	}{
+		{"512", 512, "512"},
+		{"10KB", 10000, "10000"},
+		{"1.5GiB", 3 << 29, "1536MiB"},
+		{"64M", 64 << 20, "64MiB"},
+		{"2kib", 2048, "2KiB"},
+		{"1 t", 1 << 40, "1TiB"},
+		{"7b", 7, "7"},
+	}
+	for _, tt := range tests {
+		var s Size
+		if err := s.Set(tt.in); err != nil {
+			t.Errorf("Set(%q): %v", tt.in, err)
+			continue
+		}
+		if s != tt.want || s.String() != tt.str {
+			t.Errorf("Set(%q) = %d %q, want %d %q", tt.in, int64(s), s.String(), int64(tt.want), tt.str)
+		}
+	}
+	for _, in := range []string{"", "KB", "-1", "1XB", "1e30TB"} {
+		var s Size
+		if err := s.Set(in); err == nil {
+			t.Errorf("Set(%q) = %d, want an error", in, int64(s))
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package sflag
+
+import (
+	"flag"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// multiValue is a flag.Value of a slice or map field taking several values.
+// The first value set after reset replaces the field, unless keep is true,
+// the following ones add to it. Each of the values of the command line, the
+// environment, the config and the default may hold several elements
+// separated by commas, or the separator of the sep tag. The environment
+// values of slices are split by the path list separator instead, or the one
+// of the env-sep tag.
+type multiValue interface {
+	flag.Value
+	reset(keep bool)
+	setSeparators(seps separators)
+}
+
+// separators are the sep and kvsep tags of a slice or map flag, which
+// separate the elements of a value and the keys and values of maps. Empty
+// ones are the defaults, a comma and "=". env separates the elements of the
+// environment values of slices.
+type separators struct {
+	elem, kv, env string
+	// dropEmpty drops the empty elements, such as the one after a trailing
+	// separator.
+	dropEmpty bool
+	// unique is the unique tag of a slice, which is kept along as it
+	// applies to the values of all the sources.
+	unique uniqueMode
+	// percent is the percent format tag of a float, kept along for the
+	// same reason.
+	percent bool
+}
+
+type uniqueMode int
+
+const (
+	uniqueNever uniqueMode = iota
+	// uniqueSkip skips the elements equal to one already in the slice.
+	uniqueSkip
+	// uniqueError makes an element equal to one already in the slice an
+	// error.
+	uniqueError
+)
+
+// parseUniqueTag parses the unique tag, which is a bool or "error".
+func parseUniqueTag(tag string) (uniqueMode, bool) {
+	switch tag {
+	case "":
+		return uniqueNever, true
+	case "error":
+		return uniqueError, true
+	}
+	unique, err := strconv.ParseBool(tag)
+	if err != nil {
+		return uniqueNever, false
+	}
+	if unique {
+		return uniqueSkip, true
+	}
+	return uniqueNever, true
+}
+
+// of returns the separators of the values of src, the environment values of
+// slices are split by env, dropping the empty elements.
+func (s separators) of(src Source) separators {
+	if src == EnvSource && s.env != "" {
+		return separators{elem: s.env, env: s.env, dropEmpty: true, unique: s.unique, percent: s.percent}
+	}
+	return s
+}
+
+func (s separators) elemSep() string {
+	if s.elem == "" {
+		return ","
+	}
+	return s.elem
+}
+
+func (s separators) kvSep() string {
+	if s.kv == "" {
+		return "="
+	}
+	return s.kv
+}
+
+// newValue returns the flag.Value setting the addressable val, of which
+// slices and maps take the separators s, and floats the percent format.
+func (s separators) newValue(val reflect.Value) (flag.Value, bool) {
+	if s.percent {
+		return &percentValue{val: val}, true
+	}
+	fval, ok := newFlagValue(val)
+	if mv, isMulti := fval.(multiValue); isMulti {
+		mv.setSeparators(s)
+	}
+	return fval, ok
+}
+
+// newMultiValue returns the multiValue of the slice or map val, false if the
+// elements aren't supported.
+func newMultiValue(val reflect.Value) (multiValue, bool) {
+	switch t := val.Type(); t.Kind() {
+	case reflect.Slice:
+		if !isElemType(t.Elem()) {
+			return nil, false
+		}
+		return &sliceValue{val: val}, true
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String || reflect.PtrTo(t.Key()).Implements(flagValueType) || !isElemType(t.Elem()) {
+			return nil, false
+		}
+		return &mapValue{val: val}, true
+	}
+	return nil, false
+}
+
+// isElemType reports whether t is supported as the element of a slice flag
+// or the value of a map flag, which are the types of single values.
+func isElemType(t reflect.Type) bool {
+	fval, ok := newFlagValue(reflect.New(t).Elem())
+	if !ok {
+		return false
+	}
+	_, multi := fval.(multiValue)
+	return !multi
+}
+
+// mapTypeName returns the type name of the map type t in help, which is the
+// keys and the values separated by kvsep, such as key=int.
+func mapTypeName(t reflect.Type, kvsep string) string {
+	return "key" + kvsep + nonFlagTypeName(t.Elem())
+}
+
+// split splits s into the elements separated by elemSep, the empty string
+// has none.
+func (s separators) split(str string) []string {
+	if str == "" {
+		return nil
+	}
+	elems := strings.Split(str, s.elemSep())
+	if s.dropEmpty {
+		kept := elems[:0]
+		for _, e := range elems {
+			if e != "" {
+				kept = append(kept, e)
+			}
+		}
+		elems = kept
+	}
+	return elems
+}
+
+// sliceValue sets a slice field, each element of the values is appended,
+// unless the unique tag skips the ones already in it, compared by their
+// strings.
+type sliceValue struct {
+	val  reflect.Value
+	seps separators
+	// touched is true once the field holds a slice of its own, appended to
+	// by the values set.
+	touched bool
+}
+
+func (v *sliceValue) setSeparators(seps separators) {
+	v.seps = seps
+}
+
+func (v *sliceValue) reset(keep bool) {
+	v.touched = false
+	if keep {
+		// copied so that appending leaves the original array alone
+		v.val.Set(reflect.AppendSlice(reflect.MakeSlice(v.val.Type(), 0, v.val.Len()), v.val))
+		v.touched = true
+	}
+}
+
+func (v *sliceValue) String() string {
+	elems := make([]string, v.val.Len())
+	for i := range elems {
+		fval, _ := newFlagValue(v.val.Index(i))
+		elems[i] = fval.String()
+	}
+	return strings.Join(elems, v.seps.elemSep())
+}
+
+func (v *sliceValue) Get() interface{} {
+	return v.val.Interface()
+}
+
+func (v *sliceValue) Set(s string) error {
+	slice := v.val
+	if !v.touched {
+		slice = reflect.MakeSlice(v.val.Type(), 0, 0)
+	}
+	for _, e := range v.seps.split(s) {
+		elem := reflect.New(slice.Type().Elem()).Elem()
+		fval, _ := newFlagValue(elem)
+		if err := fval.Set(e); err != nil {
+			return err
+		}
+		if v.seps.unique != uniqueNever && hasElem(slice, fval.String()) {
+			if v.seps.unique == uniqueError {
+				return newErrorf("duplicate value %q", fval.String())
+			}
+			continue
+		}
+		slice = reflect.Append(slice, elem)
+	}
+	v.val.Set(slice)
+	v.touched = true
+	return nil
+}
+
+// hasElem reports whether an element of slice has the string s.
+func hasElem(slice reflect.Value, s string) bool {
+	for i := 0; i < slice.Len(); i++ {
+		if fval, _ := newFlagValue(slice.Index(i)); fval.String() == s {
+			return true
+		}
+	}
+	return false
+}
+
+// mapValue sets a map field of string keys, each element of the values is a
+// key=value pair added to the map, the value is parsed as a flag of the
+// element type.
+type mapValue struct {
+	val  reflect.Value
+	seps separators
+	// touched is true once the field holds a map of its own.
+	touched bool
+}
+
+func (v *mapValue) setSeparators(seps separators) {
+	v.seps = seps
+}
+
+func (v *mapValue) reset(keep bool) {
+	v.touched = false
+	if keep {
+		m := reflect.MakeMap(v.val.Type())
+		for _, key := range v.val.MapKeys() {
+			m.SetMapIndex(key, v.val.MapIndex(key))
+		}
+		v.val.Set(m)
+		v.touched = true
+	}
+}
+
+// String returns the pairs sorted by key.
+func (v *mapValue) String() string {
+	keys := v.val.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		// copied as the elements of maps aren't addressable
+		elem := reflect.New(v.val.Type().Elem()).Elem()
+		elem.Set(v.val.MapIndex(key))
+		fval, _ := newFlagValue(elem)
+		pairs[i] = key.String() + v.seps.kvSep() + fval.String()
+	}
+	return strings.Join(pairs, v.seps.elemSep())
+}
+
+func (v *mapValue) Get() interface{} {
+	return v.val.Interface()
+}
+
+func (v *mapValue) Set(s string) error {
+	m := v.val
+	if !v.touched || m.IsNil() {
+		m = reflect.MakeMap(v.val.Type())
+	}
+	// parsed before any is set, so that an invalid pair leaves the map
+	// as is
+	var keys, values []reflect.Value
+	kvsep := v.seps.kvSep()
+	for _, e := range v.seps.split(s) {
+		i := strings.Index(e, kvsep)
+		if i < 0 {
+			return newErrorf("%q isn't a key%svalue pair", e, kvsep)
+		}
+		k, s := e[:i], e[i+len(kvsep):]
+		key := reflect.New(m.Type().Key()).Elem()
+		key.SetString(k)
+		value := reflect.New(m.Type().Elem()).Elem()
+		fval, _ := newFlagValue(value)
+		if err := fval.Set(s); err != nil {
+			return newErrorf("invalid value %q of key %q: %v", s, k, err)
+		}
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+	for i, key := range keys {
+		m.SetMapIndex(key, values[i])
+	}
+	v.val.Set(m)
+	v.touched = true
+	return nil
+}
@@ -0,0 +1,424 @@
+package sflag
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSliceFlags(t *testing.T) {
+	type options struct {
+		Tags    []string          `name:"tag" env:"TAGS" default:"a,b"`
+		Extra   []string          `name:"extra" env:"EXTRA" default:"a,b" append-to-default:"true"`
+		Ports   []int             `name:"port"`
+		Timeout []time.Duration   `name:"timeout"`
+		Labels  map[string]string `name:"label" env:"LABELS" default:"app=web"`
+	}
+	for _, c := range []struct {
+		args   []string
+		env    map[string]string
+		config string
+		want   options
+	}{
+		{
+			want: options{Tags: []string{"a", "b"}, Extra: []string{"a", "b"}, Labels: map[string]string{"app": "web"}},
+		},
+		{
+			args: []string{"-tag", "c", "-tag", "d,e", "-extra", "c", "-port", "80", "-port", "443", "-timeout", "1s,2m"},
+			want: options{
+				Tags:    []string{"c", "d", "e"},
+				Extra:   []string{"a", "b", "c"},
+				Ports:   []int{80, 443},
+				Timeout: []time.Duration{time.Second, 2 * time.Minute},
+				Labels:  map[string]string{"app": "web"},
+			},
+		},
+		{
+			env:  map[string]string{"TAGS": "x" + string(os.PathListSeparator) + "y", "EXTRA": "x", "LABELS": "env=prod,app=api"},
+			want: options{Tags: []string{"x", "y"}, Extra: []string{"a", "b", "x"}, Labels: map[string]string{"env": "prod", "app": "api"}},
+		},
+		{
+			args: []string{"-tag", "c", "-label", "tier=db", "-label", "zone=a"},
+			env:  map[string]string{"TAGS": "x,y", "LABELS": "env=prod"},
+			want: options{Tags: []string{"c"}, Extra: []string{"a", "b"}, Labels: map[string]string{"tier": "db", "zone": "a"}},
+		},
+		{
+			args:   []string{"-extra", "f"},
+			config: `{"tag": ["c", "d"], "extra": ["e"]}`,
+			want:   options{Tags: []string{"c", "d"}, Extra: []string{"a", "b", "f"}, Labels: map[string]string{"app": "web"}},
+		},
+		{
+			// an empty value clears the default
+			args: []string{"-tag="},
+			want: options{Tags: []string{}, Extra: []string{"a", "b"}, Labels: map[string]string{"app": "web"}},
+		},
+	} {
+		p := &Parser{LookupEnv: func(key string) (string, bool) {
+			v, ok := c.env[key]
+			return v, ok
+		}}
+		if c.config != "" {
+			if err := p.LoadConfig(strings.NewReader(c.config), "json"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var opts options
+		if err := p.Parse(append([]string{"prog"}, c.args...), &opts); err != nil {
+			t.Errorf("Parse(%q): %v", c.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(opts, c.want) {
+			t.Errorf("Parse(%q) with %v = %+v, want %+v", c.args, c.env, opts, c.want)
+		}
+	}
+}
+
+func TestSliceFlagsKeepField(t *testing.T) {
+	type options struct {
+		Hosts []string `name:"host"`
+		More  []string `name:"more" append-to-default:"true"`
+	}
+	base := []string{"a", "b"}
+	opts := options{Hosts: base[:1], More: base[:1]}
+	if err := (&Parser{}).Parse([]string{"prog", "-host", "x", "-host", "y", "-more", "z"}, &opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := (options{Hosts: []string{"x", "y"}, More: []string{"a", "z"}}); !reflect.DeepEqual(opts, want) {
+		t.Errorf("got %+v, want %+v", opts, want)
+	}
+	if base[1] != "b" {
+		t.Errorf("the array of the field is overwritten: %q", base)
+	}
+
+	opts = options{Hosts: []string{"a"}}
+	if err := (&Parser{}).Parse([]string{"prog"}, &opts); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(opts.Hosts, []string{"a"}) {
+		t.Errorf("the field without values = %q, want [a]", opts.Hosts)
+	}
+}
+
+func TestSliceFlagsInvalid(t *testing.T) {
+	var opts struct {
+		Ports  []int             `name:"port"`
+		Labels map[string]string `name:"label"`
+	}
+	for _, args := range [][]string{
+		{"-port", "80,x"},
+		{"-label", "a=b,c"},
+	} {
+		p := &Parser{ErrOutput: &strings.Builder{}}
+		if err := p.Parse(append([]string{"prog"}, args...), &opts); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", args)
+		}
+	}
+	func() {
+		defer func() {
+			if r := recover(); r == nil || !strings.Contains(r.(error).Error(), "append-to-default") {
+				t.Errorf("recovered %v, want the append-to-default panic", r)
+			}
+		}()
+		var opts struct {
+			Port int `name:"port" append-to-default:"true"`
+		}
+		_ = (&Parser{}).Parse([]string{"prog"}, &opts)
+	}()
+}
+
+func TestSliceFlagsHelp(t *testing.T) {
+	var opts struct {
+		Tags   []string          `name:"tag" default:"a,b" usage:"tags"`
+		Labels map[string]string `name:"label" usage:"labels"`
+	}
+	out := helpOutput(t, &Parser{}, []string{"prog", "-h"}, &opts)
+	checkHelp(t, out, `Usage: prog [OPTION]...
+
+Options:
+  -tag      strings (default: a,b)
+            tags
+  -label    key=string
+            labels
+  -h/-help
+            show this help
+`)
+
+	opts.Tags = []string{"x", "y"}
+	opts.Labels = map[string]string{"b": "2", "a": "1"}
+	args, err := MarshalArgs(&opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"-tag=x,y", "-label=a=1,b=2"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("MarshalArgs = %q, want %q", args, want)
+	}
+}
+
+func TestMapFlags(t *testing.T) {
+	type options struct {
+		Timeouts map[string]time.Duration `name:"timeout" default:"api=5s,db=1m"`
+		Limits   map[string]int           `name:"limit"`
+		Features map[string]bool          `name:"feature"`
+		Quotas   map[string]Size          `name:"quota"`
+	}
+	var opts options
+	args := []string{"prog", "-timeout", "api=2s", "-timeout", "cache=100ms", "-limit", "cpu=4", "-limit", "mem=2048", "-feature", "x=true,y=false", "-quota", "home=10GiB"}
+	if err := (&Parser{}).Parse(args, &opts); err != nil {
+		t.Fatal(err)
+	}
+	want := options{
+		Timeouts: map[string]time.Duration{"api": 2 * time.Second, "cache": 100 * time.Millisecond},
+		Limits:   map[string]int{"cpu": 4, "mem": 2048},
+		Features: map[string]bool{"x": true, "y": false},
+		Quotas:   map[string]Size{"home": 10 << 30},
+	}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("got %+v, want %+v", opts, want)
+	}
+	if err := (&Parser{}).Parse([]string{"prog"}, &opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]time.Duration{"api": 5 * time.Second, "db": time.Minute}; !reflect.DeepEqual(opts.Timeouts, want) {
+		t.Errorf("default = %v, want %v", opts.Timeouts, want)
+	}
+
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-limit", "cpu=4,mem=lots"}, `invalid value "cpu=4,mem=lots" for flag -limit: invalid value "lots" of key "mem": strconv.ParseInt: parsing "lots": invalid syntax`},
+		{[]string{"-timeout", "api"}, `invalid value "api" for flag -timeout: "api" isn't a key=value pair`},
+	} {
+		p := &Parser{ErrOutput: &strings.Builder{}}
+		if err := p.Parse(append([]string{"prog"}, c.args...), &opts); err == nil || err.Error() != c.err {
+			t.Errorf("Parse(%q) = %v, want %s", c.args, err, c.err)
+		}
+	}
+
+	var help struct {
+		Timeouts map[string]time.Duration `name:"timeout" default:"db=1m,api=5s" usage:"timeouts"`
+	}
+	checkHelp(t, helpOutput(t, &Parser{}, []string{"prog", "-h"}, &help), `Usage: prog [OPTION]
+
+Options:
+  -timeout  key=duration (default: api=5s,db=1m0s)
+            timeouts
+  -h/-help
+            show this help
+`)
+	help.Timeouts = map[string]time.Duration{"b-c": time.Second, "b": time.Minute}
+	if got, err := MarshalArgs(&help); err != nil || !reflect.DeepEqual(got, []string{"-timeout=b=1m0s,b-c=1s"}) {
+		t.Errorf("MarshalArgs = %q, %v", got, err)
+	}
+}
+
+func TestSeparatorTags(t *testing.T) {
+	type options struct {
+		Labels map[string]string `name:"label" kvsep:":" default:"app:a=b" usage:"labels"`
+		Limits map[string]int    `name:"limit" sep:";" kvsep:":"`
+		Paths  []string          `name:"path" sep:":"`
+	}
+	var opts options
+	args := []string{"prog", "-label", "x:y=z,k:v", "-limit", "cpu:4;mem:2048", "-path", "/bin:/usr/bin"}
+	if err := (&Parser{}).Parse(args, &opts); err != nil {
+		t.Fatal(err)
+	}
+	want := options{
+		Labels: map[string]string{"x": "y=z", "k": "v"},
+		Limits: map[string]int{"cpu": 4, "mem": 2048},
+		Paths:  []string{"/bin", "/usr/bin"},
+	}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("got %+v, want %+v", opts, want)
+	}
+	p := &Parser{ErrOutput: &strings.Builder{}}
+	if err := p.Parse([]string{"prog", "-label", "a=b"}, &opts); err == nil || !strings.HasSuffix(err.Error(), `"a=b" isn't a key:value pair`) {
+		t.Errorf("Parse(-label a=b) = %v", err)
+	}
+
+	checkHelp(t, helpOutput(t, &Parser{}, []string{"prog", "-h"}, &options{}), `Usage: prog [OPTION]...
+
+Options:
+  -label    key:string (default: app:a=b)
+            labels
+  -limit    key:int
+  -path     strings
+  -h/-help
+            show this help
+`)
+	opts = options{Limits: map[string]int{"b": 2, "a": 1}, Paths: []string{"/x", "/y"}}
+	if got, err := MarshalArgs(&opts); err != nil || !reflect.DeepEqual(got, []string{"-label=", "-limit=a:1;b:2", "-path=/x:/y"}) {
+		t.Errorf("MarshalArgs = %q, %v", got, err)
+	}
+
+	for _, c := range []struct {
+		ptr interface{}
+		err string
+	}{
+		{&struct {
+			M map[string]string `sep:""`
+		}{}, "empty sep tag of field M"},
+		{&struct {
+			M map[string]string `kvsep:","`
+		}{}, `kvsep tag of field M is the same as the separator of elements ","`},
+		{&struct {
+			M map[string]string `sep:"="`
+		}{}, `sep tag of field M is the same as the separator of keys and values "="`},
+		{&struct {
+			S []string `kvsep:":"`
+		}{}, "kvsep tag of field S, which isn't a map"},
+		{&struct {
+			N int `sep:";"`
+		}{}, "sep, kvsep or env-sep tag of field N, which isn't a slice or map"},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(r.(error).Error(), c.err) {
+					t.Errorf("recovered %v, want %s", r, c.err)
+				}
+			}()
+			_ = (&Parser{}).Parse([]string{"prog"}, c.ptr)
+		}()
+	}
+}
+
+func TestEnvSeparator(t *testing.T) {
+	type options struct {
+		Dirs  []string        `name:"dir" env:"DIRS" default:"/usr/lib"`
+		Paths []string        `name:"path" env:"PATHS" env-sep:";"`
+		Waits []time.Duration `name:"wait" env:"WAITS" env-sep:";" sep:"+"`
+		Tags  map[string]int  `name:"tag" env:"TAGS"`
+	}
+	pathList := string(os.PathListSeparator)
+	env := map[string]string{
+		"DIRS":  "/a" + pathList + "/b,c" + pathList,
+		"PATHS": `C:\Program Files;;D:\bin;`,
+		"WAITS": "1s;2m",
+		"TAGS":  "a=1,b=2",
+	}
+	p := &Parser{LookupEnv: func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}}
+	var opts options
+	if err := p.Parse([]string{"prog"}, &opts); err != nil {
+		t.Fatal(err)
+	}
+	want := options{
+		Dirs:  []string{"/a", "/b,c"},
+		Paths: []string{`C:\Program Files`, `D:\bin`},
+		Waits: []time.Duration{time.Second, 2 * time.Minute},
+		Tags:  map[string]int{"a": 1, "b": 2},
+	}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("got %+v, want %+v", opts, want)
+	}
+	// the command line keeps the sep tag
+	if err := p.Parse([]string{"prog", "-path", "x;y", "-wait", "1s+2s"}, &opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"x;y"}; !reflect.DeepEqual(opts.Paths, want) {
+		t.Errorf("Paths = %q, want %q", opts.Paths, want)
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second}; !reflect.DeepEqual(opts.Waits, want) {
+		t.Errorf("Waits = %v, want %v", opts.Waits, want)
+	}
+
+	env["WAITS"] = "1s;soon"
+	p.ErrOutput = &strings.Builder{}
+	if err := p.Parse([]string{"prog"}, &opts); err == nil || !strings.Contains(err.Error(), `"1s;soon" for $WAITS`) {
+		t.Errorf("Parse with an invalid element = %v", err)
+	}
+
+	for _, c := range []struct {
+		ptr interface{}
+		err string
+	}{
+		{&struct {
+			S []string `env-sep:""`
+		}{}, "empty env-sep tag of field S"},
+		{&struct {
+			M map[string]string `env-sep:";"`
+		}{}, "env-sep tag of field M, which isn't a slice"},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(r.(error).Error(), c.err) {
+					t.Errorf("recovered %v, want %s", r, c.err)
+				}
+			}()
+			_ = (&Parser{}).Parse([]string{"prog"}, c.ptr)
+		}()
+	}
+}
+
+func TestUniqueTag(t *testing.T) {
+	type options struct {
+		Tags    []string        `name:"tag" unique:"true"`
+		Ports   []int           `name:"port" unique:"true" default:"80" append-to-default:"true"`
+		Waits   []time.Duration `name:"wait" unique:"true" env:"WAITS" env-sep:";"`
+		Hosts   []string        `name:"host" unique:"error" default:"a" append-to-default:"true"`
+		Repeats []string        `name:"repeat"`
+	}
+	env := map[string]string{"WAITS": "1s;1000ms;2s"}
+	p := &Parser{ErrOutput: &strings.Builder{}, LookupEnv: func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}}
+	// the field isn't a default
+	opts := options{Tags: []string{"dev"}}
+	args := []string{"prog", "-tag", "prod", "-tag", "dev,prod", "-tag", "prod", "-port", "443,80", "-port", "0443", "-host", "b", "-repeat", "x", "-repeat", "x"}
+	if err := p.Parse(args, &opts); err != nil {
+		t.Fatal(err)
+	}
+	want := options{
+		Tags:    []string{"prod", "dev"},
+		Ports:   []int{80, 443},
+		Waits:   []time.Duration{time.Second, 2 * time.Second},
+		Hosts:   []string{"a", "b"},
+		Repeats: []string{"x", "x"},
+	}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("got %+v, want %+v", opts, want)
+	}
+
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-host", "b,b"}, `invalid value "b,b" for flag -host: duplicate value "b"`},
+		{[]string{"-host", "b", "-host", "c", "-host", "b"}, `invalid value "b" for flag -host: duplicate value "b"`},
+		{[]string{"-host", "a"}, `invalid value "a" for flag -host: duplicate value "a"`},
+	} {
+		var opts options
+		if err := p.Parse(append([]string{"prog"}, c.args...), &opts); err == nil || err.Error() != c.err {
+			t.Errorf("Parse(%q) = %v, want %s", c.args, err, c.err)
+		}
+	}
+
+	for _, c := range []struct {
+		ptr interface{}
+		err string
+	}{
+		{&struct {
+			S []string `unique:"maybe"`
+		}{}, `invalid unique tag "maybe" of field S`},
+		{&struct {
+			M map[string]string `unique:"true"`
+		}{}, "unique tag of field M, which isn't a slice"},
+		{&struct {
+			S []string `unique:"error" default:"a,a"`
+		}{}, `invalid default "a,a" of field S: duplicate value "a"`},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(r.(error).Error(), c.err) {
+					t.Errorf("recovered %v, want %s", r, c.err)
+				}
+			}()
+			_ = (&Parser{}).Parse([]string{"prog"}, c.ptr)
+		}()
+	}
+}
@@ -0,0 +1,365 @@
+package sflag
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// Source is where the value of a flag comes from.
+type Source int
+
+const (
+	// NoSource means no source supplied a value, the field is left as is.
+	NoSource Source = iota
+	FlagSource
+	EnvSource
+	ConfigSource
+	DefaultSource
+)
+
+var sourceNames = []string{"none", "flag", "env", "config", "default"}
+
+func (s Source) String() string {
+	if s < 0 || int(s) >= len(sourceNames) {
+		return "unknown"
+	}
+	return sourceNames[s]
+}
+
+// defaultPrecedence is the order of sources when Parser.Precedence is empty.
+var defaultPrecedence = []Source{FlagSource, EnvSource, ConfigSource, DefaultSource}
+
+func (p *Parser) precedence() []Source {
+	if len(p.Precedence) == 0 {
+		return defaultPrecedence
+	}
+	return p.Precedence
+}
+
+// typeValues are the flag.Value constructors of the types of other packages
+// which don't implement flag.Value, such as slog.Level.
+var typeValues = make(map[reflect.Type]func(val reflect.Value) flag.Value)
+
+// newFlagValue returns the flag.Value setting the addressable val, false if
+// the type isn't supported.
+func newFlagValue(val reflect.Value) (flag.Value, bool) {
+	// checked first, as the types of flag.Value may be of the common kinds
+	if reflect.PtrTo(val.Type()).Implements(flagValueType) {
+		return val.Addr().Interface().(flag.Value), true
+	}
+	if fn, ok := typeValues[val.Type()]; ok {
+		return fn(val), true
+	}
+	switch val.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64,
+		reflect.String:
+		return &commonflagValue{val}, true
+	}
+	switch val.Type() {
+	case timeType, bytesType, tcpAddrType, udpAddrType:
+		return &commonflagValue{val}, true
+	}
+	if mv, ok := newMultiValue(val); ok {
+		return mv, true
+	}
+	return nil, false
+}
+
+// sourcedValue is registered to the flag.FlagSet in place of the value of a
+// field. It records the values supplied by each source, the field is set by
+// apply from the first source of the precedence once all of them are known.
+type sourcedValue struct {
+	names []string
+	field reflect.Value
+	value flag.Value
+	// orig is a copy of the field before parsing, restored before the
+	// values of a source are applied.
+	orig   reflect.Value
+	values map[Source][]string
+	// results are copies of the field set to the values of each source by
+	// check, which apply restores for the values other than slices and
+	// maps, so that the Set methods of flag.Value fields run once a value.
+	results map[Source]reflect.Value
+	// configFile is the file of the config values, empty if they aren't
+	// loaded from a file.
+	configFile string
+	// envName is the name of the environment variable found for the flag,
+	// which may differ from the env tag in case.
+	envName string
+	// deprecated is the message of the deprecated tag, warning is written to
+	// warnOut the first time a deprecated flag is given.
+	deprecated string
+	warning    string
+	warnOut    io.Writer
+	warned     bool
+	// prompt reads the value of a secret flag given as one of promptValues,
+	// it's nil for other flags.
+	prompt func() (string, error)
+	// readStdin reads the value of a flag tagged with stdin given as "-",
+	// it's nil for other flags.
+	readStdin func() (string, error)
+	// expand expands the variables of the values of the command line, it's
+	// nil if they aren't expanded.
+	expand func(string) (string, error)
+	// fromFile is true if the values of the command line are the paths of
+	// files holding the values.
+	fromFile bool
+	// appendDefault adds the values of a slice or map flag to its default
+	// instead of replacing it.
+	appendDefault bool
+	// seps are the separators of the values of a slice or map flag.
+	seps separators
+	// strictBool takes the values of a bool flag as strconv.ParseBool does,
+	// but the ones of the config and the default.
+	strictBool bool
+
+	precedence []Source
+	applied    bool
+	source     Source
+}
+
+func newSourcedValue(field reflect.Value, names []string, seps separators) (*sourcedValue, bool) {
+	value, ok := seps.newValue(field)
+	if !ok {
+		return nil, false
+	}
+	orig := reflect.New(field.Type()).Elem()
+	orig.Set(field)
+	return &sourcedValue{
+		names:   names,
+		field:   field,
+		value:   value,
+		orig:    orig,
+		values:  make(map[Source][]string),
+		results: make(map[Source]reflect.Value),
+		seps:    seps,
+	}, true
+}
+
+// check sets the values of src to a copy of the original field, reporting
+// the first invalid one. The values other than slices and maps are set on
+// top of the ones recorded for src, and the copy is returned.
+func (v *sourcedValue) check(src Source, values []string) (reflect.Value, string, error) {
+	scratch := reflect.New(v.field.Type()).Elem()
+	scratch.Set(v.orig)
+	if result, ok := v.results[src]; ok {
+		scratch.Set(result)
+	}
+	fval, _ := v.seps.of(src).newValue(scratch)
+	if mv, ok := fval.(multiValue); ok && v.seps.unique != uniqueNever {
+		// the values add to the ones recorded, and maybe the default,
+		// which the unique tag compares them with
+		mv.reset(v.appendDefault)
+		if v.appendDefault && src != DefaultSource {
+			mv.setSeparators(v.seps)
+			for _, s := range v.values[DefaultSource] {
+				_ = mv.Set(s)
+			}
+			mv.setSeparators(v.seps.of(src))
+		}
+		for _, s := range v.values[src] {
+			_ = mv.Set(s)
+		}
+	}
+	for _, s := range values {
+		if v.strictBool && src != ConfigSource && src != DefaultSource {
+			if _, err := strconv.ParseBool(s); err != nil {
+				return scratch, s, err
+			}
+		}
+		if err := fval.Set(s); err != nil {
+			return scratch, s, err
+		}
+	}
+	return scratch, "", nil
+}
+
+// record adds the values of src if all of them are valid.
+func (v *sourcedValue) record(src Source, values ...string) (string, error) {
+	result, s, err := v.check(src, values)
+	if err != nil {
+		return s, err
+	}
+	v.values[src] = append(v.values[src], values...)
+	if _, ok := v.value.(multiValue); !ok {
+		v.results[src] = result
+	}
+	return "", nil
+}
+
+var _ flag.Getter = (*sourcedValue)(nil)
+
+// Get returns the value of the field, as the flag.Getter of the FlagSet.
+func (v *sourcedValue) Get() interface{} {
+	return v.field.Interface()
+}
+
+func (v *sourcedValue) String() string {
+	if v.value == nil {
+		return ""
+	}
+	return v.value.String()
+}
+
+// Set records a value of the command line, the field is updated right away
+// if the sources were applied already.
+func (v *sourcedValue) Set(s string) error {
+	var err error
+	switch {
+	case v.readStdin != nil && s == "-":
+		if s, err = v.readStdin(); err != nil {
+			return err
+		}
+	case v.prompt != nil && hasName(promptValues, s):
+		if s, err = v.prompt(); err != nil {
+			return err
+		}
+	case v.expand != nil:
+		if s, err = v.expand(s); err != nil {
+			return err
+		}
+	}
+	if v.fromFile {
+		if s, err = readValueFile(s); err != nil {
+			return err
+		}
+	}
+	if _, err := v.record(FlagSource, s); err != nil {
+		return err
+	}
+	if v.warning != "" && !v.warned {
+		v.warned = true
+		fprintln(v.warnOut, v.warning)
+	}
+	if v.applied {
+		v.apply(v.precedence)
+	}
+	return nil
+}
+
+func (v *sourcedValue) IsBoolFlag() bool {
+	bf, ok := v.value.(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
+
+// apply sets the field to the values of the first source in precedence which
+// supplied any. Sources missing from precedence are ignored. The values of a
+// source replace the default of a slice or map flag as a whole, unless
+// appendDefault is set.
+func (v *sourcedValue) apply(precedence []Source) {
+	v.precedence = precedence
+	v.applied = true
+	v.field.Set(v.orig)
+	if mv, ok := v.value.(multiValue); ok {
+		mv.reset(v.appendDefault)
+	}
+	v.source = NoSource
+	for _, src := range precedence {
+		values, ok := v.values[src]
+		if !ok {
+			continue
+		}
+		if v.appendDefault && src != DefaultSource {
+			v.set(DefaultSource, v.values[DefaultSource])
+		}
+		v.set(src, values)
+		v.source = src
+		return
+	}
+}
+
+// set sets the values of src to the field, which are checked when recorded.
+// Values other than slices and maps are set to the result of the check.
+func (v *sourcedValue) set(src Source, values []string) {
+	mv, ok := v.value.(multiValue)
+	if !ok {
+		v.field.Set(v.results[src])
+		return
+	}
+	mv.setSeparators(v.seps.of(src))
+	defer mv.setSeparators(v.seps)
+	for _, s := range values {
+		_ = v.value.Set(s)
+	}
+}
+
+// provided reports whether a source other than the default supplied the
+// value.
+func (v *sourcedValue) provided() bool {
+	return v.source != NoSource && v.source != DefaultSource
+}
+
+// aliasValue is registered for an alias of a flag, it warns that the alias
+// is deprecated the first time it's given.
+type aliasValue struct {
+	*sourcedValue
+	warning string
+	out     io.Writer
+	warned  bool
+}
+
+func (v *aliasValue) Set(s string) error {
+	if !v.warned {
+		v.warned = true
+		fprintln(v.out, v.warning)
+	}
+	return v.sourcedValue.Set(s)
+}
+
+// SetInfo holds the sources of the flags of a struct by the first name of
+// them. A field of the type, embedded or not, is filled by parsing the struct.
+// Flags no source supplied a value to are left out.
+type SetInfo map[string]Source
+
+var setInfoType = reflect.TypeOf(SetInfo(nil))
+
+// Source returns the source of the flag of name, NoSource if it isn't set.
+func (s SetInfo) Source(name string) Source {
+	return s[name]
+}
+
+// Changed reports whether the flag of name is given on the command line.
+func (s SetInfo) Changed(name string) bool {
+	return s[name] == FlagSource
+}
+
+// sources returns the sources of the flags set by the parse, keyed by prefix
+// and the first name of the flags.
+func (c *commandFlags) sources(prefix string) SetInfo {
+	info := make(SetInfo)
+	for name, src := range c.baseSources {
+		info[prefix+name] = src
+	}
+	for _, f := range c.flags {
+		// the flags of Parser.PreParse and Parser.Adopt have no sources recorded
+		if f.value != nil && f.value.source != NoSource {
+			info[prefix+f.value.names[0]] = f.value.source
+		}
+	}
+	return info
+}
+
+// fillSetInfo sets the SetInfo field of the struct, if any.
+func (c *commandFlags) fillSetInfo() {
+	if c.setInfo.IsValid() {
+		c.setInfo.Set(reflect.ValueOf(c.sources("")))
+	}
+}
+
+// SetFields returns the sources of the flags of the last parse. Flags of the
+// command parsed by ParseCommandFlags or RunCommand are prefixed by the
+// command name and "-", the same as their config keys.
+func (p *Parser) SetFields() SetInfo {
+	info := make(SetInfo)
+	for _, flags := range p.lastParsed() {
+		for name, src := range flags.sources(flags.configPrefix) {
+			info[name] = src
+		}
+	}
+	return info
+}
@@ -0,0 +1,56 @@
+package sflag
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// stdinSource is the stdin of a parse, which only one flag may read.
+type stdinSource struct {
+	r io.Reader
+	// flag is the name of the flag which read r.
+	flag string
+}
+
+func (p *Parser) stdin() io.Reader {
+	if p.Stdin == nil {
+		return os.Stdin
+	}
+	return p.Stdin
+}
+
+// stdinFunc returns the function reading the value of the flag of name from
+// stdin, less a trailing newline.
+func (c *commandFlags) stdinFunc(name string) func() (string, error) {
+	return func() (string, error) {
+		if c.stdin.flag != "" {
+			return "", newErrorf("flag -%s can't read stdin, as -%s has read it", name, c.stdin.flag)
+		}
+		c.stdin.flag = name
+		data, err := ioutil.ReadAll(c.stdin.r)
+		if err != nil {
+			return "", newErrorf("read stdin for -%s: %v", name, err)
+		}
+		return trimNewline(string(data)), nil
+	}
+}
+
+// readValueFile returns the content of the file of a flag tagged with
+// from-file, less a trailing newline.
+func readValueFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return trimNewline(string(data)), nil
+}
+
+// trimNewline trims a trailing "\n" or "\r\n" of s.
+func trimNewline(s string) string {
+	if !strings.HasSuffix(s, "\n") {
+		return s
+	}
+	return strings.TrimSuffix(s[:len(s)-1], "\r")
+}
@@ -0,0 +1,87 @@
+package sflag
+
+import (
+	"strings"
+	"testing"
+)
+
+type stdinOpts struct {
+	Config string `name:"config" stdin:"true" usage:"config text"`
+	Key    []byte `name:"key" stdin:"true"`
+	Name   string `name:"name"`
+}
+
+func TestStdinFlags(t *testing.T) {
+	for _, c := range []struct {
+		args  []string
+		stdin string
+		want  stdinOpts
+		err   string
+	}{
+		{[]string{"-config", "-"}, "a: 1\nb: 2\n", stdinOpts{Config: "a: 1\nb: 2"}, ""},
+		{[]string{"-key=-"}, "s3cret\r\n", stdinOpts{Key: []byte("s3cret")}, ""},
+		{[]string{"-key=-", "-name=-"}, "k\n\n", stdinOpts{Key: []byte("k\n"), Name: "-"}, ""},
+		{[]string{"-config=inline"}, "unread", stdinOpts{Config: "inline"}, ""},
+		{[]string{"-config=-", "-key=-"}, "x", stdinOpts{}, "flag -key can't read stdin, as -config has read it"},
+	} {
+		var flags stdinOpts
+		p := &Parser{Stdin: strings.NewReader(c.stdin), ErrOutput: &strings.Builder{}}
+		err := p.Parse(append([]string{"prog"}, c.args...), &flags)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("Parse %q = %v, want %q", c.args, err, c.err)
+			}
+			continue
+		}
+		if err != nil || flags.Config != c.want.Config || string(flags.Key) != string(c.want.Key) || flags.Name != c.want.Name {
+			t.Errorf("Parse %q = %+v, %v, want %+v", c.args, flags, err, c.want)
+		}
+	}
+
+	var flags stdinOpts
+	help := helpOutput(t, &Parser{}, []string{"prog", "-h"}, &flags)
+	if !strings.Contains(help, "  -config   string (- reads stdin)\n") || !strings.Contains(help, "  -key      string (- reads stdin)\n") {
+		t.Errorf("help doesn't mention stdin:\n%s", help)
+	}
+}
+
+func TestFromFile(t *testing.T) {
+	type options struct {
+		Token string `name:"token" from-file:"true" env:"TOKEN_FILE" secret:"true"`
+	}
+	path := writeFile(t, "token", "s3cret\n")
+	for _, c := range []struct {
+		args []string
+		env  string
+		want string
+		err  string
+	}{
+		{[]string{"-token", path}, "", "s3cret", ""},
+		{nil, path, "s3cret", ""},
+		{[]string{"-token", path + ".missing"}, "", "", "invalid value \"" + path + ".missing\" for flag -token: open " + path + ".missing"},
+		{nil, path + ".missing", "", "invalid value \"" + path + ".missing\" for $TOKEN_FILE (flag -token): open " + path + ".missing"},
+	} {
+		var flags options
+		p := &Parser{ErrOutput: &strings.Builder{}, LookupEnv: func(key string) (string, bool) {
+			return c.env, c.env != ""
+		}}
+		err := p.Parse(append([]string{"prog"}, c.args...), &flags)
+		if c.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), c.err) {
+				t.Errorf("Parse %q = %v, want %q", c.args, err, c.err)
+			}
+			continue
+		}
+		if err != nil || flags.Token != c.want {
+			t.Errorf("Parse %q = %q, %v, want %q", c.args, flags.Token, err, c.want)
+		}
+	}
+}
+
+func TestTrimNewline(t *testing.T) {
+	for s, want := range map[string]string{"a": "a", "a\n": "a", "a\r\n": "a", "a\n\n": "a\n", "a\r": "a\r"} {
+		if got := trimNewline(s); got != want {
+			t.Errorf("trimNewline(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
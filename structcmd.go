@@ -0,0 +1,100 @@
+package sflag
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// StructCommand is the type of a field tagged with cmd, Run is called with
+// the arguments of the command as Command.Run is, once they are parsed into
+// the fields.
+type StructCommand interface {
+	Run(args []string) error
+}
+
+// StructCommands returns the commands of the fields of the struct rootPtr
+// points to tagged with cmd, such as
+//
+//	Serve ServeCmd `cmd:"serve" usage:"run the server"`
+//
+// The fields of the type of a command are its flags, and it's run by its Run
+// method. A type with cmd fields of its own dispatches to them instead, its
+// other fields being the flags given before the nested command. The usage,
+// group and hidden tags are the fields of Command of the same names. A nil
+// pointer field is allocated. The commands may be mixed with other ones
+// passed to RunCommand, along with rootPtr as the global flags.
+func StructCommands(rootPtr interface{}) []Command {
+	refv := reflect.ValueOf(rootPtr)
+	if refv.Kind() != reflect.Ptr || refv.Elem().Kind() != reflect.Struct {
+		panic("expect pointer of struct")
+	}
+	refv = refv.Elem()
+	reft := refv.Type()
+	var commands []Command
+	for i := 0; i < reft.NumField(); i++ {
+		ftyp := reft.Field(i)
+		name, ok := ftyp.Tag.Lookup("cmd")
+		if !ok {
+			continue
+		}
+		switch {
+		case name == "":
+			panic(newErrorf("empty cmd tag of field %s", ftyp.Name))
+		case !isExported(ftyp.Name):
+			panic(newErrorf("cmd field %s is unexported", ftyp.Name))
+		}
+		fval := refv.Field(i)
+		if fval.Kind() == reflect.Ptr {
+			if fval.IsNil() {
+				fval.Set(reflect.New(fval.Type().Elem()))
+			}
+			fval = fval.Elem()
+		}
+		if fval.Kind() != reflect.Struct {
+			panic(newErrorf("cmd field %s isn't a struct or pointer of struct", ftyp.Name))
+		}
+		ptr := fval.Addr().Interface()
+		hidden, _ := strconv.ParseBool(ftyp.Tag.Get("hidden"))
+		cmd := Command{
+			Name:   name,
+			Usage:  ftyp.Tag.Get("usage"),
+			Group:  ftyp.Tag.Get("group"),
+			Hidden: hidden,
+		}
+		if nested := StructCommands(ptr); len(nested) > 0 {
+			cmd.nestedFlags = ptr
+			cmd.commands = nested
+		} else {
+			sc, ok := ptr.(StructCommand)
+			if !ok {
+				panic(newErrorf("type %s of cmd field %s has no Run(args []string) error method", fval.Type(), ftyp.Name))
+			}
+			cmd.Flags = ptr
+			cmd.RunE = sc.Run
+		}
+		commands = append(commands, cmd)
+	}
+	return commands
+}
+
+// Subcommands returns the nested commands of a command of StructCommands whose
+// type has cmd fields, and the pointer to the struct holding the flags given
+// before them. Both are nil for other commands.
+func (c Command) Subcommands() (commands []Command, flags interface{}) {
+	return c.commands, c.nestedFlags
+}
+
+// RunStruct runs the command of the cmd fields of the struct rootPtr points
+// to chosen by args, as RunCommandE does with StructCommands, the other
+// fields being the global flags.
+func (p *Parser) RunStruct(args []string, rootPtr interface{}) error {
+	commands := StructCommands(rootPtr)
+	if len(commands) == 0 {
+		panic(newErrorf("no cmd fields in %T", rootPtr))
+	}
+	return p.RunCommandE(args, rootPtr, commands...)
+}
+
+func RunStruct(args []string, rootPtr interface{}) error {
+	return (&Parser{}).RunStruct(args, rootPtr)
+}
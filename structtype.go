@@ -0,0 +1,516 @@
+package sflag
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+type fieldKind int
+
+const (
+	setInfoField fieldKind = iota
+	flagField
+	nonFlagFieldKind
+	nonFlagSliceKind
+	passthroughKind
+)
+
+// fieldDesc is a field of a struct with its tags parsed.
+type fieldDesc struct {
+	// embed is the indexes of the embedded structs holding the field, index
+	// is the index of the field in the innermost one.
+	embed []int
+	index int
+	kind  fieldKind
+	field string
+	typ   reflect.Type
+
+	// name is the display name of a non-flag field, names are the names of
+	// a flag.
+	name  string
+	names []string
+	// aliases are the hidden names of a flag, which are deprecated.
+	aliases []string
+	usage   string
+	env     string
+	def     string
+
+	// ignoreEmptyEnv takes an empty environment variable as unset.
+	ignoreEmptyEnv bool
+
+	required   bool
+	hidden     bool
+	secret     bool
+	stdin      bool
+	fromFile   bool
+	expand     expandMode
+	isConfig   bool
+	deprecated string
+	requires   []string
+	validate   []string
+	oneof      []string
+	// defaultMethod is the name of the method computing the default, empty
+	// if there isn't one.
+	defaultMethod string
+	// appendDefault adds the values of a slice or map flag to its default.
+	appendDefault bool
+	// seps are the separators of the values of a slice or map flag.
+	seps separators
+	// resolve resolves the service name of the port of a HostPort flag.
+	resolve bool
+	// implicit is true if the flag is named after the field, without a name
+	// tag.
+	implicit bool
+
+	min, max        int
+	afterTerminator bool
+	// at is the number of non-flag fields before the non-flag slice.
+	at int
+	// pos is the pos tag of a non-flag, the 1-based position of a field or
+	// posRest for the non-flag slice, zero if there is none.
+	pos int
+}
+
+// posRest is the pos tag of the non-flag slice, "rest".
+const posRest = -1
+
+// posTag returns the pos tag of the non-flag field, which is a position from
+// 1, or rest for the non-flag slice.
+func posTag(ftyp reflect.StructField, slice bool) int {
+	s, ok := ftyp.Tag.Lookup("pos")
+	if !ok {
+		return 0
+	}
+	if slice {
+		if s != "rest" {
+			panic(newErrorf(`invalid pos tag of non-flag slice %s: %q, expect "rest"`, ftyp.Name, s))
+		}
+		return posRest
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		panic(newErrorf("invalid pos tag of non-flag field %s: %q, expect a position from 1", ftyp.Name, s))
+	}
+	return n
+}
+
+// orderPositionals orders the non-flag fields of desc by their pos tags, the
+// non-flag slice last, which either all or none of them have. The positions
+// must be 1 to the number of the fields.
+func (desc *structDesc) orderPositionals() {
+	var slots []int
+	var tagged, untagged []string
+	for i, d := range desc.fields {
+		if d.kind != nonFlagFieldKind && d.kind != nonFlagSliceKind {
+			continue
+		}
+		slots = append(slots, i)
+		if d.pos != 0 {
+			tagged = append(tagged, d.field)
+		} else {
+			untagged = append(untagged, d.field)
+		}
+	}
+	if len(tagged) == 0 {
+		return
+	}
+	if len(untagged) > 0 {
+		panic(newErrorf("non-flag fields %s have pos tags but %s don't, tag all of them or none as the order of declaration is used without", strings.Join(tagged, ", "), strings.Join(untagged, ", ")))
+	}
+	ordered := make([]fieldDesc, len(slots))
+	for i, slot := range slots {
+		ordered[i] = desc.fields[slot]
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[j].pos == posRest || ordered[i].pos != posRest && ordered[i].pos < ordered[j].pos
+	})
+	n := 0
+	for i, d := range ordered {
+		switch {
+		case d.pos == posRest:
+			ordered[i].at = n
+			continue
+		case i > 0 && ordered[i-1].pos == d.pos:
+			panic(newErrorf("non-flag fields %s and %s have the same pos tag %d", ordered[i-1].field, d.field, d.pos))
+		case d.pos != n+1:
+			panic(newErrorf("no non-flag field has the pos tag %d, the positions must follow each other from 1", n+1))
+		case d.def == "" && n > 0 && ordered[i-1].def != "":
+			panic(newErrorf("required non-flag field %s follows optional ones", d.field))
+		}
+		n++
+	}
+	for i, slot := range slots {
+		desc.fields[slot] = ordered[i]
+	}
+}
+
+// typeName returns the type name of the flag in help.
+func (d *fieldDesc) typeName() string {
+	if d.seps.percent {
+		return "percent"
+	}
+	if d.typ.Kind() == reflect.Map && d.seps.kv != "" {
+		return mapTypeName(d.typ, d.seps.kv)
+	}
+	return typeName(d.typ)
+}
+
+// structDesc is the fields of a struct type which are flags, non-flags or
+// SetInfo, in the order of declaration.
+type structDesc struct {
+	fields []fieldDesc
+}
+
+// structDescs caches the structDesc of struct types by structKey, as the tags
+// are parsed once for all the parses of a type.
+var structDescs sync.Map
+
+// structKey is a struct type compiled with or without Parser.ExplicitFlags.
+type structKey struct {
+	typ      reflect.Type
+	explicit bool
+}
+
+// describeStruct returns the structDesc of the struct type t, it panics if
+// the tags of t are invalid. The fields without a name tag aren't flags if
+// explicit is true, so that other flags can't take or require their names.
+func describeStruct(t reflect.Type, explicit bool) *structDesc {
+	key := structKey{t, explicit}
+	if desc, ok := structDescs.Load(key); ok {
+		return desc.(*structDesc)
+	}
+	desc := compileStruct(t, explicit)
+	structDescs.Store(key, desc)
+	return desc
+}
+
+// validFlagNames reports whether names are usable as flag names, there must
+// be at least one, and a name mustn't contain spaces or '=' or be made of
+// dashes only.
+func validFlagNames(names []string) bool {
+	if len(names) == 0 {
+		return false
+	}
+	for _, name := range names {
+		if strings.Trim(name, "-") == "" || strings.ContainsRune(name, '=') || strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// embeddedStruct returns the struct type embedded by the field, false if the
+// field isn't an embedded struct or pointer of struct. Embedded types
+// supported as flag values aren't flattened.
+func embeddedStruct(ftyp reflect.StructField) (reflect.Type, bool) {
+	if !ftyp.Anonymous {
+		return nil, false
+	}
+	t := ftyp.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, false
+	}
+	if _, ok := newFlagValue(reflect.New(ftyp.Type).Elem()); ok {
+		return nil, false
+	}
+	return t, true
+}
+
+func hasSeparatorTags(ftyp reflect.StructField) bool {
+	_, sep := ftyp.Tag.Lookup("sep")
+	_, kvsep := ftyp.Tag.Lookup("kvsep")
+	_, envsep := ftyp.Tag.Lookup("env-sep")
+	return sep || kvsep || envsep
+}
+
+// separatorTags returns the separators of the sep, kvsep and env-sep tags of
+// the slice or map field, it panics if they are empty or the same. The
+// environment values of slices default to the path list separator.
+func separatorTags(ftyp reflect.StructField, field string) separators {
+	var seps separators
+	var ok bool
+	if seps.elem, ok = ftyp.Tag.Lookup("sep"); ok && seps.elem == "" {
+		panic(newErrorf("empty sep tag of field %s", field))
+	}
+	if seps.env, ok = ftyp.Tag.Lookup("env-sep"); ok {
+		switch {
+		case ftyp.Type.Kind() != reflect.Slice:
+			panic(newErrorf("env-sep tag of field %s, which isn't a slice", field))
+		case seps.env == "":
+			panic(newErrorf("empty env-sep tag of field %s", field))
+		}
+	} else if ftyp.Type.Kind() == reflect.Slice {
+		seps.env = string(os.PathListSeparator)
+	}
+	if seps.kv, ok = ftyp.Tag.Lookup("kvsep"); ok {
+		switch {
+		case ftyp.Type.Kind() != reflect.Map:
+			panic(newErrorf("kvsep tag of field %s, which isn't a map", field))
+		case seps.kv == "":
+			panic(newErrorf("empty kvsep tag of field %s", field))
+		case seps.kv == seps.elemSep():
+			panic(newErrorf("kvsep tag of field %s is the same as the separator of elements %q", field, seps.kv))
+		}
+	}
+	if seps.elem != "" && seps.elem == seps.kvSep() && ftyp.Type.Kind() == reflect.Map {
+		panic(newErrorf("sep tag of field %s is the same as the separator of keys and values %q", field, seps.elem))
+	}
+	return seps
+}
+
+func compileStruct(root reflect.Type, explicit bool) *structDesc {
+	var (
+		desc         structDesc
+		nonFlags     []*fieldDesc
+		nonFlagSlice *fieldDesc
+		passthrough  *fieldDesc
+		// flagNames are the fields of the flag names
+		flagNames = make(map[string]string)
+		// aliasOf are the first names of the flags of the aliases
+		aliasOf = make(map[string]string)
+		// unnamed are the fields of the names of the fields left out by
+		// explicit
+		unnamed = make(map[string]string)
+		// walking are the struct types being compiled, a type embedding
+		// itself through a pointer is flattened once.
+		walking = make(map[reflect.Type]bool)
+	)
+	// compile appends the fields of reft, which is embedded by the path embed
+	// of fields named by prefix.
+	var compile func(reft reflect.Type, embed []int, prefix string)
+	compile = func(reft reflect.Type, embed []int, prefix string) {
+		walking[reft] = true
+		defer delete(walking, reft)
+		fieldName := func(name string) string {
+			if prefix == "" {
+				return name
+			}
+			return prefix + "." + name
+		}
+		for i := 0; i < reft.NumField(); i++ {
+			ftyp := reft.Field(i)
+			if ftyp.Type == setInfoType {
+				desc.fields = append(desc.fields, fieldDesc{embed: embed, index: i, kind: setInfoField})
+				continue
+			}
+			name := ftyp.Tag.Get("name")
+			if name == "-" {
+				continue
+			}
+			// commands of StructCommands
+			if _, ok := ftyp.Tag.Lookup("cmd"); ok {
+				continue
+			}
+			if t, ok := embeddedStruct(ftyp); ok {
+				if !walking[t] {
+					compile(t, append(embed[:len(embed):len(embed)], i), fieldName(ftyp.Name))
+				}
+				continue
+			}
+			if ftyp.Anonymous {
+				continue
+			}
+			if name != "" && !isExported(ftyp.Name) {
+				panic(newErrorf(`field %s is unexported; export it or tag it name:"-"`, fieldName(ftyp.Name)))
+			}
+			d := fieldDesc{
+				embed: embed,
+				index: i,
+				field: ftyp.Name,
+				typ:   ftyp.Type,
+				usage: ftyp.Tag.Get("usage"),
+				env:   ftyp.Tag.Get("env"),
+				def:   ftyp.Tag.Get("default"),
+			}
+			// env:"-" opts the field out of the environment
+			if d.env == "-" {
+				d.env = ""
+			}
+			if strings.HasPrefix(name, "#") {
+				d.name = strings.TrimPrefix(name, "#")
+				if d.name == "" {
+					d.name = strings.ToUpper(ftyp.Name)
+				}
+				switch isPassthrough, _ := strconv.ParseBool(ftyp.Tag.Get("passthrough")); {
+				case isPassthrough:
+					if ftyp.Type != stringsType {
+						panic(newErrorf("passthrough field %s must be []string", ftyp.Name))
+					}
+					if passthrough != nil {
+						panic(newErrorf("duplicated passthrough field: %s", ftyp.Name))
+					}
+					if _, ok := ftyp.Tag.Lookup("pos"); ok {
+						panic(newErrorf("pos tag of passthrough field %s, which takes no position", ftyp.Name))
+					}
+					d.kind = passthroughKind
+					passthrough = &d
+				case isNonFlagSlice(ftyp.Type):
+					if nonFlagSlice != nil {
+						panic(newErrorf("duplicated non-flag slice field: %s", ftyp.Name))
+					}
+					d.kind = nonFlagSliceKind
+					d.pos = posTag(ftyp, true)
+					d.at = len(nonFlags)
+					d.min = countTag(ftyp, "min")
+					d.max = countTag(ftyp, "max")
+					d.afterTerminator, _ = strconv.ParseBool(ftyp.Tag.Get("after-terminator"))
+					if d.max > 0 && d.max < d.min {
+						panic(newErrorf("max tag less than min tag of non-flag field: %s", ftyp.Name))
+					}
+					nonFlagSlice = &d
+				default:
+					if _, ok := newFlagValue(reflect.New(ftyp.Type).Elem()); !ok {
+						panic(newErrorf("unsupported type of non-flag field: %s", ftyp.Name))
+					}
+					d.kind = nonFlagFieldKind
+					d.pos = posTag(ftyp, false)
+					if d.def != "" {
+						scratch, _ := newFlagValue(reflect.New(ftyp.Type).Elem())
+						if err := scratch.Set(d.def); err != nil {
+							panic(newErrorf("invalid default %q of non-flag field %s: %v", d.def, ftyp.Name, err))
+						}
+						if nonFlagSlice != nil && !nonFlagSlice.afterTerminator && d.pos == 0 {
+							panic(newErrorf("non-flag field %s after the non-flag slice can't have a default", ftyp.Name))
+						}
+					} else if n := len(nonFlags); n > 0 && nonFlags[n-1].def != "" && d.pos == 0 {
+						panic(newErrorf("required non-flag field %s follows optional ones", ftyp.Name))
+					}
+					nonFlags = append(nonFlags, &d)
+				}
+				desc.fields = append(desc.fields, d)
+				continue
+			}
+
+			if name == "" {
+				name = defaultFlagName(ftyp)
+				if name == "" {
+					continue
+				}
+				d.implicit = true
+			}
+			// fields of unsupported types aren't flags
+			scratch, ok := newFlagValue(reflect.New(ftyp.Type).Elem())
+			if !ok {
+				continue
+			}
+			if mv, ok := scratch.(multiValue); ok {
+				d.seps = separatorTags(ftyp, fieldName(ftyp.Name))
+				mv.setSeparators(d.seps)
+			} else if hasSeparatorTags(ftyp) {
+				panic(newErrorf("sep, kvsep or env-sep tag of field %s, which isn't a slice or map", fieldName(ftyp.Name)))
+			}
+			if d.seps.percent, ok = parseFormatTag(ftyp.Tag.Get("format"), ftyp.Type); !ok {
+				panic(newErrorf("invalid format tag %q of field %s", ftyp.Tag.Get("format"), fieldName(ftyp.Name)))
+			}
+			if d.seps.percent {
+				scratch = &percentValue{val: reflect.New(ftyp.Type).Elem()}
+			}
+			if d.seps.unique, ok = parseUniqueTag(ftyp.Tag.Get("unique")); !ok {
+				panic(newErrorf("invalid unique tag %q of field %s", ftyp.Tag.Get("unique"), ftyp.Name))
+			}
+			if d.seps.unique != uniqueNever {
+				if ftyp.Type.Kind() != reflect.Slice {
+					panic(newErrorf("unique tag of field %s, which isn't a slice", fieldName(ftyp.Name)))
+				}
+				scratch.(multiValue).setSeparators(d.seps)
+			}
+			if d.def != "" {
+				if err := scratch.Set(d.def); err != nil {
+					panic(newErrorf("invalid default %q of field %s: %v", d.def, ftyp.Name, err))
+				}
+			}
+			d.kind = flagField
+			if m, ok := reflect.PtrTo(reft).MethodByName("Default" + ftyp.Name); ok {
+				if m.Type.NumIn() != 1 || m.Type.NumOut() != 1 || m.Type.Out(0) != ftyp.Type {
+					panic(newErrorf("method %s of %s must be func() %s", m.Name, reft, ftyp.Type))
+				}
+				if d.def != "" {
+					panic(newErrorf("field %s has both a default tag and the method %s", fieldName(ftyp.Name), m.Name))
+				}
+				d.defaultMethod = m.Name
+			}
+			d.names = splitAndTrim(name)
+			if !validFlagNames(d.names) {
+				panic(newErrorf("invalid name tag %q of field %s", name, ftyp.Name))
+			}
+			if alias := ftyp.Tag.Get("alias"); alias != "" {
+				d.aliases = splitAndTrim(alias)
+				if !validFlagNames(d.aliases) {
+					panic(newErrorf("invalid alias tag %q of field %s", alias, ftyp.Name))
+				}
+			}
+			switch envEmpty := ftyp.Tag.Get("env-empty"); envEmpty {
+			case "":
+			case "ignore":
+				d.ignoreEmptyEnv = true
+			default:
+				panic(newErrorf("invalid env-empty tag %q of field %s", envEmpty, ftyp.Name))
+			}
+			d.required, _ = strconv.ParseBool(ftyp.Tag.Get("required"))
+			d.hidden, _ = strconv.ParseBool(ftyp.Tag.Get("hidden"))
+			d.secret, _ = strconv.ParseBool(ftyp.Tag.Get("secret"))
+			d.stdin, _ = strconv.ParseBool(ftyp.Tag.Get("stdin"))
+			d.fromFile, _ = strconv.ParseBool(ftyp.Tag.Get("from-file"))
+			if d.expand, ok = parseExpandTag(ftyp.Tag.Get("expand")); !ok {
+				panic(newErrorf("invalid expand tag %q of field %s", ftyp.Tag.Get("expand"), ftyp.Name))
+			}
+			d.isConfig, _ = strconv.ParseBool(ftyp.Tag.Get("config"))
+			if d.appendDefault, _ = strconv.ParseBool(ftyp.Tag.Get("append-to-default")); d.appendDefault {
+				if _, ok := scratch.(multiValue); !ok {
+					panic(newErrorf("append-to-default tag of field %s, which isn't a slice or map", fieldName(ftyp.Name)))
+				}
+			}
+			if d.resolve, _ = strconv.ParseBool(ftyp.Tag.Get("resolve")); d.resolve && ftyp.Type != hostPortType {
+				panic(newErrorf("resolve tag of field %s, which isn't a sflag.HostPort", fieldName(ftyp.Name)))
+			}
+			d.deprecated = ftyp.Tag.Get("deprecated")
+			d.requires = splitAndTrim(ftyp.Tag.Get("requires"))
+			d.validate = splitAndTrim(ftyp.Tag.Get("validate"))
+			d.oneof = splitAndTrim(ftyp.Tag.Get("oneof-required"))
+			if d.implicit && explicit {
+				// kept for defineFlags to reject the other tags of it
+				unnamed[d.names[0]] = fieldName(ftyp.Name)
+				desc.fields = append(desc.fields, d)
+				continue
+			}
+			for _, n := range append(d.names[:len(d.names):len(d.names)], d.aliases...) {
+				if other, ok := flagNames[n]; ok {
+					panic(newErrorf("flag -%s defined by both %s and %s", n, other, fieldName(ftyp.Name)))
+				}
+				flagNames[n] = fieldName(ftyp.Name)
+			}
+			for _, alias := range d.aliases {
+				aliasOf[alias] = d.names[0]
+			}
+			desc.fields = append(desc.fields, d)
+		}
+	}
+	compile(root, nil, root.Name())
+	desc.orderPositionals()
+	for _, d := range desc.fields {
+		for i, name := range d.requires {
+			if _, ok := flagNames[name]; !ok {
+				if field, ok := unnamed[name]; ok {
+					panic(newErrorf("requires tag of %s names field %s, which has no name tag as Parser.ExplicitFlags requires", d.field, field))
+				}
+				panic(newErrorf("unknown flag -%s in the requires tag of %s", name, d.field))
+			}
+			// the flags are looked up by their names at parse time
+			if primary, ok := aliasOf[name]; ok {
+				d.requires[i] = primary
+			}
+		}
+	}
+	if passthrough != nil && nonFlagSlice != nil && nonFlagSlice.afterTerminator {
+		panic(newErrorf("passthrough field %s conflicts with the after-terminator non-flag slice %s", passthrough.field, nonFlagSlice.field))
+	}
+	return &desc
+}
@@ -0,0 +1,161 @@
+package sflag
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	flagTags = map[string]bool{
+		"name": true, "alias": true, "usage": true, "env": true, "env-empty": true, "default": true,
+		"required": true, "hidden": true, "secret": true, "stdin": true, "expand": true, "from-file": true, "config": true,
+		"deprecated": true, "requires": true, "validate": true, "oneof-required": true, "append-to-default": true, "resolve": true, "sep": true, "kvsep": true, "env-sep": true, "unique": true, "format": true,
+	}
+	nonFlagTags      = map[string]bool{"name": true, "usage": true, "default": true, "pos": true}
+	nonFlagSliceTags = map[string]bool{"name": true, "usage": true, "min": true, "max": true, "after-terminator": true, "passthrough": true, "pos": true}
+	// sflagTags are all the tag keys read by sflag.
+	sflagTags = map[string]bool{"short": true, "cmd": true}
+)
+
+func init() {
+	for _, tags := range []map[string]bool{flagTags, nonFlagTags, nonFlagSliceTags} {
+		for key := range tags {
+			sflagTags[key] = true
+		}
+	}
+}
+
+var (
+	// extraTags are the tag keys of RegisterTags.
+	extraTags sync.Map
+	// checkedTags are the struct types passing checkTags. Types aren't
+	// checked again as registering tags only makes the check pass more.
+	checkedTags sync.Map
+)
+
+// RegisterTags adds keys to the tag keys accepted by Parser.StrictTags, for
+// the tags read by other packages, such as json.
+func RegisterTags(keys ...string) {
+	for _, key := range keys {
+		extraTags.Store(key, true)
+	}
+}
+
+// tagKeys returns the keys of tag in order, following the conventional
+// format of reflect.StructTag.Get.
+func tagKeys(tag reflect.StructTag) []string {
+	var keys []string
+	for tag != "" {
+		tag = reflect.StructTag(strings.TrimLeft(string(tag), " "))
+		i := 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' && tag[i] != 0x7f {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			break
+		}
+		key := string(tag[:i])
+		tag = tag[i+1:]
+		// skip the quoted value
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			break
+		}
+		if _, err := strconv.Unquote(string(tag[:i+1])); err != nil {
+			break
+		}
+		tag = tag[i+1:]
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// checkTags panics if the tags of the fields of the struct type reft have
+// unknown keys, or keys of sflag not applying to the kind of the field.
+func checkTags(reft reflect.Type) {
+	if _, ok := checkedTags.Load(reft); ok {
+		return
+	}
+	if problems := tagProblems(reft, make(map[reflect.Type]bool)); len(problems) > 0 {
+		panic(newErrorf("%s", strings.Join(problems, "; ")))
+	}
+	checkedTags.Store(reft, true)
+}
+
+// tagProblems returns the problems of the tags of reft and the structs it
+// embeds, walking are the struct types being checked.
+func tagProblems(reft reflect.Type, walking map[reflect.Type]bool) []string {
+	walking[reft] = true
+	defer delete(walking, reft)
+	var problems []string
+	for i := 0; i < reft.NumField(); i++ {
+		ftyp := reft.Field(i)
+		if t, ok := embeddedStruct(ftyp); ok && ftyp.Tag.Get("name") != "-" {
+			if !walking[t] {
+				problems = append(problems, tagProblems(t, walking)...)
+			}
+			continue
+		}
+		if ftyp.Anonymous || ftyp.Type == setInfoType {
+			continue
+		}
+		var allowed map[string]bool
+		name := ftyp.Tag.Get("name")
+		switch {
+		case name == "-":
+			allowed = map[string]bool{"name": true}
+		case strings.HasPrefix(name, "#") && isNonFlagSlice(ftyp.Type):
+			allowed = nonFlagSliceTags
+		case strings.HasPrefix(name, "#"):
+			allowed = nonFlagTags
+		default:
+			if name == "" && defaultFlagName(ftyp) == "" {
+				continue
+			}
+			// fields of unsupported types aren't flags
+			if _, ok := newFlagValue(reflect.New(ftyp.Type).Elem()); !ok {
+				continue
+			}
+			allowed = flagTags
+			if name == "" {
+				allowed = map[string]bool{"short": true}
+				for key := range flagTags {
+					allowed[key] = true
+				}
+			}
+		}
+		var unknown, misplaced []string
+		for _, key := range tagKeys(ftyp.Tag) {
+			if allowed[key] {
+				continue
+			}
+			if sflagTags[key] {
+				misplaced = append(misplaced, key)
+			} else if _, ok := extraTags.Load(key); !ok {
+				unknown = append(unknown, key)
+			}
+		}
+		field := ftyp.Name
+		if reft.Name() != "" {
+			field = reft.Name() + "." + field
+		}
+		if len(unknown) > 0 {
+			problems = append(problems, "unknown tags of field "+field+": "+strings.Join(unknown, ", "))
+		}
+		if len(misplaced) > 0 {
+			problems = append(problems, "misplaced tags of field "+field+": "+strings.Join(misplaced, ", "))
+		}
+		if unknown := unknownPlaceholders(ftyp.Tag.Get("usage")); len(unknown) > 0 {
+			problems = append(problems, "unknown placeholders in usage tag of field "+field+": "+strings.Join(unknown, ", "))
+		}
+	}
+	return problems
+}
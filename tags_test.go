@@ -0,0 +1,137 @@
+package sflag
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTagKeys(t *testing.T) {
+	for tag, want := range map[reflect.StructTag][]string{
+		``:                                  nil,
+		`name:"port" defualt:"8080"`:        {"name", "defualt"},
+		`usage:"a \"quoted\" usage" env:""`: {"usage", "env"},
+		`name:"x" broken`:                   {"name"},
+	} {
+		if got := tagKeys(tag); !reflect.DeepEqual(got, want) {
+			t.Errorf("tagKeys(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
+
+type typoOpts struct {
+	Port int `name:"port" defualt:"8080"`
+}
+
+func TestStrictTags(t *testing.T) {
+	RegisterTags("json")
+	for _, c := range []struct {
+		flags interface{}
+		want  string
+	}{
+		{&typoOpts{}, "unknown tags of field typoOpts.Port: defualt"},
+		{&struct {
+			Dir string `name:"#" short:"true" env:"DIR"`
+		}{}, "misplaced tags of field Dir: short, env"},
+		{&struct {
+			Cache string   `name:"-" default:"x"`
+			Files []string `name:"#" default:"a"`
+		}{}, "misplaced tags of field Cache: default; misplaced tags of field Files: default"},
+		{&struct {
+			Host string `name:"host" short:"true" json:"host"`
+		}{}, "misplaced tags of field Host: short"},
+		{&struct {
+			Port  int      `short:"true" default:"80" json:"port"`
+			Files []string `name:"#" min:"1"`
+		}{}, ""},
+		{&struct {
+			Port int    `name:"port" usage:"port, {defualt} by default, {{ok}}"`
+			Host string `name:"#" usage:"{name} or {ip}"`
+		}{}, "unknown placeholders in usage tag of field Port: {defualt}; unknown placeholders in usage tag of field Host: {ip}"},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); (r == nil) != (c.want == "") || (r != nil && fmt.Sprint(r) != c.want) {
+					t.Errorf("recover() = %v, want %q", r, c.want)
+				}
+			}()
+			_ = (&Parser{StrictTags: true}).Parse([]string{"prog"}, c.flags)
+		}()
+	}
+
+	var flags typoOpts
+	if err := Parse([]string{"prog"}, &flags); err != nil {
+		t.Errorf("Parse without StrictTags = %v", err)
+	}
+}
+
+func TestExplicitFlags(t *testing.T) {
+	type options struct {
+		Port  int      `name:"port" usage:"port"`
+		Data  string   `json:"data"`
+		Count int      // not a flag
+		Files []string `name:"#FILE"`
+	}
+	opts := options{Data: "keep", Count: 3}
+	p := &Parser{ExplicitFlags: true, ErrOutput: &strings.Builder{}}
+	if err := p.Parse([]string{"prog", "-port", "80", "a", "b"}, &opts); err != nil {
+		t.Fatal(err)
+	}
+	if want := (options{Port: 80, Data: "keep", Count: 3, Files: []string{"a", "b"}}); !reflect.DeepEqual(opts, want) {
+		t.Errorf("got %+v, want %+v", opts, want)
+	}
+	if err := p.Parse([]string{"prog", "-count", "4"}, &opts); err == nil || !strings.Contains(err.Error(), "-count") {
+		t.Errorf("Parse(-count) = %v, want an error", err)
+	}
+	if err := (&Parser{}).Parse([]string{"prog", "-count", "4"}, &opts); err != nil || opts.Count != 4 {
+		t.Errorf("Parse(-count) without ExplicitFlags = %v, %d", err, opts.Count)
+	}
+
+	checkHelp(t, helpOutput(t, &Parser{ExplicitFlags: true}, []string{"prog", "-h"}, &options{}), `Usage: prog [OPTION] [FILE...]
+
+Options:
+  -port     int
+            port
+  FILE      string
+  -h/-help
+            show this help
+`)
+	if got, err := MarshalArgsWith(&opts, MarshalOptions{ExplicitFlags: true}); err != nil || !reflect.DeepEqual(got, []string{"-port=80", "a", "b"}) {
+		t.Errorf("MarshalArgsWith = %q, %v", got, err)
+	}
+
+	// the fields left out don't take names
+	var renamed struct {
+		Total int `name:"count"`
+		Count int
+	}
+	if err := p.Parse([]string{"prog", "-count", "4"}, &renamed); err != nil || renamed.Total != 4 || renamed.Count != 0 {
+		t.Errorf("Parse(-count) = %v, %+v, want -count to set Total", err, renamed)
+	}
+	func() {
+		defer func() {
+			want := "requires tag of TLS names field Cert, which has no name tag as Parser.ExplicitFlags requires"
+			if r := recover(); r == nil || fmt.Sprint(r) != want {
+				t.Errorf("recovered %v, want %s", r, want)
+			}
+		}()
+		var unnamed struct {
+			TLS  bool `name:"tls" requires:"cert"`
+			Cert string
+		}
+		_ = p.Parse([]string{"prog", "-tls"}, &unnamed)
+	}()
+
+	defer func() {
+		want := "field Timeout has the usage tag but no name tag, which Parser.ExplicitFlags requires"
+		if r := recover(); r == nil || fmt.Sprint(r) != want {
+			t.Errorf("recovered %v, want %s", r, want)
+		}
+	}()
+	var half struct {
+		Port    int `name:"port"`
+		Timeout int `usage:"timeout" default:"5"`
+	}
+	_ = (&Parser{ExplicitFlags: true}).Parse([]string{"prog"}, &half)
+}
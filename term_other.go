@@ -0,0 +1,14 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package sflag
+
+import "os"
+
+func disableEcho(f *os.File) (restore func(), ok bool) {
+	return nil, false
+}
+
+func terminalWidth(f *os.File) (int, bool) {
+	return 0, false
+}
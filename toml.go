@@ -0,0 +1,317 @@
+package sflag
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// LoadConfigTOML loads flag values from a TOML document, it is the same as
+// LoadConfig(r, "toml").
+//
+// Only a subset of TOML is supported: strings, integers, floats, booleans,
+// arrays of them and tables. Tables map to the prefix of flag names, so
+// "port" of table [server] sets the flag -server-port. Dates are kept as
+// strings.
+func (p *Parser) LoadConfigTOML(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	doc, err := parseTOML(string(data))
+	if err != nil {
+		return err
+	}
+	return p.addConfig(doc, "")
+}
+
+type tomlParser struct {
+	s    string
+	pos  int
+	line int
+}
+
+func parseTOML(s string) (map[string]interface{}, error) {
+	p := &tomlParser{s: s, line: 1}
+	root := make(map[string]interface{})
+	table := root
+	for {
+		p.skipSpace(true)
+		if p.eof() {
+			return root, nil
+		}
+		if p.peek() == '[' {
+			p.pos++
+			if !p.eof() && p.peek() == '[' {
+				return nil, p.errorf("arrays of tables are not supported")
+			}
+			keys, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace(false)
+			if p.eof() || p.peek() != ']' {
+				return nil, p.errorf("expect ] after table name")
+			}
+			p.pos++
+			table, err = p.subtable(root, keys)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			keys, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace(false)
+			if p.eof() || p.peek() != '=' {
+				return nil, p.errorf("expect = after key")
+			}
+			p.pos++
+			p.skipSpace(false)
+			val, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			t, err := p.subtable(table, keys[:len(keys)-1])
+			if err != nil {
+				return nil, err
+			}
+			key := keys[len(keys)-1]
+			if _, ok := t[key]; ok {
+				return nil, p.errorf("duplicated key: %s", strings.Join(keys, "."))
+			}
+			t[key] = val
+		}
+		if err := p.endLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *tomlParser) errorf(format string, v ...interface{}) error {
+	return newErrorf("toml: line %d: %s", p.line, fmt.Sprintf(format, v...))
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *tomlParser) peek() byte {
+	return p.s[p.pos]
+}
+
+// skipSpace skips spaces and comments, and newlines too if multiline.
+func (p *tomlParser) skipSpace(multiline bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '\n' && multiline:
+			p.pos++
+			p.line++
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) endLine() error {
+	p.skipSpace(false)
+	if p.eof() {
+		return nil
+	}
+	if p.peek() != '\n' {
+		return p.errorf("unexpected %q", p.s[p.pos:p.lineEnd()])
+	}
+	return nil
+}
+
+func (p *tomlParser) lineEnd() int {
+	if i := strings.IndexByte(p.s[p.pos:], '\n'); i >= 0 {
+		return p.pos + i
+	}
+	return len(p.s)
+}
+
+func (p *tomlParser) subtable(t map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	for _, key := range keys {
+		v, ok := t[key]
+		if !ok {
+			sub := make(map[string]interface{})
+			t[key] = sub
+			t = sub
+			continue
+		}
+		sub, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, p.errorf("key %s is not a table", key)
+		}
+		t = sub
+	}
+	return t, nil
+}
+
+// parseKey parses a possibly dotted key of bare or quoted parts.
+func (p *tomlParser) parseKey() ([]string, error) {
+	var keys []string
+	for {
+		p.skipSpace(false)
+		if p.eof() {
+			return nil, p.errorf("expect key")
+		}
+		var key string
+		switch p.peek() {
+		case '"', '\'':
+			s, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			key = s
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.peek()) {
+				p.pos++
+			}
+			key = p.s[start:p.pos]
+			if key == "" {
+				return nil, p.errorf("invalid key")
+			}
+		}
+		keys = append(keys, key)
+		p.skipSpace(false)
+		if p.eof() || p.peek() != '.' {
+			return keys, nil
+		}
+		p.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	if p.eof() {
+		return nil, p.errorf("expect value")
+	}
+	switch p.peek() {
+	case '"', '\'':
+		return p.parseString()
+	case '[':
+		return p.parseArray()
+	case '{':
+		return nil, p.errorf("inline tables are not supported")
+	}
+	start := p.pos
+	for !p.eof() {
+		c := p.peek()
+		if c == ',' || c == ']' || c == '#' || c == '\n' || c == '\r' {
+			break
+		}
+		// a space is only allowed between the date and time of a datetime
+		if (c == ' ' || c == '\t') && !(p.pos-start == 10 && p.pos+1 < len(p.s) && isDigit(p.s[p.pos+1])) {
+			break
+		}
+		p.pos++
+	}
+	tok := p.s[start:p.pos]
+	switch tok {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "":
+		return nil, p.errorf("expect value")
+	}
+	if v, err := strconv.ParseInt(tok, 0, 64); err == nil && !isLeadingZero(tok) {
+		return v, nil
+	}
+	num := strings.Replace(tok, "_", "", -1)
+	if v, err := strconv.ParseFloat(num, 64); err == nil && !isLeadingZero(num) && !strings.HasPrefix(strings.TrimLeft(num, "+-"), "0x") {
+		return v, nil
+	}
+	if isDigit(tok[0]) && strings.ContainsAny(tok, "-:") {
+		return tok, nil
+	}
+	return nil, p.errorf("invalid value: %s", tok)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isLeadingZero reports decimal integers with leading zeros, which Go reads
+// as octal but TOML forbids.
+func isLeadingZero(tok string) bool {
+	tok = strings.TrimLeft(tok, "+-")
+	return len(tok) > 1 && tok[0] == '0' && isDigit(tok[1])
+}
+
+func (p *tomlParser) parseString() (string, error) {
+	quote := p.peek()
+	if strings.HasPrefix(p.s[p.pos:], strings.Repeat(string(quote), 3)) {
+		return "", p.errorf("multi-line strings are not supported")
+	}
+	start := p.pos
+	p.pos++
+	for !p.eof() {
+		c := p.peek()
+		switch {
+		case c == '\n':
+			return "", p.errorf("unterminated string")
+		case c == '\\' && quote == '"':
+			p.pos += 2
+			continue
+		case c == quote:
+			p.pos++
+			if quote == '\'' {
+				return p.s[start+1 : p.pos-1], nil
+			}
+			s, err := strconv.Unquote(p.s[start:p.pos])
+			if err != nil {
+				return "", p.errorf("invalid string %s", p.s[start:p.pos])
+			}
+			return s, nil
+		}
+		p.pos++
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *tomlParser) parseArray() ([]interface{}, error) {
+	p.pos++
+	arr := []interface{}{}
+	for {
+		p.skipSpace(true)
+		if p.eof() {
+			return nil, p.errorf("unterminated array")
+		}
+		if p.peek() == ']' {
+			p.pos++
+			return arr, nil
+		}
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+		p.skipSpace(true)
+		if p.eof() {
+			return nil, p.errorf("unterminated array")
+		}
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, p.errorf("expect , or ] in array")
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package sflag
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	doc, err := parseTOML(`# service
+name = "a" # comment
+count = 3
+ratio = 1.5
+verbose = true
+tags = ["x", 'y']
+
+[db]
+host = 'h'
+port = 1
+`)
+	if err != nil {
+		t.Fatalf("parseTOML: %v", err)
+	}
+	got := make(config)
+	if err := flattenConfig(got, "", doc); err != nil {
+		t.Fatalf("flattenConfig: %v", err)
+	}
+	want := config{
+		"name": {"a"}, "count": {"3"}, "ratio": {"1.5"}, "verbose": {"true"},
+		"tags": {"x", "y"}, "db-host": {"h"}, "db-port": {"1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTOML = %v, want %v", got, want)
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		doc, err string
+	}{
+		{"name = \"a\"\ncount = \n", "toml: line 2: expect value"},
+		{"[db\n", "toml: line 1: expect ] after table name"},
+		{"a = 1\nx = \"unterminated\n", "toml: line 2: unterminated string"},
+	}
+	for _, tt := range tests {
+		if _, err := parseTOML(tt.doc); err == nil || err.Error() != tt.err {
+			t.Errorf("parseTOML(%q) = %v, want %s", tt.doc, err, tt.err)
+		}
+	}
+}
+
+func TestLoadConfigTOMLPrecedence(t *testing.T) {
+	var flags struct {
+		A string `default:"default" env:"A"`
+		B string `default:"default" env:"B"`
+		C string `default:"default" env:"C"`
+		D string `default:"default" env:"D"`
+	}
+	p := &Parser{LookupEnv: mapEnv(map[string]string{"A": "env", "B": "env"})}
+	if err := p.LoadConfigTOML(strings.NewReader("a = \"toml\"\nb = \"toml\"\nc = \"toml\"\n")); err != nil {
+		t.Fatalf("LoadConfigTOML: %v", err)
+	}
+	if err := p.Parse([]string{"prog", "-a", "flag"}, &flags); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if flags.A != "flag" || flags.B != "env" || flags.C != "toml" || flags.D != "default" {
+		t.Errorf("flags %+v, want flag > env > toml > default", flags)
+	}
+}
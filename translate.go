@@ -0,0 +1,160 @@
+package sflag
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// TranslateFunc returns the text of key formatted with args. The keys are the
+// Msg constants, which are the English formats of the texts, so a translation
+// is a map of them to the formats of another language.
+//
+// The errors of the flag package, such as invalid values, aren't translated,
+// but for the undefined flags with similar ones suggested.
+type TranslateFunc func(key string, args ...interface{}) string
+
+// The keys of TranslateFunc, each taking the args of its format.
+const (
+	MsgNoOptions           = "no options."
+	MsgUsage               = "Usage: %s"
+	MsgOptionPlaceholder   = "OPTION"
+	MsgCommandPlaceholder  = "COMMAND"
+	MsgArgumentPlaceholder = "ARGUMENT"
+	MsgOptions             = "Options:"
+	MsgGlobalOptions       = "Global options:"
+	MsgRequired            = "required"
+	MsgDefault             = "default: %s"
+	MsgComputedDefault     = "default: %s (computed)"
+	MsgChoices             = "one of: %s"
+	MsgEnv                 = "env: %s"
+	MsgStdin               = "- reads stdin"
+	MsgCommands            = "Commands:"
+	MsgGroupCommands       = "%s Commands:"
+	MsgExamples            = "Examples:"
+	MsgShowHelp            = "show this help"
+	MsgSecretPrompt        = "value of -%s: "
+	MsgDeprecatedAlias     = "flag -%s is deprecated, use -%s"
+	MsgDeprecatedFlag      = "flag -%s is deprecated, %s"
+	MsgHelpCommand         = "show help of the program or a command"
+	MsgWarning             = "warning: %v"
+
+	MsgUnknownCommand     = "unknown command: %s"
+	MsgDidYouMean         = "unknown command: %s (did you mean %s?)"
+	MsgDidYouMeanFlag     = "flag provided but not defined: -%s (did you mean %s?)"
+	MsgOr                 = " or "
+	MsgNoCommand          = "no command to be run"
+	MsgNoCommandArguments = "the command should be runs without arguments"
+
+	MsgCommandNoArguments      = "command %q accepts no arguments, got %d"
+	MsgCommandExactArguments   = "command %q accepts %d %s, got %d"
+	MsgCommandMinArguments     = "command %q accepts at least %d %s, got %d"
+	MsgCommandRangeArguments   = "command %q accepts between %d and %d arguments, got %d"
+	MsgCommandInvalidArguments = "command %q: %v"
+
+	MsgMissingFlag         = "missing required flag: -%s"
+	MsgMissingArgument     = "missing required argument: %s"
+	MsgInvalidArgument     = "argument %s: invalid %s %q"
+	MsgArgumentError       = "argument %s: %v"
+	MsgArgument            = "argument"
+	MsgArguments           = "arguments"
+	MsgTooFewArguments     = "expected at least %d %s %s, got %d"
+	MsgTooManyArguments    = "expected at most %d %s %s, got %d: extra %v"
+	MsgUnexpectedArgument  = "unexpected argument %s"
+	MsgUnexpectedArguments = "unexpected arguments %s"
+	MsgNoArgumentsExpected = "%s (no arguments expected)"
+	MsgExpectedArguments   = "%s (expected: %s)"
+	MsgRequires            = "-%s requires %s"
+	MsgOneofRequired       = "at least one of %s is required"
+	MsgValidatorFailed     = "flag -%s failed validator %s: %v"
+	MsgFlagNeedsArgument   = "flag needs an argument: -%s"
+	MsgInvalidFlagValue    = "invalid value %q for flag -%s: %v"
+	MsgInvalidEnvValue     = "invalid value %q for $%s (flag -%s): %v"
+	MsgInvalidConfigValue  = "invalid config value %q for flag -%s: %v"
+	MsgUnknownConfigKeys   = "unknown config keys: %s"
+)
+
+// MessageKeys returns all the keys of TranslateFunc, to check a translation
+// is complete.
+func MessageKeys() []string {
+	return []string{
+		MsgNoOptions, MsgUsage, MsgOptionPlaceholder, MsgCommandPlaceholder,
+		MsgArgumentPlaceholder, MsgOptions, MsgGlobalOptions, MsgRequired, MsgDefault, MsgComputedDefault, MsgChoices, MsgEnv, MsgStdin,
+		MsgCommands, MsgGroupCommands, MsgExamples, MsgShowHelp, MsgSecretPrompt,
+		MsgDeprecatedAlias, MsgDeprecatedFlag, MsgHelpCommand, MsgWarning,
+		MsgUnknownCommand, MsgDidYouMean, MsgDidYouMeanFlag, MsgOr, MsgNoCommand,
+		MsgNoCommandArguments, MsgCommandNoArguments, MsgCommandExactArguments,
+		MsgCommandMinArguments, MsgCommandRangeArguments, MsgCommandInvalidArguments,
+		MsgMissingFlag, MsgMissingArgument,
+		MsgInvalidArgument, MsgArgumentError, MsgArgument, MsgArguments,
+		MsgTooFewArguments, MsgTooManyArguments, MsgUnexpectedArgument,
+		MsgUnexpectedArguments, MsgNoArgumentsExpected, MsgExpectedArguments,
+		MsgRequires, MsgOneofRequired, MsgValidatorFailed, MsgFlagNeedsArgument,
+		MsgInvalidFlagValue, MsgInvalidEnvValue, MsgInvalidConfigValue,
+		MsgUnknownConfigKeys,
+	}
+}
+
+// English is the TranslateFunc of Parser.Translator if it's nil, other
+// translations may fall back to it.
+func English(key string, args ...interface{}) string {
+	if len(args) == 0 {
+		return key
+	}
+	return fmt.Sprintf(key, args...)
+}
+
+func (p *Parser) translator() TranslateFunc {
+	if p.Translator == nil {
+		return English
+	}
+	return p.Translator
+}
+
+// errorf returns the error of the text of key.
+func (tr TranslateFunc) errorf(key string, args ...interface{}) error {
+	return newErrorf("%s", tr(key, args...))
+}
+
+// displayWidth returns the number of columns s takes on a terminal, wide
+// characters such as CJK take two and combining marks none.
+func displayWidth(s string) int {
+	var width int
+	for _, r := range s {
+		switch {
+		case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		case isWideRune(r):
+			width += 2
+		default:
+			width++
+		}
+	}
+	return width
+}
+
+// wideRanges are the East Asian wide and fullwidth characters.
+var wideRanges = [][2]rune{
+	{0x1100, 0x115F},
+	{0x2E80, 0x303E},
+	{0x3041, 0x33FF},
+	{0x3400, 0x4DBF},
+	{0x4E00, 0x9FFF},
+	{0xA000, 0xA4CF},
+	{0xAC00, 0xD7A3},
+	{0xF900, 0xFAFF},
+	{0xFE30, 0xFE4F},
+	{0xFF00, 0xFF60},
+	{0xFFE0, 0xFFE6},
+	{0x1F300, 0x1F64F},
+	{0x1F900, 0x1F9FF},
+	{0x20000, 0x2FFFD},
+	{0x30000, 0x3FFFD},
+}
+
+func isWideRune(r rune) bool {
+	for _, rg := range wideRanges {
+		if r >= rg[0] && r <= rg[1] {
+			return true
+		}
+	}
+	return false
+}
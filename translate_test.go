@@ -0,0 +1,98 @@
+package sflag
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var chinese = map[string]string{
+	MsgUsage:             "用法：%s",
+	MsgOptionPlaceholder: "选项",
+	MsgOptions:           "选项：",
+	MsgDefault:           "默认：%s",
+	MsgShowHelp:          "显示帮助",
+	MsgMissingArgument:   "缺少参数：%s",
+	MsgHelpCommand:       "显示程序或命令的帮助",
+	MsgInvalidFlagValue:  "标志 -%[2]s 的值 %[1]q 无效：%[3]v",
+}
+
+func translateChinese(key string, args ...interface{}) string {
+	if format, ok := chinese[key]; ok {
+		return fmt.Sprintf(format, args...)
+	}
+	return English(key, args...)
+}
+
+func TestTranslator(t *testing.T) {
+	var flags struct {
+		Host string `name:"主机" default:"localhost" usage:"连接的主机"`
+		Port int    `name:"port" usage:"port to connect"`
+		Dir  string `name:"#DIR"`
+	}
+	p := &Parser{Translator: translateChinese}
+	checkHelp(t, helpOutput(t, p, []string{"prog", "-h"}, &flags), `用法：prog [选项]... DIR
+
+选项：
+  -主机     string (默认："localhost")
+            连接的主机
+  -port     int
+            port to connect
+  DIR       string
+  -h/-help
+            显示帮助
+`)
+	if err := p.Parse([]string{"prog"}, &flags); err == nil || err.Error() != "缺少参数：DIR" {
+		t.Errorf("Parse = %v, want the missing argument translated", err)
+	}
+
+	var global struct {
+		N int `name:"n"`
+	}
+	cmds := []Command{{Name: "run", Usage: "run it"}}
+	var help bytes.Buffer
+	p.Usage = func(printDefaults func(w io.Writer)) { printDefaults(&help) }
+	if _, _, err := p.ParseCommand([]string{"prog", "-h"}, &global, cmds...); err != ErrHelp {
+		t.Fatal(err)
+	}
+	if !strings.Contains(help.String(), "显示程序或命令的帮助") {
+		t.Errorf("help:\n%s\nwant the help command translated", help.String())
+	}
+	p.GlobalFlagsAnywhere = true
+	if _, _, err := p.ParseCommand([]string{"prog", "run", "-n", "x"}, &global, cmds...); err == nil || !strings.HasPrefix(err.Error(), `标志 -n 的值 "x" 无效`) {
+		t.Errorf("ParseCommand = %v, want the invalid global flag translated", err)
+	}
+}
+
+func TestMessageKeys(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, key := range MessageKeys() {
+		if seen[key] {
+			t.Errorf("key %q listed twice", key)
+		}
+		seen[key] = true
+	}
+}
+
+func TestDisplayWidth(t *testing.T) {
+	for s, want := range map[string]int{"": 0, "host": 4, "主机": 4, "ｈｏｓｔ": 8, "é": 1} {
+		if got := displayWidth(s); got != want {
+			t.Errorf("displayWidth(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
+
+func TestWrapWide(t *testing.T) {
+	// a CJK rune takes two columns
+	got := wrapLines("连接的主机 连接的主机", 10)
+	if want := []string{"连接的主机", "连接的主机"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrapLines = %q, want %q", got, want)
+	}
+	got = wrapLines("连接的主机连接的主机", 5)
+	if want := []string{"连接", "的主", "机连", "接的", "主机"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("wrapLines = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,75 @@
+package sflag
+
+import (
+	"strings"
+)
+
+// usagePlaceholders are the placeholders of usage tags, such as
+// usage:"listen address, {default} by default".
+var usagePlaceholders = map[string]bool{"default": true, "env": true, "name": true, "type": true, "choices": true}
+
+// expandPlaceholders replaces the placeholders {name} of s by the values
+// lookup returns, the ones it doesn't know are left as they are. {{ and }}
+// are literal braces.
+func expandPlaceholders(s string, lookup func(name string) (string, bool)) string {
+	if !strings.ContainsAny(s, "{}") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], "{{"), strings.HasPrefix(s[i:], "}}"):
+			b.WriteByte(s[i])
+			i += 2
+			continue
+		case s[i] == '{':
+			if j := strings.IndexAny(s[i+1:], "{}"); j >= 0 && s[i+1+j] == '}' {
+				if v, ok := lookup(s[i+1 : i+1+j]); ok {
+					b.WriteString(v)
+					i += j + 2
+					continue
+				}
+			}
+		}
+		b.WriteByte(s[i])
+		i++
+	}
+	return b.String()
+}
+
+// expandUsage expands the placeholders of the usage of f: {default}, {env},
+// {name}, {type} and {choices}, which are the choices of the flag, joined as
+// help does. The default of a flag is quoted as help shows it.
+func (f *flagInfo) expandUsage(choices []string) {
+	f.Usage = expandPlaceholders(f.Usage, func(name string) (string, bool) {
+		switch name {
+		case "default":
+			if f.value != nil {
+				return helpDefault(f.spec()), true
+			}
+			return f.Default, true
+		case "env":
+			return f.Env, true
+		case "name":
+			return strings.Split(f.Name, "/")[0], true
+		case "type":
+			return f.Type, true
+		case "choices":
+			return strings.Join(choices, "|"), true
+		}
+		return "", false
+	})
+}
+
+// unknownPlaceholders returns the placeholders of usage sflag doesn't know,
+// which are likely misspelled or braces to be escaped by {{ and }}.
+func unknownPlaceholders(usage string) []string {
+	var unknown []string
+	expandPlaceholders(usage, func(name string) (string, bool) {
+		if !usagePlaceholders[name] {
+			unknown = append(unknown, "{"+name+"}")
+		}
+		return "", true
+	})
+	return unknown
+}
@@ -0,0 +1,59 @@
+package sflag
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUsagePlaceholders(t *testing.T) {
+	type options struct {
+		Addr    string `name:"addr,listen" env:"ADDR" default:":8080" usage:"listen address (default {default}, env {env})"`
+		Workers int    `name:"workers" default:"4" usage:"{name} takes a {type}, {{literal}} and {unknown} braces"`
+		Token   string `name:"token" secret:"true" default:"s3cret" usage:"token, {default} by default"`
+		Src     string `name:"#SRC" default:"." usage:"{name} of type {type}, {default} by default"`
+	}
+	want := `Usage: prog [OPTION]... [SRC]
+
+Options:
+  -addr/-listen  string (default: ":8080", env: ADDR)
+                 listen address (default ":8080", env ADDR)
+  -workers       int (default: 4)
+                 -workers takes a int, {literal} and {unknown} braces
+  -token         string (default: ****)
+                 token, **** by default
+  SRC            string (default: .)
+                 SRC of type string, . by default
+  -h/-help
+                 show this help
+`
+	checkHelp(t, helpOutput(t, &Parser{}, []string{"prog", "-h"}, &options{}), want)
+
+	spec, err := Describe(&options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var usages []string
+	for _, f := range spec.Flags {
+		usages = append(usages, f.Usage)
+	}
+	for _, p := range spec.Positionals {
+		usages = append(usages, p.Usage)
+	}
+	if want := []string{
+		`listen address (default ":8080", env ADDR)`,
+		"-workers takes a int, {literal} and {unknown} braces",
+		"token, **** by default",
+		"SRC of type string, . by default",
+	}; !reflect.DeepEqual(usages, want) {
+		t.Errorf("Describe usages = %q, want %q", usages, want)
+	}
+
+	var visited []string
+	_ = (&Parser{}).BindFlags(&options{}, func(f BoundFlag) error {
+		visited = append(visited, f.Usage)
+		return nil
+	})
+	if len(visited) == 0 || visited[0] != `listen address (default ":8080", env ADDR)` {
+		t.Errorf("BindFlags usages = %q", visited)
+	}
+}
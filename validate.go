@@ -0,0 +1,302 @@
+package sflag
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// ValidatorFunc checks the value of a flag, which is the value of the field.
+type ValidatorFunc func(value interface{}) error
+
+// builtinValidators are the validators every Parser has, a validator
+// registered by the same name takes the place of one.
+var builtinValidators = map[string]ValidatorFunc{
+	"nonzero": func(value interface{}) error {
+		if reflect.ValueOf(value).IsZero() {
+			return errors.New("must not be zero")
+		}
+		return nil
+	},
+	"file-exists": func(value interface{}) error {
+		fi, err := statValue(value)
+		if err == nil && fi.IsDir() {
+			err = errors.New("is a directory")
+		}
+		return err
+	},
+	"dir-exists": func(value interface{}) error {
+		fi, err := statValue(value)
+		if err == nil && !fi.IsDir() {
+			err = errors.New("is not a directory")
+		}
+		return err
+	},
+	"url": func(value interface{}) error {
+		s, ok := value.(string)
+		if !ok {
+			return newErrorf("expect a string, got %T", value)
+		}
+		u, err := url.Parse(s)
+		if err != nil {
+			return err
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return errors.New("not an absolute url")
+		}
+		return nil
+	},
+}
+
+func statValue(value interface{}) (os.FileInfo, error) {
+	path, ok := value.(string)
+	if !ok {
+		return nil, newErrorf("expect a string, got %T", value)
+	}
+	return os.Stat(path)
+}
+
+// RegisterValidator registers fn by name for the validate tag, such as
+// `validate:"port,nonzero"`. The validators of the tag check the value of the
+// flag in order once it is set by any source. The built-in validators are
+// nonzero, file-exists, dir-exists and url.
+func (p *Parser) RegisterValidator(name string, fn ValidatorFunc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.validators == nil {
+		p.validators = make(map[string]ValidatorFunc)
+	}
+	p.validators[name] = fn
+}
+
+// flagValidators is a flag tagged with validate.
+type flagValidators struct {
+	value      *sourcedValue
+	names      []string
+	validators []ValidatorFunc
+}
+
+// flagValidators looks up the validators of names, it panics if one of them
+// isn't registered.
+func (p *Parser) flagValidators(field string, value *sourcedValue, names []string) flagValidators {
+	fv := flagValidators{value: value, names: names}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, name := range names {
+		fn, ok := p.validators[name]
+		if !ok {
+			fn, ok = builtinValidators[name]
+		}
+		if !ok {
+			panic(newErrorf("unknown validator %s in the validate tag of %s", name, field))
+		}
+		fv.validators = append(fv.validators, fn)
+	}
+	return fv
+}
+
+// check runs the validators until one of them fails, flags not set by any
+// source aren't checked.
+func (fv flagValidators) check(tr TranslateFunc) error {
+	if fv.value.source == NoSource {
+		return nil
+	}
+	val := fv.value.field.Interface()
+	for i, fn := range fv.validators {
+		if err := fn(val); err != nil {
+			return tr.errorf(MsgValidatorFailed, fv.value.names[0], fv.names[i], err)
+		}
+	}
+	return nil
+}
+
+// flagRequires is a flag tagged with requires, which must be given along with
+// the flags of the tag.
+type flagRequires struct {
+	value    *sourcedValue
+	requires []string
+}
+
+func (fields *structFields) lookup(name string) *sourcedValue {
+	for _, value := range fields.values {
+		if hasName(value.names, name) {
+			return value
+		}
+	}
+	return nil
+}
+
+// flagGroup is a group of flags of a group tag, in the order of declaration.
+type flagGroup struct {
+	name    string
+	members []*sourcedValue
+}
+
+func addToGroup(groups *[]flagGroup, name string, value *sourcedValue) {
+	for i := range *groups {
+		if (*groups)[i].name == name {
+			(*groups)[i].members = append((*groups)[i].members, value)
+			return
+		}
+	}
+	*groups = append(*groups, flagGroup{name, []*sourcedValue{value}})
+}
+
+func (g flagGroup) names() string {
+	names := make([]string, len(g.members))
+	for i, value := range g.members {
+		names[i] = "-" + value.names[0]
+	}
+	return strings.Join(names, ", ")
+}
+
+// checkFlagRules reports the flags given without the flags they require, the
+// oneof-required groups of which no flag is given and the flags failing
+// validators, all of them at once.
+func (fields *structFields) checkFlagRules() error {
+	var unmet []string
+	for _, r := range fields.requires {
+		if !r.value.provided() {
+			continue
+		}
+		var missing []string
+		for _, name := range r.requires {
+			if value := fields.lookup(name); !value.provided() {
+				missing = append(missing, "-"+value.names[0])
+			}
+		}
+		if len(missing) > 0 {
+			unmet = append(unmet, fields.tr(MsgRequires, r.value.names[0], strings.Join(missing, ", ")))
+		}
+	}
+checkOneof:
+	for _, g := range fields.oneofGroups {
+		for _, value := range g.members {
+			if value.provided() {
+				continue checkOneof
+			}
+		}
+		unmet = append(unmet, fields.tr(MsgOneofRequired, g.names()))
+	}
+	for _, fv := range fields.validated {
+		if err := fv.check(fields.tr); err != nil {
+			unmet = append(unmet, err.Error())
+		}
+	}
+	if len(unmet) > 0 {
+		return newErrorf("%s", strings.Join(unmet, "; "))
+	}
+	return nil
+}
+
+// ErrUsage may be wrapped by the errors of Validate methods to print the
+// help before the error.
+var ErrUsage = errors.New("usage error")
+
+// validationError is an error of a Validate method.
+type validationError struct {
+	err error
+}
+
+func (e validationError) Error() string {
+	return e.err.Error()
+}
+func (e validationError) Unwrap() error {
+	return e.err
+}
+
+// validate calls the Validate method of the struct flagsPtr points to, if it
+// has one.
+func (c *commandFlags) validate(flagsPtr interface{}) error {
+	v, ok := flagsPtr.(interface{ Validate() error })
+	if !ok {
+		return nil
+	}
+	err := v.Validate()
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, ErrUsage) {
+		c.printHelp(c.errOutput)
+	}
+	return validationError{err}
+}
+
+// Validate parses args into a deep copy of the struct flagsPtr points to and
+// discards it, so that the struct is left as is. It returns the error Parse
+// would, taking the environment, the config and the defaults into account.
+// The Set methods of the flag.Value fields run on the copies, side effects
+// beyond the fields themselves are up to the types.
+func (p *Parser) Validate(args []string, flagsPtr interface{}) error {
+	refv := reflect.ValueOf(flagsPtr)
+	if refv.Kind() != reflect.Ptr || refv.Elem().Kind() != reflect.Struct {
+		panic("expect pointer of struct")
+	}
+	scratch := reflect.New(refv.Elem().Type())
+	scratch.Elem().Set(refv.Elem())
+	deepen(scratch.Elem(), make(map[copiedPtr]reflect.Value))
+	// not recorded, the last parse stays the one of SetFields and
+	// LastFlagSet
+	_, _, _, err := p.parse(args, scratch.Interface(), nil)
+	return err
+}
+
+type copiedPtr struct {
+	typ reflect.Type
+	ptr uintptr
+}
+
+// deepen replaces the pointers, slices and maps reachable from the settable
+// fields of v by copies, copied are the copies of the pointers met.
+// Unexported fields are left shared.
+func deepen(v reflect.Value, copied map[copiedPtr]reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			deepen(v.Field(i), copied)
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			deepen(v.Index(i), copied)
+		}
+	case reflect.Ptr:
+		if v.IsNil() || !v.CanSet() {
+			return
+		}
+		key := copiedPtr{v.Type(), v.Pointer()}
+		if c, ok := copied[key]; ok {
+			v.Set(c)
+			return
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(v.Elem())
+		copied[key] = c
+		v.Set(c)
+		deepen(c.Elem(), copied)
+	case reflect.Slice:
+		if v.IsNil() || !v.CanSet() {
+			return
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Cap())
+		reflect.Copy(c, v)
+		v.Set(c)
+		for i := 0; i < c.Len(); i++ {
+			deepen(c.Index(i), copied)
+		}
+	case reflect.Map:
+		if v.IsNil() || !v.CanSet() {
+			return
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			deepen(elem, copied)
+			c.SetMapIndex(iter.Key(), elem)
+		}
+		v.Set(c)
+	}
+}
@@ -0,0 +1,219 @@
+package sflag
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRequiresTag(t *testing.T) {
+	type options struct {
+		Name    string `required:"true"`
+		TLS     bool   `name:"tls" requires:"tls-cert,tls-key"`
+		TLSCert string `name:"tls-cert" alias:"cert"`
+		TLSKey  string `name:"tls-key" env:"REQUIRES_TLS_KEY"`
+		Sign    bool   `name:"sign" requires:"cert"`
+		Auth    bool   `requires:"user"`
+		User    string `default:"admin"`
+	}
+	for _, c := range []struct {
+		args []string
+		env  map[string]string
+		err  string
+	}{
+		{[]string{"-name", "a"}, nil, ""},
+		{[]string{"-name", "a", "-tls", "-tls-cert", "c"}, map[string]string{"REQUIRES_TLS_KEY": "k"}, ""},
+		{[]string{"-tls"}, nil, "missing required flag: -name"},
+		{[]string{"-name", "a", "-tls", "-tls-cert", "c"}, nil, "-tls requires -tls-key"},
+		{[]string{"-name", "a", "-tls", "-auth"}, nil, "-tls requires -tls-cert, -tls-key; -auth requires -user"},
+		{[]string{"-name", "a", "-auth", "-user", "u"}, nil, ""},
+		{[]string{"-name", "a", "-sign"}, nil, "-sign requires -tls-cert"},
+		{[]string{"-name", "a", "-sign", "-cert", "c"}, nil, ""},
+	} {
+		p := &Parser{ErrOutput: ioutil.Discard, LookupEnv: func(key string) (string, bool) {
+			v, ok := c.env[key]
+			return v, ok
+		}}
+		var flags options
+		err := p.Parse(append([]string{"prog"}, c.args...), &flags)
+		if (err == nil && c.err != "") || (err != nil && err.Error() != c.err) {
+			t.Errorf("Parse %q = %v, want %q", c.args, err, c.err)
+		}
+	}
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(error).Error(), "unknown flag -tls-crt in the requires tag of TLS") {
+			t.Errorf("recovered %v, want the unknown flag", r)
+		}
+	}()
+	var typo struct {
+		TLS     bool   `name:"tls" requires:"tls-crt"`
+		TLSCert string `name:"tls-cert"`
+	}
+	_ = Parse([]string{"prog"}, &typo)
+}
+
+type rangeFlags struct {
+	Start int
+	End   int
+	Dir   string `name:"#DIR"`
+}
+
+func (f *rangeFlags) Validate() error {
+	switch {
+	case f.Start < 0:
+		return fmt.Errorf("%w: negative -start", ErrUsage)
+	case f.Start >= f.End:
+		return fmt.Errorf("-start %d must be less than -end %d", f.Start, f.End)
+	}
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	var out bytes.Buffer
+	p := &Parser{Usage: func(printDefaults func(w io.Writer)) { printDefaults(&out) }}
+	var flags rangeFlags
+	if err := p.Parse([]string{"prog", "-start", "1", "-end", "2", "dir"}, &flags); err != nil {
+		t.Errorf("Parse: %v", err)
+	}
+	err := p.Parse([]string{"prog", "-start", "2", "-end", "1", "dir"}, &flags)
+	if err == nil || err.Error() != "-start 2 must be less than -end 1" || out.Len() != 0 {
+		t.Errorf("Parse = %v, help %q, want the error of Validate without help", err, out.String())
+	}
+	flags = rangeFlags{}
+	err = p.Parse([]string{"prog", "-start", "-1", "-end", "1", "dir"}, &flags)
+	if !errors.Is(err, ErrUsage) || !strings.HasPrefix(out.String(), "Usage: prog [OPTION]... DIR") {
+		t.Errorf("Parse = %v, help %q, want the help printed for ErrUsage", err, out.String())
+	}
+
+	var serve rangeFlags
+	err = p.RunCommandE([]string{"prog", "serve", "-start", "3", "dir"}, nil, Command{Name: "serve", Flags: &serve, Run: func([]string) {}})
+	if err == nil || err.Error() != "-start 3 must be less than -end 0" {
+		t.Errorf("RunCommandE = %v, want the error of Validate of the command flags", err)
+	}
+}
+
+func TestOneofRequired(t *testing.T) {
+	type options struct {
+		File   string `oneof-required:"input"`
+		Stdin  bool   `oneof-required:"input"`
+		URL    string `name:"url" oneof-required:"input,remote" default:"x"`
+		Remote string `oneof-required:"remote" requires:"file"`
+	}
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-stdin", "-remote", "r", "-file", "f"}, ""},
+		{[]string{"-url", "u"}, ""},
+		{[]string{"-remote", "r"}, "-remote requires -file; at least one of -file, -stdin, -url is required"},
+		{[]string{}, "at least one of -file, -stdin, -url is required; at least one of -url, -remote is required"},
+	} {
+		var flags options
+		err := Parse(append([]string{"prog"}, c.args...), &flags)
+		if (err == nil && c.err != "") || (err != nil && err.Error() != c.err) {
+			t.Errorf("Parse %q = %v, want %q", c.args, err, c.err)
+		}
+	}
+}
+
+func TestValidateTag(t *testing.T) {
+	type options struct {
+		Port int    `validate:"port,nonzero" default:"8080"`
+		Dir  string `validate:"dir-exists"`
+		URL  string `name:"url" validate:"url"`
+	}
+	p := &Parser{}
+	p.RegisterValidator("port", func(value interface{}) error {
+		if port := value.(int); port < 0 || port > 65535 {
+			return fmt.Errorf("%d out of range", port)
+		}
+		return nil
+	})
+	dir := t.TempDir()
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{}, ""},
+		{[]string{"-dir", dir, "-url", "https://example.com/x"}, ""},
+		{[]string{"-port", "0"}, "flag -port failed validator nonzero: must not be zero"},
+		{[]string{"-port", "70000", "-url", "example.com"},
+			"flag -port failed validator port: 70000 out of range; flag -url failed validator url: not an absolute url"},
+		{[]string{"-dir", dir + "/missing"}, "flag -dir failed validator dir-exists: stat " + dir + "/missing: no such file or directory"},
+	} {
+		var flags options
+		err := p.Parse(append([]string{"prog"}, c.args...), &flags)
+		if (err == nil && c.err != "") || (err != nil && err.Error() != c.err) {
+			t.Errorf("Parse %q = %v, want %q", c.args, err, c.err)
+		}
+	}
+
+	defer func() {
+		if r := recover(); r == nil || fmt.Sprint(r) != "unknown validator even in the validate tag of N" {
+			t.Errorf("recover() = %v", r)
+		}
+	}()
+	var unknown struct {
+		N int `validate:"even"`
+	}
+	_ = (&Parser{}).Parse([]string{"prog"}, &unknown)
+}
+
+// labels is a flag.Value of a map, which a shallow copy shares.
+type labels map[string]string
+
+func (l *labels) String() string { return fmt.Sprint(map[string]string(*l)) }
+
+func (l *labels) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return errors.New("expect key=value")
+	}
+	if *l == nil {
+		*l = make(labels)
+	}
+	(*l)[kv[0]] = kv[1]
+	return nil
+}
+
+func TestParserValidate(t *testing.T) {
+	type options struct {
+		Host   string `name:"host" env:"CHECK_HOST" required:"true"`
+		Port   int    `name:"port" default:"80"`
+		Labels labels `name:"label"`
+		*CommonOpts
+	}
+	common := CommonOpts{Level: 1}
+	flags := options{Labels: labels{"a": "1"}, CommonOpts: &common}
+	orig := options{Labels: labels{"a": "1"}, CommonOpts: &CommonOpts{Level: 1}}
+	p := &Parser{ErrOutput: io.Discard}
+	if err := p.Parse([]string{"prog", "-host", "h", "dir"}, &options{}); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	set := p.SetFields()
+
+	env := map[string]string{"CHECK_HOST": "env"}
+	p.LookupEnv = mapEnv(env)
+	if err := p.Validate([]string{"prog", "-label", "b=2", "-port", "8080", "-level", "3", "dir"}, &flags); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	if !reflect.DeepEqual(flags, orig) || flags.CommonOpts != &common {
+		t.Errorf("Validate changed the flags to %+v", flags)
+	}
+	if !reflect.DeepEqual(p.SetFields(), set) {
+		t.Errorf("SetFields = %v after Validate, want %v of the last parse", p.SetFields(), set)
+	}
+	if err := p.Validate([]string{"prog", "-label", "b", "dir"}, &flags); err == nil || !strings.Contains(err.Error(), "expect key=value") {
+		t.Errorf("Validate = %v, want the error of the invalid label", err)
+	}
+	delete(env, "CHECK_HOST")
+	if err := p.Validate([]string{"prog", "dir"}, &flags); err == nil || err.Error() != "missing required flag: -host" {
+		t.Errorf("Validate = %v, want the required flag missing", err)
+	}
+}
@@ -0,0 +1,85 @@
+package sflag
+
+import (
+	"flag"
+	"strings"
+)
+
+// Flag describes a flag of the last parse.
+type Flag struct {
+	// Command is the name of the command of the flag, empty for the global
+	// flags.
+	Command string
+	Names   []string
+	Usage   string
+	Env     string
+	// Default is the value of the default tag.
+	Default string
+	// Value is the current value of the flag.
+	Value string
+	// Typed is the current value of the flag as the field holds it, such as
+	// an int or a []string, for a flag of PreParse or Adopt it's the value
+	// of its flag.Getter, nil if it isn't one.
+	Typed  interface{}
+	Type   string
+	Hidden bool
+	// Secret is true if the flag is tagged with secret, its Value and
+	// Default are masked then, and Typed is nil.
+	Secret bool
+	// Source is where the value comes from, NoSource if the field is left
+	// as is.
+	Source Source
+}
+
+// IsSet reports whether the value is given by the command line, the
+// environment or a config.
+func (f Flag) IsSet() bool {
+	return f.Source != NoSource && f.Source != DefaultSource
+}
+
+// VisitFlags calls fn for the flags of the last parse in the order of help,
+// hidden flags included. The global flags are visited first, then the flags
+// of the command parsed by ParseCommandFlags or RunCommand.
+func (p *Parser) VisitFlags(fn func(f Flag)) {
+	for _, flags := range p.lastParsed() {
+		var command string
+		if flags.command {
+			command = strings.TrimSuffix(flags.configPrefix, "-")
+		}
+		for _, info := range flags.sortedFlags(true) {
+			f := Flag{
+				Command: command,
+				Usage:   info.Usage,
+				Env:     info.Env,
+				Type:    info.Type,
+				Hidden:  info.Hidden,
+				Secret:  info.Secret,
+			}
+			if info.value == nil {
+				// registered by Parser.PreParse or adopted
+				name := strings.TrimPrefix(info.Name, "-")
+				f.Names = []string{name}
+				if fl := flags.flagSet.Lookup(name); fl != nil {
+					f.Default = fl.DefValue
+					f.Value = fl.Value.String()
+					if g, ok := fl.Value.(flag.Getter); ok {
+						f.Typed = g.Get()
+					}
+				}
+			} else {
+				f.Names = info.value.names
+				if defs := info.value.values[DefaultSource]; len(defs) > 0 {
+					f.Default = defs[0]
+				}
+				f.Value = info.value.String()
+				f.Typed = info.value.Get()
+				f.Source = info.value.source
+			}
+			if f.Secret {
+				f.Default, f.Value = maskSecret(f.Default), maskSecret(f.Value)
+				f.Typed = nil
+			}
+			fn(f)
+		}
+	}
+}